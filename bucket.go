@@ -0,0 +1,201 @@
+package splunker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:generate stringer -type=BucketState
+type BucketState byte
+
+const (
+	BucketStateUnknown BucketState = iota
+	BucketStateHot
+	BucketStateWarm
+	BucketStateCold
+	BucketStateThawed
+)
+
+// Bucket describes a bucket directory of an index.
+type Bucket struct {
+	Path  string
+	Index string
+	State BucketState
+	ID    uint64
+	// GUID of the peer that created the bucket, only set for clustered buckets
+	GUID string
+	// Replicated is true for copies that were streamed from another peer (rb_)
+	Replicated bool
+	// Newest and Oldest event time in the bucket, only known for non hot buckets
+	Newest time.Time
+	Oldest time.Time
+}
+
+// SplunkID returns the id of the bucket as used by Splunk in the _bkt field,
+// <index>~<id>~<guid> or <index>~<id> for buckets without guid.
+func (b Bucket) SplunkID() string {
+	id := b.Index + "~" + strconv.FormatUint(b.ID, 10)
+	if b.GUID != "" {
+		id += "~" + b.GUID
+	}
+	return id
+}
+
+// ParseBucket parses the information encoded in the path of a bucket.
+// Known names are hot_v1_<id>, db_<newest>_<oldest>_<id>[_<guid>] and
+// rb_<newest>_<oldest>_<id>_<guid>.
+func ParseBucket(path string) (Bucket, error) {
+	b := Bucket{
+		Path: path,
+	}
+
+	parent := filepath.Dir(path)
+	b.Index = indexName(filepath.Base(filepath.Dir(parent)))
+
+	name := filepath.Base(path)
+	parts := strings.Split(name, "_")
+
+	var err error
+	switch {
+	case parts[0] == "hot" && len(parts) == 3:
+		b.State = BucketStateHot
+		b.ID, err = strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			return b, fmt.Errorf("invalid bucket id %q: %v", name, err)
+		}
+		return b, nil
+	case (parts[0] == "db" || parts[0] == "rb") && (len(parts) == 4 || len(parts) == 5):
+		b.Replicated = parts[0] == "rb"
+	default:
+		return b, fmt.Errorf("unknown bucket name %q", name)
+	}
+
+	switch filepath.Base(parent) {
+	case "db":
+		b.State = BucketStateWarm
+	case "colddb":
+		b.State = BucketStateCold
+	case "thaweddb":
+		b.State = BucketStateThawed
+	}
+
+	newest, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return b, fmt.Errorf("invalid bucket newest time %q: %v", name, err)
+	}
+	b.Newest = time.Unix(newest, 0)
+
+	oldest, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return b, fmt.Errorf("invalid bucket oldest time %q: %v", name, err)
+	}
+	b.Oldest = time.Unix(oldest, 0)
+
+	b.ID, err = strconv.ParseUint(parts[3], 10, 64)
+	if err != nil {
+		return b, fmt.Errorf("invalid bucket id %q: %v", name, err)
+	}
+
+	if len(parts) == 5 {
+		b.GUID = parts[4]
+	}
+
+	return b, nil
+}
+
+// defaultIndexDirs maps the directories of the default indexes to their names
+var defaultIndexDirs = map[string]string{
+	"defaultdb":      "main",
+	"_internaldb":    "_internal",
+	"audit":          "_audit",
+	"fishbucket":     "_thefishbucket",
+	"historydb":      "history",
+	"summarydb":      "summary",
+	"_metrics":       "_metrics",
+	"_telemetry":     "_telemetry",
+	"_introspection": "_introspection",
+}
+
+func indexName(dir string) string {
+	if n, ok := defaultIndexDirs[dir]; ok {
+		return n
+	}
+	return dir
+}
+
+// Index is a Splunk index directory containing the db, colddb and
+// thaweddb bucket directories.
+type Index struct {
+	Name    string
+	Path    string
+	buckets []Bucket
+}
+
+// OpenIndex collects the buckets of the index at path.
+func OpenIndex(path string) (*Index, error) {
+	idx := &Index{
+		Name: indexName(filepath.Base(path)),
+		Path: path,
+	}
+
+	found := false
+	for _, dir := range []string{"db", "colddb", "thaweddb"} {
+		buckets, err := readBucketDir(filepath.Join(path, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+
+		for _, b := range buckets {
+			b.Index = idx.Name
+			idx.buckets = append(idx.buckets, b)
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%s: no bucket directories found: %w", path, os.ErrNotExist)
+	}
+
+	return idx, nil
+}
+
+// Buckets returns the buckets of the index.
+func (i *Index) Buckets() []Bucket {
+	return i.buckets
+}
+
+// readBucketDir returns all buckets in dir ordered by id.
+// Directories that are not buckets are skipped.
+func readBucketDir(dir string) ([]Bucket, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []Bucket
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		b, err := ParseBucket(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		buckets = append(buckets, b)
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].ID < buckets[j].ID
+	})
+
+	return buckets, nil
+}
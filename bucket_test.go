@@ -0,0 +1,62 @@
+package splunker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBucket(t *testing.T) {
+	b, err := ParseBucket("/opt/splunk/var/lib/splunk/defaultdb/db/db_1680000000_1670000000_42")
+	require.NoError(t, err)
+	assert.Equal(t, "main", b.Index)
+	assert.Equal(t, BucketStateWarm, b.State)
+	assert.Equal(t, uint64(42), b.ID)
+	assert.Equal(t, time.Unix(1680000000, 0), b.Newest)
+	assert.Equal(t, time.Unix(1670000000, 0), b.Oldest)
+	assert.False(t, b.Replicated)
+
+	b, err = ParseBucket("/data/web/colddb/rb_1680000000_1670000000_7_9A1B2C3D-0000-4E5F-8000-000000000001")
+	require.NoError(t, err)
+	assert.Equal(t, "web", b.Index)
+	assert.Equal(t, BucketStateCold, b.State)
+	assert.Equal(t, uint64(7), b.ID)
+	assert.Equal(t, "9A1B2C3D-0000-4E5F-8000-000000000001", b.GUID)
+	assert.True(t, b.Replicated)
+
+	b, err = ParseBucket("/data/web/db/hot_v1_3")
+	require.NoError(t, err)
+	assert.Equal(t, BucketStateHot, b.State)
+	assert.Equal(t, uint64(3), b.ID)
+
+	_, err = ParseBucket("/data/web/db/GlobalMetaData")
+	assert.Error(t, err)
+}
+
+func TestGroupBuckets(t *testing.T) {
+	var buckets []Bucket
+	for _, p := range []string{
+		"/peer1/web/db/rb_20_10_1_AAAA",
+		"/peer2/web/db/db_20_10_1_AAAA",
+		"/peer3/web/colddb/rb_20_10_1_AAAA",
+		"/peer1/web/db/db_30_21_2_BBBB",
+		"/peer1/web/db/db_30_21_3",
+		"/peer2/web/db/db_30_21_3",
+	} {
+		b, err := ParseBucket(p)
+		require.NoError(t, err)
+		buckets = append(buckets, b)
+	}
+
+	groups := GroupBuckets(buckets)
+	require.Len(t, groups, 4)
+
+	assert.Len(t, groups[0].Copies, 3)
+	assert.Equal(t, "/peer2/web/db/db_20_10_1_AAAA", groups[0].Primary().Path)
+	assert.Len(t, groups[1].Copies, 1)
+	// buckets without guid are never copies of each other
+	assert.Len(t, groups[2].Copies, 1)
+	assert.Len(t, groups[3].Copies, 1)
+}
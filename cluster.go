@@ -0,0 +1,219 @@
+package splunker
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// BucketGroup is a set of copies of the same bucket, e.g. the db_ and rb_
+// copies of a clustered bucket stored on different peers.
+type BucketGroup struct {
+	Index string
+	ID    uint64
+	GUID  string
+	// Copies of the bucket, the preferred copy is the first one
+	Copies []Bucket
+}
+
+// Primary returns the preferred copy of the bucket.
+func (g BucketGroup) Primary() Bucket {
+	return g.Copies[0]
+}
+
+// Clean reports whether all copies are complete and cover the same time range,
+// so reading a single copy yields all events of the bucket.
+func (g BucketGroup) Clean() bool {
+	p := g.Copies[0]
+	for _, c := range g.Copies {
+		if c.State == BucketStateHot || !c.Newest.Equal(p.Newest) || !c.Oldest.Equal(p.Oldest) {
+			return false
+		}
+		if _, err := os.Stat(filepath.Join(c.Path, "rawdata", "journal.zst")); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// All returns an iterator over the events of the bucket. A clean group is
+// read from its primary copy only. Otherwise all copies are read and
+// duplicated events are dropped, see Dedup.
+func (g BucketGroup) All(opts ...Option) iter.Seq2[Event, error] {
+	return g.AllContext(context.Background(), opts...)
+}
+
+// AllContext is like All, but stops once ctx is done.
+func (g BucketGroup) AllContext(ctx context.Context, opts ...Option) iter.Seq2[Event, error] {
+	if len(g.Copies) == 1 || g.Clean() {
+		return g.Primary().AllContext(ctx, opts...)
+	}
+
+	return Dedup(func(yield func(Event, error) bool) {
+		for _, c := range g.Copies {
+			for e, err := range c.AllContext(ctx, opts...) {
+				// a damaged copy can not be read, the others might be fine
+				if err != nil && os.IsNotExist(err) {
+					break
+				}
+				if !yield(e, err) || err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+// GroupBuckets groups the copies of clustered buckets by index, id and guid.
+// Buckets without a guid are not replicated and form a group of their own.
+// Primary copies (db_) are preferred over replicated ones (rb_).
+func GroupBuckets(buckets []Bucket) []BucketGroup {
+	type key struct {
+		index string
+		id    uint64
+		guid  string
+		// path keeps buckets without guid apart
+		path string
+	}
+
+	var groups []BucketGroup
+	byKey := make(map[key]int)
+	for _, b := range buckets {
+		k := key{index: b.Index, id: b.ID, guid: b.GUID}
+		if b.GUID == "" {
+			k.path = b.Path
+		}
+
+		i, ok := byKey[k]
+		if !ok {
+			i = len(groups)
+			byKey[k] = i
+			groups = append(groups, BucketGroup{Index: b.Index, ID: b.ID, GUID: b.GUID})
+		}
+		groups[i].Copies = append(groups[i].Copies, b)
+	}
+
+	for _, g := range groups {
+		sort.SliceStable(g.Copies, func(i, j int) bool {
+			return copyRank(g.Copies[i]) < copyRank(g.Copies[j])
+		})
+	}
+
+	return groups
+}
+
+// copyRank orders the copies of a bucket, lower is preferred
+func copyRank(b Bucket) int {
+	r := 0
+	if b.Replicated {
+		r += 2
+	}
+	if b.State == BucketStateHot {
+		r += 4
+	}
+	return r
+}
+
+// ClusterIndex is an index spread over the peers of an indexer cluster.
+type ClusterIndex struct {
+	Name   string
+	groups []BucketGroup
+}
+
+// OpenClusterIndex collects the buckets of the same index stored at paths,
+// usually one path per peer, and groups their copies.
+func OpenClusterIndex(paths ...string) (*ClusterIndex, error) {
+	var buckets []Bucket
+	c := &ClusterIndex{}
+	for _, p := range paths {
+		idx, err := OpenIndex(p)
+		if err != nil {
+			return nil, err
+		}
+		if c.Name == "" {
+			c.Name = idx.Name
+		} else if c.Name != idx.Name {
+			return nil, fmt.Errorf("%s: index %q does not match %q", p, idx.Name, c.Name)
+		}
+
+		buckets = append(buckets, idx.Buckets()...)
+	}
+
+	c.groups = GroupBuckets(buckets)
+
+	return c, nil
+}
+
+// Groups returns the buckets of the index grouped by their copies.
+func (c *ClusterIndex) Groups() []BucketGroup {
+	return c.groups
+}
+
+// All returns an iterator over the events of all bucket groups,
+// reading every event once. See BucketGroup.All.
+func (c *ClusterIndex) All(opts ...Option) iter.Seq2[Event, error] {
+	return c.AllContext(context.Background(), opts...)
+}
+
+// AllContext is like All, but stops once ctx is done.
+func (c *ClusterIndex) AllContext(ctx context.Context, opts ...Option) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for _, g := range c.groups {
+			for e, err := range g.AllContext(ctx, opts...) {
+				if err != nil && os.IsNotExist(err) {
+					break
+				}
+				if !yield(e, err) || err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// dedupKey identifies an event by its position in the input stream
+// and its hash, if the journal stores one
+type dedupKey struct {
+	hash            [hashSize]byte
+	streamID        uint64
+	streamOffset    uint64
+	streamSubOffset uint64
+}
+
+// Dedup returns an iterator over the events of seq that drops events
+// seen before. Events are identified by streamID, streamOffset and
+// streamSubOffset together with their hash, if the journal stores one.
+// Events with equal hash, e.g. the same line sent twice, are kept.
+//
+// Every event is remembered, so seq should be limited to the copies
+// of a single bucket.
+func Dedup(seq iter.Seq2[Event, error]) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		seen := make(map[dedupKey]struct{})
+		for e, err := range seq {
+			if err == nil {
+				k := dedupKey{
+					streamID:        e.streamID,
+					streamOffset:    e.streamOffset,
+					streamSubOffset: e.streamSubOffset,
+				}
+				if e.hasHash {
+					k.hash = e.hash
+				}
+
+				if _, ok := seen[k]; ok {
+					continue
+				}
+				seen[k] = struct{}{}
+			}
+
+			if !yield(e, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package splunker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCopy writes events as single bucket of the index web at peer and
+// renames it to the clustered bucket name with prefix db or rb
+func writeCopy(t *testing.T, peer, prefix string, events []EventInfo) string {
+	t.Helper()
+
+	dir := filepath.Join(peer, "web")
+	w, err := NewIndexWriter(dir)
+	require.NoError(t, err)
+	for _, info := range events {
+		require.NoError(t, w.WriteEvent(NewEvent(info)))
+	}
+	require.NoError(t, w.Close())
+
+	matches, err := filepath.Glob(filepath.Join(dir, "db", "db_*"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+
+	name := prefix + strings.TrimPrefix(filepath.Base(matches[0]), "db") + "_9A1B2C3D-0000-4E5F-8000-000000000001"
+	require.NoError(t, os.Rename(matches[0], filepath.Join(dir, "db", name)))
+	return dir
+}
+
+func clusterEvents(n int) []EventInfo {
+	var events []EventInfo
+	for i := 0; i < n; i++ {
+		events = append(events, EventInfo{
+			Time:         time.Unix(1700000000+int64(i), 0),
+			Host:         "web01",
+			Source:       "/var/log/app.log",
+			SourceType:   "app",
+			Raw:          []byte(fmt.Sprintf("event %d", i)),
+			StreamID:     1,
+			StreamOffset: uint64(i) * 10,
+			// the same line sent repeatedly has the same hash
+			HasHash: true,
+			Hash:    [hashSize]byte{42},
+		})
+	}
+	return events
+}
+
+func readMessages(t *testing.T, g BucketGroup) []string {
+	t.Helper()
+
+	var got []string
+	for e, err := range g.All() {
+		require.NoError(t, err)
+		got = append(got, string(e.Message()))
+	}
+	return got
+}
+
+func TestBucketGroupDedup(t *testing.T) {
+	root := t.TempDir()
+	events := clusterEvents(10)
+	// the primary copy misses the events that were replicated last
+	primary := writeCopy(t, filepath.Join(root, "peer1"), "db", events[:6])
+	replica := writeCopy(t, filepath.Join(root, "peer2"), "rb", events)
+
+	c, err := OpenClusterIndex(replica, primary)
+	require.NoError(t, err)
+	groups := c.Groups()
+	require.Len(t, groups, 1)
+	g := groups[0]
+	require.Len(t, g.Copies, 2)
+	assert.Equal(t, "9A1B2C3D-0000-4E5F-8000-000000000001", g.GUID)
+	assert.False(t, g.Primary().Replicated)
+	assert.False(t, g.Clean())
+
+	var want []string
+	for _, info := range events {
+		want = append(want, string(info.Raw))
+	}
+	assert.Equal(t, want, readMessages(t, g))
+}
+
+func TestBucketGroupClean(t *testing.T) {
+	root := t.TempDir()
+	events := clusterEvents(10)
+	primary := writeCopy(t, filepath.Join(root, "peer1"), "db", events)
+	replica := writeCopy(t, filepath.Join(root, "peer2"), "rb", events)
+
+	c, err := OpenClusterIndex(primary, replica)
+	require.NoError(t, err)
+	require.Len(t, c.Groups(), 1)
+	g := c.Groups()[0]
+	require.True(t, g.Clean())
+
+	// a clean group is read from the primary copy only
+	require.NoError(t, os.WriteFile(filepath.Join(g.Copies[1].Path, "rawdata", "journal.zst"), []byte("garbage"), 0o644))
+	assert.Len(t, readMessages(t, g), len(events))
+}
+
+func TestDedup(t *testing.T) {
+	events := []Event{
+		NewEvent(EventInfo{Raw: []byte("a"), StreamID: 1, StreamOffset: 0, HasHash: true, Hash: [hashSize]byte{1}}),
+		// same hash at another position
+		NewEvent(EventInfo{Raw: []byte("b"), StreamID: 1, StreamOffset: 10, HasHash: true, Hash: [hashSize]byte{1}}),
+		// same position in another stream
+		NewEvent(EventInfo{Raw: []byte("c"), StreamID: 2, StreamOffset: 0, HasHash: true, Hash: [hashSize]byte{1}}),
+		NewEvent(EventInfo{Raw: []byte("d"), StreamID: 1, StreamOffset: 10, StreamSubOffset: 1}),
+		// duplicates
+		NewEvent(EventInfo{Raw: []byte("a"), StreamID: 1, StreamOffset: 0, HasHash: true, Hash: [hashSize]byte{1}}),
+		NewEvent(EventInfo{Raw: []byte("d"), StreamID: 1, StreamOffset: 10, StreamSubOffset: 1}),
+	}
+	failure := errors.New("failure")
+
+	var (
+		got  []string
+		errs []error
+	)
+	for e, err := range Dedup(func(yield func(Event, error) bool) {
+		for _, e := range events {
+			if !yield(e, nil) {
+				return
+			}
+		}
+		yield(Event{}, failure)
+	}) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		got = append(got, e.MessageString())
+	}
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, got)
+	assert.Equal(t, []error{failure}, errs)
+}
+
+func TestGroupReader(t *testing.T) {
+	root := t.TempDir()
+	events := clusterEvents(10)
+	primary := writeCopy(t, filepath.Join(root, "peer1"), "db", events[:6])
+	replica := writeCopy(t, filepath.Join(root, "peer2"), "rb", events[3:])
+
+	c, err := OpenClusterIndex(primary, replica)
+	require.NoError(t, err)
+
+	r := NewGroupReader(c.Groups(), WithWorkers(2), WithTimeOrder(), WithMaxBufferedEvents(4), WithSpillDir(t.TempDir()))
+	var got []string
+	for e, err := range r.All() {
+		require.NoError(t, err)
+		got = append(got, e.MessageString())
+	}
+
+	var want []string
+	for _, info := range events {
+		want = append(want, string(info.Raw))
+	}
+	assert.Equal(t, want, got)
+}
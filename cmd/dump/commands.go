@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/s2s"
+)
+
+func runCat(ctx context.Context, args []string) error {
+	var sel selection
+	fs := newFlagSet("cat", &sel)
+	_ = fs.Parse(args)
+
+	return export(ctx, &sel, "raw", "")
+}
+
+func runExport(ctx context.Context, args []string) error {
+	var sel selection
+	fs := newFlagSet("export", &sel)
+	formatName := fs.String("format", "raw", "output format, one of: "+formatNames())
+	out := fs.String("o", "", "output file, defaults to stdout")
+	for _, f := range formats {
+		if f.flags != nil {
+			f.flags(fs)
+		}
+	}
+	_ = fs.Parse(args)
+
+	return export(ctx, &sel, *formatName, *out)
+}
+
+func export(ctx context.Context, sel *selection, formatName, out string) error {
+	events, err := sel.events(ctx)
+	if err != nil {
+		return err
+	}
+
+	w, closeOut, err := openOutput(formatName, out)
+	if err != nil {
+		return err
+	}
+
+	for e, err := range events {
+		if err != nil {
+			_ = closeOut()
+			return err
+		}
+
+		if err := w.WriteEvent(e); err != nil {
+			_ = closeOut()
+			return err
+		}
+	}
+
+	return closeOut()
+}
+
+// counter aggregates the events of a host, source, ...
+type counter struct {
+	events   int
+	bytes    int64
+	earliest time.Time
+	latest   time.Time
+}
+
+func (c *counter) add(e splunker.Event) {
+	t := e.Time()
+	if c.events == 0 || t.Before(c.earliest) {
+		c.earliest = t
+	}
+	if c.events == 0 || t.After(c.latest) {
+		c.latest = t
+	}
+	c.events++
+	c.bytes += int64(len(e.Message()))
+}
+
+// count aggregates the selected events by the key returned by key
+func count(ctx context.Context, sel *selection, key func(e splunker.Event) string) (map[string]*counter, error) {
+	events, err := sel.events(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make(map[string]*counter)
+	for e, err := range events {
+		if err != nil {
+			return nil, err
+		}
+
+		k := key(e)
+		c, ok := counters[k]
+		if !ok {
+			c = &counter{}
+			counters[k] = c
+		}
+		c.add(e)
+	}
+
+	return counters, nil
+}
+
+func printCounters(header string, counters map[string]*counter) error {
+	keys := make([]string, 0, len(counters))
+	for k := range counters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tEVENTS\tBYTES\tEARLIEST\tLATEST\n", header)
+	for _, k := range keys {
+		c := counters[k]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", k, c.events, c.bytes,
+			c.earliest.Format(time.RFC3339), c.latest.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func runStats(ctx context.Context, args []string) error {
+	var sel selection
+	fs := newFlagSet("stats", &sel)
+	_ = fs.Parse(args)
+
+	counters, err := count(ctx, &sel, func(e splunker.Event) string {
+		return e.Bucket().Index + "\t" + e.Host() + "\t" + e.SourceType()
+	})
+	if err != nil {
+		return err
+	}
+
+	return printCounters("INDEX\tHOST\tSOURCETYPE", counters)
+}
+
+func runHosts(ctx context.Context, args []string) error {
+	var sel selection
+	fs := newFlagSet("hosts", &sel)
+	_ = fs.Parse(args)
+
+	counters, err := count(ctx, &sel, splunker.Event.Host)
+	if err != nil {
+		return err
+	}
+
+	return printCounters("HOST", counters)
+}
+
+func runSources(ctx context.Context, args []string) error {
+	var sel selection
+	fs := newFlagSet("sources", &sel)
+	_ = fs.Parse(args)
+
+	counters, err := count(ctx, &sel, splunker.Event.Source)
+	if err != nil {
+		return err
+	}
+
+	return printCounters("SOURCE", counters)
+}
+
+func runVerify(ctx context.Context, args []string) error {
+	var sel selection
+	fs := newFlagSet("verify", &sel)
+	_ = fs.Parse(args)
+
+	buckets, err := sel.selectBuckets()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, b := range buckets {
+		events := 0
+		var err error
+		for _, err = range b.AllContext(ctx) {
+			if err != nil {
+				break
+			}
+			events++
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case os.IsNotExist(err):
+			fmt.Printf("%s: no journal\n", b.Path)
+		case err != nil:
+			failed++
+			fmt.Printf("%s: FAILED after %d events: %v\n", b.Path, events, err)
+		default:
+			fmt.Printf("%s: OK, %d events\n", b.Path, events)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d buckets failed", failed, len(buckets))
+	}
+
+	return nil
+}
+
+func runBuckets(_ context.Context, args []string) error {
+	var sel selection
+	fs := newFlagSet("buckets", &sel)
+	_ = fs.Parse(args)
+
+	buckets, err := sel.selectBuckets()
+	if err != nil {
+		return err
+	}
+
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "INDEX\tID\tSTATE\tOLDEST\tNEWEST\tGUID\tPATH\n")
+	for _, b := range buckets {
+		state := b.State.String()[len("BucketState"):]
+		if b.Replicated {
+			state += " (replicated)"
+		}
+
+		guid := b.GUID
+		if guid == "" {
+			guid = "-"
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", b.Index, b.ID, state,
+			formatTime(b.Oldest), formatTime(b.Newest), guid, b.Path)
+	}
+
+	return w.Flush()
+}
+
+func runInspect(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	limit := fs.Int("n", 0, "only print the first n events of every bucket")
+	private := fs.Bool("private", false, "print the OpcodeSplunkPrivate payloads")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: inspect [flags] <bucket>...\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	var opts []splunker.Option
+	if *private {
+		opts = append(opts, splunker.WithPrivateHandler(func(p splunker.SplunkPrivate) error {
+			fmt.Fprintf(w, "private @%d: %d bytes\n%s", p.Offset, len(p.Data), hex.Dump(p.Data))
+			return nil
+		}))
+	}
+
+	for _, p := range fs.Args() {
+		// unknown bucket names can be inspected all the same
+		b, _ := splunker.ParseBucket(filepath.Clean(p))
+		fmt.Fprintf(w, "bucket %s: index %s, id %d, state %s\n", b.Path, b.Index, b.ID, b.State)
+
+		n := 0
+		for e, err := range b.AllContext(ctx, opts...) {
+			if err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+
+			fmt.Fprintf(w, "host: %s - source: %s - sourcetype: %s - time: %s - %s\n",
+				e.Host(), e.Source(), e.SourceType(), e.Time().Format(time.RFC3339Nano), e)
+
+			if n++; *limit > 0 && n >= *limit {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func runRestore(ctx context.Context, args []string) error {
+	var sel selection
+	fs := newFlagSet("restore", &sel)
+	out := fs.String("o", "restored", "directory to write the files to")
+	gapMarker := fs.String("gap-marker", "", "write this line at every gap, %d verbs receive offset and length")
+	_ = fs.Parse(args)
+
+	events, err := sel.events(ctx)
+	if err != nil {
+		return err
+	}
+
+	var opts []splunker.RestoreOption
+	if *gapMarker != "" {
+		opts = append(opts, splunker.WithGapMarker(*gapMarker))
+	}
+
+	restored, err := splunker.RestoreSources(*out, events, opts...)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "PATH\tEVENTS\tBYTES\tGAPS\n")
+	for _, r := range restored {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", r.Path, r.Events, r.Bytes, len(r.Gaps))
+	}
+
+	return w.Flush()
+}
+
+func runReceive(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("receive", flag.ExitOnError)
+	listen := fs.String("listen", ":9997", "address to accept forwarder connections on")
+	out := fs.String("o", "splunkdb", "directory to create the index directories in")
+	defaultIndex := fs.String("default-index", "main", "index of events without a valid index")
+	maxSize := fs.Int64("max-bucket-size", 750<<20, "roll buckets at this uncompressed journal size in bytes")
+	maxSpan := fs.Duration("max-bucket-span", 90*24*time.Hour, "roll buckets before their events span more than this")
+	maxAge := fs.Duration("max-bucket-age", 0, "roll buckets after they were written to for this long")
+	certFile := fs.String("tls-cert", "", "certificate file, enables TLS together with -tls-key")
+	keyFile := fs.String("tls-key", "", "key file of the certificate")
+	_ = fs.Parse(args)
+
+	var tlsConfig *tls.Config
+	if *certFile != "" || *keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	r, err := s2s.NewReceiver(*out,
+		s2s.WithDefaultIndex(*defaultIndex),
+		s2s.WithIndexOptions(
+			splunker.WithMaxBucketSize(*maxSize),
+			splunker.WithMaxBucketSpan(*maxSpan),
+			splunker.WithMaxBucketAge(*maxAge),
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- r.ListenAndServe(*listen, tlsConfig) }()
+
+	select {
+	case <-ctx.Done():
+	case err := <-served:
+		_ = r.Close()
+		return err
+	}
+
+	if err := r.Close(); err != nil {
+		return err
+	}
+	if err := <-served; !errors.Is(err, s2s.ErrReceiverClosed) {
+		return err
+	}
+
+	return nil
+}
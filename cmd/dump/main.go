@@ -1,83 +1,81 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
-	"path/filepath"
-
-	"github.com/fionera/splunker"
+	"os/signal"
+	"sort"
 )
 
-const baseDir = "./adsb_bratwurst"
-
-func main() {
-	go http.ListenAndServe(":8080", nil)
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
 
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	if err := OpenDB(baseDir); err != nil {
-		log.Fatal(err)
-	}
+var commands = map[string]command{
+	"cat":     {"print the _raw of all events", runCat},
+	"export":  {"write the events in one of the output formats", runExport},
+	"stats":   {"count events and bytes per index, host and sourcetype", runStats},
+	"verify":  {"decode all buckets and report the broken ones", runVerify},
+	"hosts":   {"list the hosts and their event counts", runHosts},
+	"sources": {"list the sources and their event counts", runSources},
+	"buckets": {"list the selected buckets", runBuckets},
+	"inspect": {"print the decoded entries of a bucket", runInspect},
+	"restore": {"write the events back into their original source files", runRestore},
+	"receive": {"accept Splunk-to-Splunk connections and write the events into buckets", runReceive},
 }
 
-func OpenDB(p string) error {
-	dirs := []string{
-		filepath.Join(p, "db"),
-		filepath.Join(p, "colddb"),
-	}
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] <command> [command flags]\n\nCommands:\n", os.Args[0])
 
-	for _, dir := range dirs {
-		if err := readBuckets(dir); err != nil && !os.IsNotExist(err) {
-			return err
-		}
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].usage)
 	}
 
-	return nil
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
 }
 
-func readBuckets(name string) error {
-	dir, err := os.ReadDir(name)
-	if err != nil {
-		return err
-	}
+func main() {
+	pprofAddr := flag.String("pprof", "", "serve pprof on this address, e.g. localhost:6060")
+	flag.Usage = usage
+	flag.Parse()
 
-	for _, e := range dir {
-		if !e.IsDir() {
-			continue
-		}
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-		p := filepath.Join(name, e.Name())
-		decoder, err := splunker.NewJournalDecoder(p)
-		if err != nil {
-			if os.IsNotExist(err) {
-				continue
-			}
-			return fmt.Errorf("NewJournalDecoder(%q): %v", p, err)
-		}
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
 
-		if err := runDecode(decoder); err != nil {
-			return fmt.Errorf("runDecode: %q: %v", p, err)
-		}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
 	}
 
-	return nil
-}
+	if *pprofAddr != "" {
+		go func() {
+			log.Println(http.ListenAndServe(*pprofAddr, nil))
+		}()
+	}
 
-func runDecode(d *splunker.JournalDecoder) error {
-	var e splunker.Event
-	var w io.Writer = bufio.NewWriterSize(os.Stdout, 4*1024*1024)
-	for d.Scan() {
-		e = d.Event()
-		_ = e
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
 
-		_, _ = w.Write(e.Message())
-		//fmt.Println(e.MessageString())
-		//fmt.Println(fmt.Sprintf("%s - %s - %s: %s", d.Host(), d.SourceType(), d.Source(), e.MessageString()))
+	if err := cmd.run(ctx, flag.Args()[1:]); err != nil {
+		log.Fatal(err)
 	}
-
-	return d.Err()
 }
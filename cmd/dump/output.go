@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/arrow"
+	"github.com/fionera/splunker/sink/elastic"
+	"github.com/fionera/splunker/sink/exporttool"
+	"github.com/fionera/splunker/sink/fluent"
+	"github.com/fionera/splunker/sink/hec"
+	"github.com/fionera/splunker/sink/hive"
+	"github.com/fionera/splunker/sink/jsonl"
+	"github.com/fionera/splunker/sink/loki"
+	"github.com/fionera/splunker/sink/otlp"
+	"github.com/fionera/splunker/sink/parquet"
+	"github.com/fionera/splunker/sink/s2s"
+	"github.com/fionera/splunker/sink/sqlite"
+	"github.com/fionera/splunker/sink/syslog"
+	"github.com/fionera/splunker/sink/text"
+)
+
+type format struct {
+	usage string
+	// flags registers the flags of the format, may be nil
+	flags func(fs *flag.FlagSet)
+	// createsOutput formats create -o themselves, e.g. a directory
+	// or a database, instead of writing to it as file
+	createsOutput bool
+	// new creates the writer. out is the value of -o, w writes to
+	// it or to stdout if it is empty.
+	new func(w io.Writer, out string) (splunker.Sink, error)
+}
+
+var formats = map[string]format{
+	"raw": {
+		usage: "the _raw of every event followed by a newline",
+		new: func(w io.Writer, _ string) (splunker.Sink, error) {
+			return &rawWriter{w: bufio.NewWriterSize(w, 4*1024*1024)}, nil
+		},
+	},
+	"json": {
+		usage: "JSON Lines with the metadata and indexed fields of every event",
+		new: func(w io.Writer, _ string) (splunker.Sink, error) {
+			return jsonl.NewWriter(w), nil
+		},
+	},
+	"csv": {
+		usage: "CSV in the layout of splunk cmd exporttool -csv",
+		new: func(w io.Writer, _ string) (splunker.Sink, error) {
+			return exporttool.NewWriter(w), nil
+		},
+	},
+	"template": {
+		usage: "every event rendered through the text/template -template",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&templateText, "template", text.DefaultTemplate, "template executed for every event, see package sink/text for the fields and functions")
+			fs.StringVar(&templateFile, "template.file", "", "read the template from this file instead of -template")
+		},
+		new: func(w io.Writer, _ string) (splunker.Sink, error) {
+			if templateFile != "" {
+				b, err := os.ReadFile(templateFile)
+				if err != nil {
+					return nil, err
+				}
+				templateText = string(b)
+			}
+			tmpl, err := text.Parse(templateText)
+			if err != nil {
+				return nil, err
+			}
+			return text.NewWriter(w, tmpl), nil
+		},
+	},
+	"parquet": {
+		usage: "Apache Parquet with a column per metadata field and a map of the indexed fields",
+		flags: func(fs *flag.FlagSet) {
+			fs.Int64Var(&parquetConfig.RowGroupSize, "parquet.row-group", 128<<20, "approximate uncompressed size of a row group in bytes")
+			fs.StringVar(&parquetCompression, "parquet.compression", "zstd", "column compression, zstd, snappy, gzip or none")
+		},
+		new: func(w io.Writer, _ string) (splunker.Sink, error) {
+			var err error
+			if parquetConfig.Compression, err = parquet.ParseCompression(parquetCompression); err != nil {
+				return nil, err
+			}
+			return parquet.NewWriter(w, parquetConfig)
+		},
+	},
+	"arrow": {
+		usage: "Apache Arrow IPC stream of record batches, e.g. for DuckDB or pandas",
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&arrowConfig.BatchSize, "arrow.batch", 65536, "number of rows per record batch")
+		},
+		new: func(w io.Writer, _ string) (splunker.Sink, error) {
+			return arrow.NewWriter(w, arrowConfig), nil
+		},
+	},
+	"sqlite": {
+		usage: "SQLite database -o with events, indexed_fields and buckets tables",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&sqliteConfig.FullText, "sqlite.fts", false, "create an FTS5 full text index over _raw")
+			fs.IntVar(&sqliteConfig.BatchSize, "sqlite.batch", 10000, "number of events inserted per transaction")
+		},
+		createsOutput: true,
+		new: func(_ io.Writer, out string) (splunker.Sink, error) {
+			if out == "" || out == "-" {
+				return nil, fmt.Errorf("the sqlite format requires a database file as -o")
+			}
+			sqliteConfig.Path = out
+			return sqlite.Open(sqliteConfig)
+		},
+	},
+	"hec": {
+		usage: "send the events to the HTTP Event Collector at -hec.url",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&hecConfig.URL, "hec.url", "", "URL of the HTTP Event Collector, e.g. https://splunk:8088")
+			fs.StringVar(&hecConfig.Token, "hec.token", "", "HEC token")
+			fs.StringVar(&hecConfig.Index, "hec.index", "", "send the events to this index instead of their own")
+			fs.IntVar(&hecConfig.BatchSize, "hec.batch", 1000, "maximum number of events per request")
+			fs.BoolVar(&hecConfig.Gzip, "hec.gzip", true, "compress the requests")
+			fs.BoolVar(&hecConfig.Ack, "hec.ack", false, "wait for the indexer acknowledgement")
+			fs.BoolVar(&hecInsecure, "hec.insecure", false, "do not verify the TLS certificate")
+		},
+		new: func(io.Writer, string) (splunker.Sink, error) {
+			if hecInsecure {
+				hecConfig.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+			}
+			return hec.New(hecConfig)
+		},
+	},
+	"s2s": {
+		usage: "forward the events with the Splunk-to-Splunk protocol to -s2s.addr",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&s2sAddr, "s2s.addr", "", "address of the receiving Splunk instance, e.g. splunk:9997")
+			fs.StringVar(&s2sConfig.Index, "s2s.index", "", "send the events to this index instead of their own")
+			fs.BoolVar(&s2sTLS, "s2s.tls", false, "connect with TLS")
+			fs.BoolVar(&s2sInsecure, "s2s.insecure", false, "do not verify the TLS certificate")
+		},
+		new: func(io.Writer, string) (splunker.Sink, error) {
+			if s2sTLS {
+				s2sConfig.TLSConfig = &tls.Config{InsecureSkipVerify: s2sInsecure}
+			}
+			return s2s.Dial(s2sAddr, s2sConfig)
+		},
+	},
+	"otlp": {
+		usage: "export the events as OpenTelemetry log records to the OTLP/HTTP collector at -otlp.url",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&otlpConfig.URL, "otlp.url", "", "URL of the collector, e.g. http://collector:4318")
+			fs.Var(&otlpHeaders, "otlp.header", "header added to every request as 'Name: value', can be repeated")
+			fs.IntVar(&otlpConfig.BatchSize, "otlp.batch", 1000, "maximum number of log records per request")
+			fs.BoolVar(&otlpConfig.Gzip, "otlp.gzip", true, "compress the requests")
+		},
+		new: func(io.Writer, string) (splunker.Sink, error) {
+			otlpConfig.Headers = make(map[string]string)
+			for _, h := range otlpHeaders {
+				name, value, ok := strings.Cut(h, ":")
+				if !ok {
+					return nil, fmt.Errorf("invalid header %q", h)
+				}
+				otlpConfig.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+			}
+			return otlp.New(otlpConfig)
+		},
+	},
+	"elastic": {
+		usage: "index the events into Elasticsearch or OpenSearch at -es.url",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&esConfig.URL, "es.url", "", "URL of the cluster, e.g. https://elastic:9200")
+			fs.StringVar(&esConfig.IndexTemplate, "es.index", elastic.DefaultIndexTemplate, "template of the index names")
+			fs.StringVar(&esConfig.Username, "es.user", "", "user for basic authentication")
+			fs.StringVar(&esConfig.Password, "es.password", "", "password for basic authentication")
+			fs.StringVar(&esConfig.APIKey, "es.api-key", "", "API key")
+			fs.IntVar(&esConfig.BatchSize, "es.batch", 1000, "maximum number of documents per request")
+			fs.BoolVar(&esInsecure, "es.insecure", false, "do not verify the TLS certificate")
+		},
+		new: func(io.Writer, string) (splunker.Sink, error) {
+			if esInsecure {
+				esConfig.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+			}
+			return elastic.New(esConfig)
+		},
+	},
+	"loki": {
+		usage: "push the events to Grafana Loki at -loki.url",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&lokiConfig.URL, "loki.url", "", "URL of Loki, e.g. http://loki:3100")
+			fs.StringVar(&lokiConfig.TenantID, "loki.tenant", "", "tenant sent as X-Scope-OrgID")
+			fs.StringVar(&lokiConfig.Username, "loki.user", "", "user for basic authentication")
+			fs.StringVar(&lokiConfig.Password, "loki.password", "", "password for basic authentication")
+			fs.Var(&lokiLabels, "loki.label", "stream label: host, source, sourcetype, index or an indexed field, can be repeated (default "+strings.Join(loki.DefaultLabels, ", ")+")")
+			fs.BoolVar(&lokiConfig.StructuredMetadata, "loki.metadata", false, "attach the other indexed fields as structured metadata")
+			fs.IntVar(&lokiConfig.BatchSize, "loki.batch", 1000, "maximum number of entries per request")
+		},
+		new: func(io.Writer, string) (splunker.Sink, error) {
+			lokiConfig.Labels = lokiLabels
+			return loki.New(lokiConfig)
+		},
+	},
+	"fluent": {
+		usage: "forward the events to the Fluentd or Fluent Bit forward input at -fluent.addr",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&fluentConfig.Addr, "fluent.addr", "", "address of the forward input, e.g. fluentbit:24224")
+			fs.StringVar(&fluentConfig.TagPrefix, "fluent.tag", "splunk", "prefix of the tags")
+			fs.BoolVar(&fluentConfig.Compress, "fluent.gzip", false, "compress the entries")
+			fs.BoolVar(&fluentConfig.RequireAck, "fluent.ack", false, "wait for the acknowledgement of every message")
+			fs.IntVar(&fluentConfig.BatchSize, "fluent.batch", 1000, "maximum number of entries per flush")
+			fs.BoolVar(&fluentTLS, "fluent.tls", false, "connect with TLS")
+			fs.BoolVar(&fluentInsecure, "fluent.insecure", false, "do not verify the TLS certificate")
+		},
+		new: func(io.Writer, string) (splunker.Sink, error) {
+			if fluentTLS {
+				fluentConfig.TLSConfig = &tls.Config{InsecureSkipVerify: fluentInsecure}
+			}
+			return fluent.New(fluentConfig)
+		},
+	},
+	"hive": {
+		usage: "zstd compressed JSON Lines in a directory tree partitioned like -hive.keys below -o",
+		flags: func(fs *flag.FlagSet) {
+			fs.Var(&hiveKeys, "hive.keys", "partition key: index, host, source, sourcetype, date, hour or an indexed field, can be repeated (default "+strings.Join(hive.DefaultKeys, ", ")+")")
+			fs.Int64Var(&hiveConfig.MaxPartSize, "hive.part-size", 128<<20, "roll parts at this uncompressed size in bytes")
+			fs.IntVar(&hiveConfig.MaxOpenParts, "hive.open-parts", 64, "maximum number of parts written at the same time")
+		},
+		createsOutput: true,
+		new: func(_ io.Writer, out string) (splunker.Sink, error) {
+			if out == "" || out == "-" {
+				return nil, fmt.Errorf("the hive format requires a directory as -o")
+			}
+			hiveConfig.Dir = out
+			hiveConfig.Keys = hiveKeys
+			return hive.New(hiveConfig)
+		},
+	},
+	"syslog": {
+		usage: "send the events as syslog messages to -syslog.addr",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&syslogConfig.Addr, "syslog.addr", "", "address of the syslog server, e.g. siem:514")
+			fs.StringVar(&syslogConfig.Network, "syslog.network", "udp", "udp, tcp or tls")
+			fs.StringVar(&syslogFormat, "syslog.format", "rfc5424", "message format, rfc5424 or rfc3164")
+			fs.IntVar(&syslogFacility, "syslog.facility", 1, "facility of the messages, 0 to 23")
+			fs.IntVar(&syslogSeverity, "syslog.severity", 5, "severity of the messages, 0 to 7")
+			fs.BoolVar(&syslogInsecure, "syslog.insecure", false, "do not verify the TLS certificate")
+		},
+		new: func(io.Writer, string) (splunker.Sink, error) {
+			switch syslogFormat {
+			case "rfc5424":
+				syslogConfig.Format = syslog.RFC5424
+			case "rfc3164":
+				syslogConfig.Format = syslog.RFC3164
+			default:
+				return nil, fmt.Errorf("unknown syslog format %q", syslogFormat)
+			}
+			syslogConfig.Facility, syslogConfig.Severity = &syslogFacility, &syslogSeverity
+			syslogConfig.TLSConfig = &tls.Config{InsecureSkipVerify: syslogInsecure}
+			return syslog.Dial(syslogConfig)
+		},
+	},
+}
+
+var (
+	hecConfig   hec.Config
+	hecInsecure bool
+
+	s2sAddr     string
+	s2sConfig   s2s.Config
+	s2sTLS      bool
+	s2sInsecure bool
+
+	otlpConfig  otlp.Config
+	otlpHeaders stringList
+
+	esConfig   elastic.Config
+	esInsecure bool
+
+	lokiConfig loki.Config
+	lokiLabels stringList
+
+	fluentConfig   fluent.Config
+	fluentTLS      bool
+	fluentInsecure bool
+
+	parquetConfig      parquet.Config
+	parquetCompression string
+
+	templateText string
+	templateFile string
+
+	arrowConfig arrow.Config
+
+	sqliteConfig sqlite.Config
+
+	hiveConfig hive.Config
+	hiveKeys   stringList
+
+	syslogConfig   syslog.Config
+	syslogFormat   string
+	syslogFacility int
+	syslogSeverity int
+	syslogInsecure bool
+)
+
+func formatNames() string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// openOutput creates the writer for the format name writing to out or stdout
+func openOutput(name, out string) (splunker.Sink, func() error, error) {
+	f, ok := formats[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown format %q, known formats: %s", name, formatNames())
+	}
+
+	var w io.Writer = os.Stdout
+	closeOut := func() error { return nil }
+	if out != "" && out != "-" && !f.createsOutput {
+		file, err := os.Create(out)
+		if err != nil {
+			return nil, nil, err
+		}
+		w = file
+		closeOut = file.Close
+	}
+
+	ew, err := f.new(w, out)
+	if err != nil {
+		_ = closeOut()
+		return nil, nil, err
+	}
+
+	return ew, func() error {
+		if err := ew.Close(); err != nil {
+			_ = closeOut()
+			return err
+		}
+		return closeOut()
+	}, nil
+}
+
+type rawWriter struct {
+	w *bufio.Writer
+}
+
+func (r *rawWriter) WriteEvent(e splunker.Event) error {
+	if _, err := r.w.Write(e.Message()); err != nil {
+		return err
+	}
+	return r.w.WriteByte('\n')
+}
+
+func (r *rawWriter) Close() error {
+	return r.w.Flush()
+}
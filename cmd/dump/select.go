@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"iter"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fionera/splunker"
+)
+
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// timeFlag accepts RFC 3339 timestamps, dates, unix timestamps
+// and durations relative to now like -24h
+type timeFlag struct {
+	t time.Time
+}
+
+func (f *timeFlag) String() string {
+	if f.t.IsZero() {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(s string) error {
+	if d, err := time.ParseDuration(s); err == nil {
+		f.t = time.Now().Add(d)
+		return nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		f.t = time.Unix(sec, 0)
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			f.t = t
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid time %q", s)
+}
+
+// selection holds the flags shared by all commands to select the events
+type selection struct {
+	indexes     stringList
+	buckets     stringList
+	earliest    timeFlag
+	latest      timeFlag
+	hosts       stringList
+	sources     stringList
+	sourceTypes stringList
+	workers     int
+	sorted      bool
+	dedup       bool
+}
+
+func newFlagSet(name string, sel *selection) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Var(&sel.indexes, "index", "path of an index directory, can be repeated")
+	fs.Var(&sel.buckets, "bucket", "only read buckets with this id or matching this name pattern, can be repeated")
+	fs.Var(&sel.earliest, "earliest", "only events at or after this time (RFC 3339, date, unix time or duration like -24h)")
+	fs.Var(&sel.latest, "latest", "only events before this time")
+	fs.Var(&sel.hosts, "host", "only events of hosts matching this wildcard pattern, can be repeated")
+	fs.Var(&sel.sources, "source", "only events of sources matching this wildcard pattern, can be repeated")
+	fs.Var(&sel.sourceTypes, "sourcetype", "only events of sourcetypes matching this wildcard pattern, can be repeated")
+	fs.IntVar(&sel.workers, "workers", 1, "number of buckets decoded concurrently")
+	fs.BoolVar(&sel.sorted, "sorted", false, "emit the events ordered by _time")
+	fs.BoolVar(&sel.dedup, "dedup", false, "read replicated cluster buckets only once, the indexes have to be copies of the same index")
+	return fs
+}
+
+// selectBuckets returns the buckets of all indexes matching the bucket and time flags
+func (s *selection) selectBuckets() ([]splunker.Bucket, error) {
+	if len(s.indexes) == 0 {
+		return nil, errors.New("no -index given")
+	}
+
+	var buckets []splunker.Bucket
+	for _, p := range s.indexes {
+		idx, err := splunker.OpenIndex(p)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range idx.Buckets() {
+			if s.matchBucket(b) {
+				buckets = append(buckets, b)
+			}
+		}
+	}
+
+	return buckets, nil
+}
+
+func (s *selection) matchBucket(b splunker.Bucket) bool {
+	// events are at or after the oldest and less than a second after the newest time
+	if !s.earliest.t.IsZero() && !b.Newest.IsZero() && !b.Newest.Add(time.Second).After(s.earliest.t) {
+		return false
+	}
+	if !s.latest.t.IsZero() && !b.Oldest.IsZero() && !b.Oldest.Before(s.latest.t) {
+		return false
+	}
+
+	if len(s.buckets) == 0 {
+		return true
+	}
+
+	name := filepath.Base(b.Path)
+	for _, p := range s.buckets {
+		if p == strconv.FormatUint(b.ID, 10) {
+			return true
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// events returns the selected events. They stay valid after the iteration.
+func (s *selection) events(ctx context.Context) (iter.Seq2[splunker.Event, error], error) {
+	buckets, err := s.selectBuckets()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []splunker.ReaderOption{splunker.WithWorkers(s.workers)}
+	if s.sorted {
+		opts = append(opts, splunker.WithTimeOrder())
+	}
+
+	var r *splunker.IndexReader
+	if s.dedup {
+		r = splunker.NewGroupReader(splunker.GroupBuckets(buckets), opts...)
+	} else {
+		r = splunker.NewIndexReader(buckets, opts...)
+	}
+	seq := r.AllContext(ctx)
+
+	match, err := s.eventMatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	return splunker.Filter(seq, match), nil
+}
+
+func (s *selection) eventMatcher() (func(splunker.Event) bool, error) {
+	hosts, err := compileGlobs(s.hosts)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := compileGlobs(s.sources)
+	if err != nil {
+		return nil, err
+	}
+	sourceTypes, err := compileGlobs(s.sourceTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	earliest, latest := s.earliest.t, s.latest.t
+	return func(e splunker.Event) bool {
+		if !earliest.IsZero() || !latest.IsZero() {
+			t := e.Time()
+			if !earliest.IsZero() && t.Before(earliest) {
+				return false
+			}
+			if !latest.IsZero() && !t.Before(latest) {
+				return false
+			}
+		}
+
+		return matchAny(hosts, e.Host()) && matchAny(sources, e.Source()) && matchAny(sourceTypes, e.SourceType())
+	}, nil
+}
+
+// compileGlobs compiles Splunk style wildcard patterns, * matches any
+// number of characters and the match is case insensitive
+func compileGlobs(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		parts := strings.Split(p, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+
+		re, err := regexp.Compile("(?i)^" + strings.Join(parts, ".*") + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	if len(res) == 0 {
+		return true
+	}
+
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+const testGUID = "9A1B2C3D-0000-4E5F-8000-000000000001"
+
+// writePeer writes the first n events into the index web of peer and
+// names its buckets like clustered copies with prefix db or rb
+func writePeer(t *testing.T, peer, prefix string, n int) string {
+	t.Helper()
+
+	dir := filepath.Join(peer, "web")
+	w, err := splunker.NewIndexWriter(dir, splunker.WithMaxBucketSize(400))
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		require.NoError(t, w.WriteEvent(splunker.NewEvent(splunker.EventInfo{
+			Time:         time.Unix(1700000000+int64(i), 0),
+			Host:         fmt.Sprintf("web%02d", i%2),
+			Source:       "/var/log/app.log",
+			SourceType:   "app",
+			Raw:          []byte(fmt.Sprintf("event %d", i)),
+			StreamID:     1,
+			StreamOffset: uint64(i) * 10,
+		})))
+	}
+	require.NoError(t, w.Close())
+
+	matches, err := filepath.Glob(filepath.Join(dir, "db", "db_*"))
+	require.NoError(t, err)
+	require.Greater(t, len(matches), 2)
+	for _, m := range matches {
+		name := prefix + strings.TrimPrefix(filepath.Base(m), "db") + "_" + testGUID
+		require.NoError(t, os.Rename(m, filepath.Join(dir, "db", name)))
+	}
+	return dir
+}
+
+// testPeers returns two copies of an index of 60 events. The replicated
+// copy lacks the last event, so the groups of its last bucket differ.
+func testPeers(t *testing.T) (string, string) {
+	return writePeer(t, t.TempDir(), "db", 60), writePeer(t, t.TempDir(), "rb", 59)
+}
+
+func parseSelection(t *testing.T, args ...string) *selection {
+	t.Helper()
+
+	var sel selection
+	fs := newFlagSet("test", &sel)
+	require.NoError(t, fs.Parse(args))
+	return &sel
+}
+
+func TestSelectionFlags(t *testing.T) {
+	sel := parseSelection(t,
+		"-index", "a", "-index", "b",
+		"-bucket", "7", "-bucket", "db_*",
+		"-host", "web*", "-sourcetype", "app",
+		"-earliest", "2023-11-14", "-latest", "1700000100",
+		"-workers", "4", "-sorted", "-dedup",
+	)
+
+	assert.Equal(t, stringList{"a", "b"}, sel.indexes)
+	assert.Equal(t, stringList{"7", "db_*"}, sel.buckets)
+	assert.Equal(t, stringList{"web*"}, sel.hosts)
+	assert.Equal(t, stringList{"app"}, sel.sourceTypes)
+	assert.Equal(t, time.Date(2023, 11, 14, 0, 0, 0, 0, time.Local), sel.earliest.t)
+	assert.Equal(t, time.Unix(1700000100, 0), sel.latest.t)
+	assert.Equal(t, 4, sel.workers)
+	assert.True(t, sel.sorted)
+	assert.True(t, sel.dedup)
+
+	// the defaults read every bucket once in one worker
+	sel = parseSelection(t)
+	assert.Equal(t, 1, sel.workers)
+	assert.False(t, sel.sorted)
+	assert.False(t, sel.dedup)
+}
+
+func TestTimeFlag(t *testing.T) {
+	var f timeFlag
+	require.NoError(t, f.Set("-1h"))
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), f.t, time.Minute)
+
+	require.NoError(t, f.Set("2023-11-14T22:13:20Z"))
+	assert.Equal(t, int64(1700000000), f.t.Unix())
+
+	assert.Error(t, f.Set("yesterday"))
+}
+
+// selected returns the messages of the events selected by args
+func selected(t *testing.T, args ...string) []string {
+	t.Helper()
+
+	events, err := parseSelection(t, args...).events(context.Background())
+	require.NoError(t, err)
+
+	var messages []string
+	for e, err := range events {
+		require.NoError(t, err)
+		messages = append(messages, string(e.Message()))
+	}
+	return messages
+}
+
+func TestSelectionDedup(t *testing.T) {
+	primary, replicated := testPeers(t)
+
+	var want []string
+	for i := 0; i < 60; i++ {
+		want = append(want, fmt.Sprintf("event %d", i))
+	}
+
+	for _, args := range [][]string{
+		{"-dedup"},
+		{"-dedup", "-sorted"},
+		{"-dedup", "-workers", "3"},
+		{"-dedup", "-sorted", "-workers", "3"},
+	} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			got := selected(t, append([]string{"-index", primary, "-index", replicated}, args...)...)
+			if !containsString(args, "-sorted") {
+				sort.Slice(got, func(i, j int) bool { return messageNumber(got[i]) < messageNumber(got[j]) })
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+
+	// without -dedup every copy is read
+	got := selected(t, "-index", primary, "-index", replicated, "-workers", "3")
+	assert.Len(t, got, 119)
+
+	// the filters apply to the deduplicated events
+	got = selected(t, "-index", primary, "-index", replicated, "-dedup", "-sorted", "-workers", "2",
+		"-host", "WEB01", "-earliest", "1700000050")
+	assert.Equal(t, []string{"event 51", "event 53", "event 55", "event 57", "event 59"}, got)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func messageNumber(msg string) int {
+	var n int
+	_, _ = fmt.Sscanf(msg, "event %d", &n)
+	return n
+}
+
+func TestSelectBuckets(t *testing.T) {
+	primary, _ := testPeers(t)
+
+	_, err := parseSelection(t).selectBuckets()
+	assert.EqualError(t, err, "no -index given")
+
+	all, err := parseSelection(t, "-index", primary).selectBuckets()
+	require.NoError(t, err)
+
+	one, err := parseSelection(t, "-index", primary, "-bucket", "1").selectBuckets()
+	require.NoError(t, err)
+	require.Len(t, one, 1)
+	assert.EqualValues(t, 1, one[0].ID)
+
+	// the time range skips buckets that end before -earliest
+	late, err := parseSelection(t, "-index", primary, "-earliest", "1700000059").selectBuckets()
+	require.NoError(t, err)
+	require.Len(t, late, 1)
+	assert.Equal(t, all[len(all)-1].Path, late[0].Path)
+}
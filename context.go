@@ -0,0 +1,150 @@
+package splunker
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"os"
+)
+
+// NewJournalDecoderContext creates a JournalDecoder that stops decoding
+// once ctx is done. See ScanContext.
+func NewJournalDecoderContext(ctx context.Context, name string, opts ...Option) (*JournalDecoder, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	jd := newJournalDecoder(opts)
+	if err := jd.Reset(name); err != nil {
+		return nil, err
+	}
+	jd.setContext(ctx)
+
+	return jd, nil
+}
+
+// ScanContext is like Scan, but stops once ctx is done. The journal file
+// and the zstd decoder are closed as soon as ctx is done, even while a
+// read is blocked. Err then returns ctx.Err() wrapped with the position
+// the decoder reached.
+//
+// The context stays in effect for following calls to Scan until the
+// decoder is reset.
+func (jd *JournalDecoder) ScanContext(ctx context.Context) bool {
+	jd.setContext(ctx)
+	return jd.Scan()
+}
+
+// AllContext is like All, but stops once ctx is done. See ScanContext.
+func (jd *JournalDecoder) AllContext(ctx context.Context) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for jd.ScanContext(ctx) {
+			if !yield(jd.Event(), nil) {
+				return
+			}
+		}
+
+		if err := jd.Err(); err != nil {
+			yield(Event{}, err)
+		}
+	}
+}
+
+// AllContext is like All, but stops once ctx is done. See ScanContext.
+func (b Bucket) AllContext(ctx context.Context, opts ...Option) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		if err := ctx.Err(); err != nil {
+			yield(Event{}, err)
+			return
+		}
+
+		jd := newJournalDecoder(opts)
+		defer jd.Close()
+
+		if err := jd.resetBucket(&b); err != nil {
+			yield(Event{}, err)
+			return
+		}
+
+		for e, err := range jd.AllContext(ctx) {
+			if !yield(e, err) {
+				return
+			}
+		}
+	}
+}
+
+// AllContext is like All, but stops once ctx is done. See ScanContext.
+func (i *Index) AllContext(ctx context.Context, opts ...Option) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		jd := newJournalDecoder(opts)
+		defer jd.Close()
+
+		for n := range i.buckets {
+			b := &i.buckets[n]
+
+			err := ctx.Err()
+			if err == nil {
+				err = jd.resetBucket(b)
+			}
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				yield(Event{}, fmt.Errorf("%s: %w", b.Path, err))
+				return
+			}
+
+			for e, err := range jd.AllContext(ctx) {
+				if err != nil {
+					err = fmt.Errorf("%s: %w", b.Path, err)
+				}
+				if !yield(e, err) || err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (jd *JournalDecoder) setContext(ctx context.Context) {
+	if jd.ctx == ctx {
+		return
+	}
+
+	if jd.stopCtx != nil {
+		jd.stopCtx()
+	}
+	jd.ctx = ctx
+	jd.stopCtx = context.AfterFunc(ctx, jd.interrupt)
+}
+
+// interrupt closes the journal file to unblock a pending read.
+// It is called from the goroutine of the context.
+func (jd *JournalDecoder) interrupt() {
+	jd.fMu.Lock()
+	defer jd.fMu.Unlock()
+
+	if jd.f != nil {
+		_ = jd.f.Close()
+	}
+}
+
+// checkCancel replaces the current error with the context error
+// if the error was caused by the cancellation
+func (jd *JournalDecoder) checkCancel() {
+	if jd.ctx != nil && jd.ctx.Err() != nil {
+		jd.cancel()
+	}
+}
+
+// cancel releases the resources of the journal and records the context error
+func (jd *JournalDecoder) cancel() {
+	jd.closeFile()
+	if jd.zr != nil {
+		jd.zr.Close()
+		jd.zr = nil
+	}
+
+	jd.err = fmt.Errorf("stopped at offset %d: %w", jd.cr.pos, jd.ctx.Err())
+}
@@ -0,0 +1,153 @@
+package splunker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBucket writes a bucket with n events
+func testBucket(t *testing.T, n int) Bucket {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "main")
+	w, err := NewIndexWriter(dir)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		require.NoError(t, w.WriteEvent(NewEvent(EventInfo{
+			Time: time.Unix(1700000000+int64(i), 0),
+			Host: "web01",
+			Raw:  []byte(fmt.Sprintf("event %d", i)),
+		})))
+	}
+	require.NoError(t, w.Close())
+
+	idx, err := OpenIndex(dir)
+	require.NoError(t, err)
+	require.Len(t, idx.Buckets(), 1)
+	return idx.Buckets()[0]
+}
+
+func TestScanContextCancel(t *testing.T) {
+	b := testBucket(t, 100)
+	files := openFiles(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jd, err := NewJournalDecoderContext(ctx, b.Path)
+	require.NoError(t, err)
+	defer jd.Close()
+	assert.Equal(t, files+1, openFiles(t))
+
+	n := 0
+	for jd.Scan() {
+		if n++; n == 10 {
+			cancel()
+		}
+	}
+	assert.Equal(t, 10, n)
+	assert.ErrorIs(t, jd.Err(), context.Canceled)
+	assert.Regexp(t, `^stopped at offset [1-9][0-9]*: context canceled$`, jd.Err().Error())
+	// the file is closed without calling Close
+	assert.Equal(t, files, openFiles(t))
+	assert.False(t, jd.Scan())
+
+	// a reset decoder no longer observes the cancelled context
+	require.NoError(t, jd.Reset(b.Path))
+	n = 0
+	for jd.Scan() {
+		n++
+	}
+	require.NoError(t, jd.Err())
+	assert.Equal(t, 100, n)
+
+	require.NoError(t, jd.ResetReader(mustOpen(t, filepath.Join(b.Path, "rawdata", "journal.zst"))))
+	assert.True(t, jd.Scan())
+}
+
+func mustOpen(t *testing.T, name string) *os.File {
+	t.Helper()
+
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func TestScanContext(t *testing.T) {
+	b := testBucket(t, 20)
+
+	jd, err := NewJournalDecoder(b.Path)
+	require.NoError(t, err)
+	defer jd.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.True(t, jd.ScanContext(ctx))
+	cancel()
+	assert.False(t, jd.ScanContext(ctx))
+	assert.ErrorIs(t, jd.Err(), context.Canceled)
+
+	// a context that is done already fails the constructor
+	_, err = NewJournalDecoderContext(ctx, b.Path)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the deadline error is wrapped as well
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	require.NoError(t, jd.Reset(b.Path))
+	assert.False(t, jd.ScanContext(ctx))
+	assert.ErrorIs(t, jd.Err(), context.DeadlineExceeded)
+	assert.Contains(t, jd.Err().Error(), "stopped at offset 0")
+}
+
+func TestAllContext(t *testing.T) {
+	b := testBucket(t, 50)
+	files := openFiles(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := 0
+	var last error
+	for _, err := range b.AllContext(ctx) {
+		if err != nil {
+			last = err
+			continue
+		}
+		if n++; n == 5 {
+			cancel()
+		}
+	}
+	assert.Equal(t, 5, n)
+	assert.ErrorIs(t, last, context.Canceled)
+	assert.Equal(t, files, openFiles(t))
+
+	idx, err := OpenIndex(filepath.Dir(filepath.Dir(b.Path)))
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	n, last = 0, nil
+	for _, err := range idx.AllContext(ctx) {
+		if err != nil {
+			last = err
+			continue
+		}
+		if n++; n == 5 {
+			cancel()
+		}
+	}
+	assert.Equal(t, 5, n)
+	assert.ErrorIs(t, last, context.Canceled)
+	assert.ErrorContains(t, last, b.Path)
+	assert.Equal(t, files, openFiles(t))
+
+	// a done context yields its error without opening the journal
+	for _, err := range b.AllContext(ctx) {
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+}
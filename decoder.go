@@ -2,7 +2,7 @@ package splunker
 
 import (
 	"encoding/binary"
-	"io"
+	"fmt"
 	"log"
 
 	"github.com/fionera/splunker/varint"
@@ -27,18 +27,43 @@ func (jd *JournalDecoder) headerDecoder(r *CountedReader, o byte) error {
 	return nil
 }
 
+// maxPrivateSize bounds the length of an OpcodeSplunkPrivate entry, so a
+// corrupt length fails instead of allocating its size
+const maxPrivateSize = 64 << 20
+
 // splunkPrivateDecoder is the decoder for OpcodeSplunkPrivate
 func (jd *JournalDecoder) splunkPrivateDecoder(r *CountedReader, o byte) error {
+	// the opcode has already been consumed
+	offset := r.pos - 1
+
 	l, err := binary.ReadUvarint(r)
 	if err != nil {
 		return err
 	}
+	if l > maxPrivateSize {
+		return fmt.Errorf("splunk private entry at %d: length %d exceeds %d bytes", offset, l, maxPrivateSize)
+	}
 
-	if _, err := io.CopyN(io.Discard, r, int64(l)); err != nil {
+	if jd.privateHandler == nil {
+		if _, err := r.Discard(int(l)); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if cap(jd.privateBuf) < int(l) {
+		jd.privateBuf = make([]byte, l)
+	}
+	jd.privateBuf = jd.privateBuf[:l]
+
+	if _, err := r.Read(jd.privateBuf); err != nil {
 		return err
 	}
 
-	return nil
+	return jd.privateHandler(SplunkPrivate{
+		Offset: offset,
+		Data:   jd.privateBuf,
+	})
 }
 
 func (jd *JournalDecoder) stringFieldDecoder(r *CountedReader) (string, error) {
@@ -102,7 +127,7 @@ const eventInfoSize = 8*binary.MaxVarintLen64 + decBufSize + hashSize
 // eventDecoder is the decoder for OpcodeOldstyleEventWithHash, OpcodeOldstyleEvent
 func (jd *JournalDecoder) eventDecoder(r *CountedReader, o byte) (err error) {
 	var peekOffset, n int
-	peek, err := jd.cr.Peek(eventInfoSize)
+	peek, err := jd.cr.peekPadded(eventInfoSize)
 	if err != nil {
 		return err
 	}
@@ -175,18 +200,20 @@ func (jd *JournalDecoder) eventDecoder(r *CountedReader, o byte) (err error) {
 	// per entry: 1
 	// max highest int needed: 3
 	// max var int size: MaxVarintLen64
-	peek, err = r.Peek(4 * binary.MaxVarintLen64 * int(jd.e.metadataCount))
+	peek, err = r.peekPadded(4 * binary.MaxVarintLen64 * int(jd.e.metadataCount))
 	if err != nil {
 		return err
 	}
 
 	peekOffset = 0
+	jd.meta = jd.meta[:0]
 	for i := 0; i < int(jd.e.metadataCount); i++ {
-		n, err := readMetadata(peek[peekOffset:], o)
+		m, n, err := readMetadata(peek[peekOffset:], o)
 		if err != nil {
 			return err
 		}
 		peekOffset += n
+		jd.meta = append(jd.meta, m)
 	}
 
 	if _, err := r.Discard(peekOffset); err != nil {
@@ -217,5 +244,8 @@ func (jd *JournalDecoder) eventDecoder(r *CountedReader, o byte) (err error) {
 
 	jd.e.includePunctuation = (o & 0x22) == 34
 
+	// needs the message for fields referencing parts of it
+	jd.e.fields = jd.resolveMetadata(jd.e.fields[:0])
+
 	return nil
 }
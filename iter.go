@@ -0,0 +1,92 @@
+package splunker
+
+import (
+	"context"
+	"iter"
+)
+
+// All returns an iterator over the remaining events of the journal.
+// An error ends the iteration.
+//
+// The yielded Event is only valid until the next iteration,
+// use Event.Clone to keep it.
+func (jd *JournalDecoder) All() iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for jd.Scan() {
+			if !yield(jd.Event(), nil) {
+				return
+			}
+		}
+
+		if err := jd.Err(); err != nil {
+			yield(Event{}, err)
+		}
+	}
+}
+
+// All returns an iterator over the events of the bucket.
+// See JournalDecoder.All for the lifetime of the events.
+func (b Bucket) All(opts ...Option) iter.Seq2[Event, error] {
+	return b.AllContext(context.Background(), opts...)
+}
+
+// All returns an iterator over the events of all buckets of the index.
+// Buckets without a journal are skipped.
+// See JournalDecoder.All for the lifetime of the events.
+func (i *Index) All(opts ...Option) iter.Seq2[Event, error] {
+	return i.AllContext(context.Background(), opts...)
+}
+
+// Filter returns an iterator over the values of seq for which keep returns true.
+// Errors are always passed through.
+func Filter[V any](seq iter.Seq2[V, error], keep func(V) bool) iter.Seq2[V, error] {
+	return func(yield func(V, error) bool) {
+		for v, err := range seq {
+			if err == nil && !keep(v) {
+				continue
+			}
+			if !yield(v, err) {
+				return
+			}
+		}
+	}
+}
+
+// Map returns an iterator over the values of seq converted by f.
+// Errors are passed through with the zero value of T.
+func Map[V, T any](seq iter.Seq2[V, error], f func(V) T) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for v, err := range seq {
+			var t T
+			if err == nil {
+				t = f(v)
+			}
+			if !yield(t, err) {
+				return
+			}
+		}
+	}
+}
+
+// Limit returns an iterator over the first n values of seq.
+// Errors are passed through and do not count towards n.
+func Limit[V any](seq iter.Seq2[V, error], n int) iter.Seq2[V, error] {
+	return func(yield func(V, error) bool) {
+		if n <= 0 {
+			return
+		}
+
+		count := 0
+		for v, err := range seq {
+			if !yield(v, err) {
+				return
+			}
+			if err != nil {
+				continue
+			}
+			if count++; count >= n {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package splunker
+
+import (
+	"errors"
+	"iter"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTest = errors.New("test")
+
+// values yields 1..n with errTest after every value divisible by 3
+// and records the last value in pulled
+func values(n int, pulled *int) iter.Seq2[int, error] {
+	return func(yield func(int, error) bool) {
+		for i := 1; i <= n; i++ {
+			*pulled = i
+			if !yield(i, nil) {
+				return
+			}
+			if i%3 == 0 && !yield(0, errTest) {
+				return
+			}
+		}
+	}
+}
+
+type result struct {
+	v   int
+	err error
+}
+
+func drain(seq iter.Seq2[int, error]) []result {
+	var res []result
+	for v, err := range seq {
+		res = append(res, result{v, err})
+	}
+	return res
+}
+
+func TestFilter(t *testing.T) {
+	var pulled int
+	odd := func(v int) bool { return v%2 == 1 }
+	assert.Equal(t, []result{{1, nil}, {3, nil}, {0, errTest}, {5, nil}}, drain(Filter(values(5, &pulled), odd)))
+
+	// errors pass even if keep would drop the zero value
+	none := func(int) bool { return false }
+	assert.Equal(t, []result{{0, errTest}}, drain(Filter(values(4, &pulled), none)))
+
+	for v := range Filter(values(10, &pulled), odd) {
+		if v == 3 {
+			break
+		}
+	}
+	assert.Equal(t, 3, pulled)
+}
+
+func TestMap(t *testing.T) {
+	var pulled int
+	double := func(v int) int { return 2 * v }
+	assert.Equal(t, []result{{2, nil}, {4, nil}, {6, nil}, {0, errTest}}, drain(Map(values(3, &pulled), double)))
+
+	for v := range Map(values(10, &pulled), double) {
+		if v == 4 {
+			break
+		}
+	}
+	assert.Equal(t, 2, pulled)
+}
+
+func TestLimit(t *testing.T) {
+	var pulled int
+	// errors do not count towards n
+	assert.Equal(t, []result{{1, nil}, {2, nil}, {3, nil}, {0, errTest}, {4, nil}},
+		drain(Limit(values(10, &pulled), 4)))
+	assert.Equal(t, 4, pulled)
+
+	pulled = 0
+	assert.Empty(t, drain(Limit(values(10, &pulled), 0)))
+	assert.Zero(t, pulled, "Limit(0) must not start seq")
+
+	for v := range Limit(values(10, &pulled), 5) {
+		if v == 2 {
+			break
+		}
+	}
+	assert.Equal(t, 2, pulled)
+}
+
+// openFiles returns the number of open file descriptors of the process
+func openFiles(t *testing.T) int {
+	t.Helper()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("counts /proc/self/fd")
+	}
+	fds, err := os.ReadDir("/proc/self/fd")
+	require.NoError(t, err)
+	return len(fds)
+}
+
+func TestJournalDecoderAll(t *testing.T) {
+	journal, _ := testJournal(t, nil)
+	b := writeBucket(t, journal)
+
+	jd, err := NewJournalDecoder(b.Path)
+	require.NoError(t, err)
+	defer jd.Close()
+
+	for range jd.All() {
+		break
+	}
+	// All continues with the remaining events
+	var messages []string
+	for e, err := range jd.All() {
+		require.NoError(t, err)
+		messages = append(messages, string(e.Message()))
+	}
+	assert.Equal(t, []string{"second"}, messages)
+
+	// a decoding error ends the iteration
+	broken, _ := testJournal(t, []byte{50})
+	require.NoError(t, jd.Reset(writeBucket(t, broken).Path))
+	res := drainEvents(jd.All())
+	require.Len(t, res, 2)
+	assert.Equal(t, "first", res[0].msg)
+	assert.ErrorContains(t, res[1].err, "unknown opcode")
+}
+
+type eventResult struct {
+	msg string
+	err error
+}
+
+func drainEvents(seq iter.Seq2[Event, error]) []eventResult {
+	var res []eventResult
+	for e, err := range seq {
+		res = append(res, eventResult{string(e.Message()), err})
+	}
+	return res
+}
+
+func TestBucketAll(t *testing.T) {
+	journal, _ := testJournal(t, nil)
+	b := writeBucket(t, journal)
+
+	before := openFiles(t)
+	for range b.All() {
+		assert.Equal(t, before+1, openFiles(t))
+		break
+	}
+	assert.Equal(t, before, openFiles(t), "the journal is closed after break")
+
+	// a bucket without journal yields the error
+	res := drainEvents(Bucket{Path: t.TempDir()}.All())
+	require.Len(t, res, 1)
+	assert.True(t, os.IsNotExist(res[0].err))
+}
+
+func TestIndexAll(t *testing.T) {
+	buckets := testIndex(t, 60)
+	idx, err := OpenIndex(filepath.Dir(filepath.Dir(buckets[0].Path)))
+	require.NoError(t, err)
+
+	// buckets without a journal are skipped
+	require.NoError(t, os.Remove(filepath.Join(buckets[1].Path, "rawdata", "journal.zst")))
+	n := 0
+	for _, err := range idx.All() {
+		require.NoError(t, err)
+		n++
+	}
+	assert.Less(t, n, 60)
+	assert.Greater(t, n, 0)
+
+	before := openFiles(t)
+	for range idx.All() {
+		break
+	}
+	assert.Equal(t, before, openFiles(t), "the journal is closed after break")
+
+	// an error names the bucket and ends the iteration
+	require.NoError(t, os.WriteFile(filepath.Join(buckets[2].Path, "rawdata", "journal.zst"), []byte("not zstd"), 0o644))
+	res := drainEvents(idx.All())
+	require.NotEmpty(t, res)
+	last := res[len(res)-1]
+	assert.ErrorContains(t, last.err, buckets[2].Path)
+	for _, r := range res[:len(res)-1] {
+		assert.NoError(t, r.err)
+	}
+}
@@ -1,41 +1,112 @@
 package splunker
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 )
 
-func NewJournalDecoder(name string) (*JournalDecoder, error) {
-	r, err := openJournal(name)
-	if err != nil {
+// Option configures a JournalDecoder.
+type Option func(jd *JournalDecoder)
+
+// WithMaxMemory limits the memory the zstd decoder may allocate
+// for a single frame to n bytes.
+func WithMaxMemory(n uint64) Option {
+	return func(jd *JournalDecoder) {
+		jd.zstdOpts = append(jd.zstdOpts, zstd.WithDecoderMaxMemory(n))
+	}
+}
+
+// WithMaxWindow limits the window size the zstd decoder accepts to n bytes.
+// Journals with a bigger window fail to decode.
+func WithMaxWindow(n uint64) Option {
+	return func(jd *JournalDecoder) {
+		jd.zstdOpts = append(jd.zstdOpts, zstd.WithDecoderMaxWindow(n))
+	}
+}
+
+// WithConcurrency sets the number of goroutines the zstd decoder uses.
+// 0 uses GOMAXPROCS goroutines, which is the default.
+func WithConcurrency(n int) Option {
+	return func(jd *JournalDecoder) {
+		jd.zstdOpts = append(jd.zstdOpts, zstd.WithDecoderConcurrency(n))
+	}
+}
+
+func NewJournalDecoder(name string, opts ...Option) (*JournalDecoder, error) {
+	jd := newJournalDecoder(opts)
+	if err := jd.Reset(name); err != nil {
 		return nil, err
 	}
 
-	jd := &JournalDecoder{
-		n:  name,
-		cr: newCountedReader(r),
+	return jd, nil
+}
+
+// NewJournalDecoderFromReader creates a JournalDecoder reading the zstd
+// compressed journal from r.
+func NewJournalDecoderFromReader(r io.Reader, opts ...Option) (*JournalDecoder, error) {
+	jd := newJournalDecoder(opts)
+	if err := jd.ResetReader(r); err != nil {
+		return nil, err
 	}
-	jd.s.fields = make(map[byte][]string)
 
 	return jd, nil
 }
 
+func newJournalDecoder(opts []Option) *JournalDecoder {
+	jd := &JournalDecoder{}
+	jd.s.fields = make(map[byte][]string)
+
+	for _, opt := range opts {
+		opt(jd)
+	}
+
+	return jd
+}
+
 // to buffer up to uint64 reads
 const decBufSize = 8
 
 type JournalDecoder struct {
+	// fMu guards f, it is closed from another goroutine on cancellation
+	fMu sync.Mutex
+	f   *os.File
+	zr  *zstd.Decoder
+	// zstdOpts are applied when creating zr
+	zstdOpts []zstd.DOption
+
 	cr     *CountedReader
 	err    error
 	opcode byte
 	e      Event
 	decBuf [decBufSize]byte
 	n      string
-	s      struct {
+	bucket *Bucket
+
+	// decoders registered by the user, they take precedence over the builtin ones
+	decoders [256]Decoder
+	// events marks the registered opcodes that yield an event
+	events [256]bool
+
+	ctx     context.Context
+	stopCtx func() bool
+
+	privateHandler PrivateHandler
+	privateBuf     []byte
+
+	// metadata of the current event
+	meta []metadataItem
+
+	s struct {
 		fields           map[byte][]string
 		baseTime         int32
 		activeHost       uint64
@@ -45,21 +116,49 @@ type JournalDecoder struct {
 }
 
 func (jd *JournalDecoder) Host() string {
-	return jd.s.fields[byte(OpcodeNewHost)][jd.s.activeHost-1]
+	return jd.field(OpcodeNewHost, jd.s.activeHost)
 }
 
 func (jd *JournalDecoder) Source() string {
-	return jd.s.fields[byte(OpcodeNewSource)][jd.s.activeSource-1]
+	return jd.field(OpcodeNewSource, jd.s.activeSource)
 }
 
 func (jd *JournalDecoder) SourceType() string {
-	return jd.s.fields[byte(OpcodeNewSourceType)][jd.s.activeSourceType-1]
+	return jd.field(OpcodeNewSourceType, jd.s.activeSourceType)
+}
+
+// field returns the i-th entry (starting at 1) of the table of o
+// or an empty string if there is none
+func (jd *JournalDecoder) field(o Opcode, i uint64) string {
+	f := jd.s.fields[byte(o)]
+	if i == 0 || i > uint64(len(f)) {
+		return ""
+	}
+	return f[i-1]
+}
+
+// Bucket returns the bucket of the journal. It is empty if the
+// journal is not read from a bucket directory.
+func (jd *JournalDecoder) Bucket() Bucket {
+	if jd.bucket == nil {
+		return Bucket{}
+	}
+	return *jd.bucket
 }
 
 func (jd *JournalDecoder) Scan() bool {
+	if jd.err == ErrClosed {
+		return false
+	}
+	if jd.ctx != nil && jd.ctx.Err() != nil {
+		jd.cancel()
+		return false
+	}
+
 next:
 	jd.opcode, jd.err = jd.cr.ReadByte()
 	if jd.err != nil {
+		jd.checkCancel()
 		return false
 	}
 
@@ -69,6 +168,7 @@ next:
 
 	jd.err = jd.decodeNext()
 	if jd.err != nil {
+		jd.checkCancel()
 		return false
 	}
 
@@ -76,6 +176,11 @@ next:
 		goto next
 	}
 
+	jd.e.host = jd.Host()
+	jd.e.source = jd.Source()
+	jd.e.sourceType = jd.SourceType()
+	jd.e.bucket = jd.bucket
+
 	return true
 }
 
@@ -123,6 +228,10 @@ func (jd *JournalDecoder) decodeNewState() (err error) {
 }
 
 func (jd *JournalDecoder) decodeNext() error {
+	if d := jd.decoders[jd.opcode]; d != nil {
+		return d.Decode(jd, jd.cr, jd.opcode)
+	}
+
 	if d := fetchDecoder(Opcode(jd.opcode)); d != nil {
 		return d.Decode(jd, jd.cr, jd.opcode)
 	}
@@ -139,6 +248,9 @@ func (jd *JournalDecoder) decodeNext() error {
 }
 
 func (jd *JournalDecoder) isEventOpcode() bool {
+	if jd.decoders[jd.opcode] != nil {
+		return jd.events[jd.opcode]
+	}
 	return jd.opcode == byte(OpcodeOldstyleEvent) || jd.opcode == byte(OpcodeOldstyleEventWithHash) || (jd.opcode >= 32 && jd.opcode <= 43)
 }
 
@@ -158,6 +270,49 @@ type Event struct {
 	metadataCount      uint64
 	message            []byte
 	includePunctuation bool
+	host               string
+	source             string
+	sourceType         string
+	bucket             *Bucket
+	fields             []Field
+}
+
+// EventInfo holds the data of an Event created with NewEvent.
+type EventInfo struct {
+	Time            time.Time
+	Host            string
+	Source          string
+	SourceType      string
+	Raw             []byte
+	Bucket          *Bucket
+	StreamID        uint64
+	StreamOffset    uint64
+	StreamSubOffset uint64
+	// Hash is only stored if HasHash is set
+	Hash          [hashSize]byte
+	HasHash       bool
+	IndexedFields []Field
+}
+
+// NewEvent creates an Event that was not read from a journal,
+// e.g. to pass it to a Sink. Raw and IndexedFields are not copied.
+func NewEvent(i EventInfo) Event {
+	return Event{
+		messageLength:   uint64(len(i.Raw)),
+		hasHash:         i.HasHash,
+		hash:            i.Hash,
+		streamID:        i.StreamID,
+		streamOffset:    i.StreamOffset,
+		streamSubOffset: i.StreamSubOffset,
+		indexTime:       i.Time.Unix(),
+		subSeconds:      uint64(i.Time.Nanosecond()) / uint64(subSecondUnit),
+		message:         i.Raw,
+		host:            i.Host,
+		source:          i.Source,
+		sourceType:      i.SourceType,
+		bucket:          i.Bucket,
+		fields:          i.IndexedFields,
+	}
 }
 
 func (e Event) String() string {
@@ -195,11 +350,103 @@ func (e Event) MessageString() string {
 	return bytesToStr(e.message)
 }
 
-func (e Event) reset() {
+func (e Event) Host() string {
+	return e.host
+}
+
+func (e Event) Source() string {
+	return e.source
+}
+
+func (e Event) SourceType() string {
+	return e.sourceType
+}
+
+// Bucket returns the bucket the event was read from.
+func (e Event) Bucket() Bucket {
+	if e.bucket == nil {
+		return Bucket{}
+	}
+	return *e.bucket
+}
+
+// subSecondUnit is the resolution of the subSeconds of an event
+const (
+	subSecondUnit   = time.Microsecond
+	subSecondDigits = 6
+)
+
+// Time returns the _time of the event.
+func (e Event) Time() time.Time {
+	return time.Unix(e.indexTime, int64(e.subSeconds)*int64(subSecondUnit))
+}
+
+// Epoch returns the _time of the event as unix timestamp with the
+// subseconds as fraction, e.g. 1700000000.123456.
+func (e Event) Epoch() string {
+	t := e.Time()
+	sec, frac := t.Unix(), int64(t.Nanosecond())/int64(subSecondUnit)
+	if frac == 0 {
+		return strconv.FormatInt(sec, 10)
+	}
+
+	var b []byte
+	// the fraction of a negative time counts towards zero,
+	// e.g. -1.25 is Unix(-2, 750ms)
+	if sec < 0 {
+		b = append(b, '-')
+		sec, frac = -(sec + 1), int64(time.Second/subSecondUnit)-frac
+	}
+	b = strconv.AppendInt(b, sec, 10)
+
+	digits := strconv.AppendInt(nil, frac, 10)
+	b = append(b, '.')
+	for i := len(digits); i < subSecondDigits; i++ {
+		b = append(b, '0')
+	}
+	return string(append(b, digits...))
+}
+
+// Hash returns the hash of the event, if the journal stored one.
+func (e Event) Hash() ([hashSize]byte, bool) {
+	return e.hash, e.hasHash
+}
+
+func (e Event) StreamID() uint64 {
+	return e.streamID
+}
+
+func (e Event) StreamOffset() uint64 {
+	return e.streamOffset
+}
+
+func (e Event) StreamSubOffset() uint64 {
+	return e.streamSubOffset
+}
+
+// IndexedFields returns the indexed fields stored with the event.
+// Decoding them is a best effort, as the encoding is not documented.
+func (e Event) IndexedFields() []Field {
+	return e.fields
+}
+
+// Clone returns a copy of e that is not modified by further calls to Scan.
+func (e Event) Clone() Event {
+	e.message = append([]byte(nil), e.message...)
+	if len(e.fields) > 0 {
+		e.fields = append([]Field(nil), e.fields...)
+	} else {
+		e.fields = nil
+	}
+	return e
+}
+
+func (e *Event) reset() {
 	e.messageLength = 0
 	e.hasExtendedStorage = false
 	e.extendedStorageLen = 0
 	e.hasHash = false
+	e.hash = [hashSize]byte{}
 	e.streamID = 0
 	e.streamOffset = 0
 	e.streamSubOffset = 0
@@ -208,6 +455,11 @@ func (e Event) reset() {
 	e.metadataCount = 0
 	e.message = e.message[:0]
 	e.includePunctuation = false
+	e.host = ""
+	e.source = ""
+	e.sourceType = ""
+	e.bucket = nil
+	e.fields = e.fields[:0]
 }
 
 // Event returns a struct filled with the current event data.
@@ -216,17 +468,136 @@ func (jd *JournalDecoder) Event() Event {
 	return jd.e
 }
 
-func openJournal(name string) (io.Reader, error) {
-	p := filepath.Join(name, "rawdata", "journal.zst")
-	file, err := os.Open(p)
+// Reset makes jd decode the journal of the bucket name.
+// The buffers and the zstd decoder of the previous journal are reused.
+func (jd *JournalDecoder) Reset(name string) error {
+	f, err := os.Open(filepath.Join(name, "rawdata", "journal.zst"))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	zstdReader, err := zstd.NewReader(file, zstd.WithDecoderConcurrency(0))
-	if err != nil {
-		return nil, fmt.Errorf("zstd.NewReader: %v", err)
+	if err := jd.reset(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	jd.closeFile()
+	jd.fMu.Lock()
+	jd.f = f
+	jd.fMu.Unlock()
+	jd.n = name
+	// a bucket with an unknown name is still usable,
+	// just without the information from the name
+	b, _ := ParseBucket(name)
+	jd.bucket = &b
+
+	return nil
+}
+
+// ResetReader makes jd decode the zstd compressed journal read from r.
+// The buffers and the zstd decoder of the previous journal are reused.
+func (jd *JournalDecoder) ResetReader(r io.Reader) error {
+	if err := jd.reset(r); err != nil {
+		return err
+	}
+
+	jd.closeFile()
+	jd.n = ""
+	jd.bucket = nil
+
+	return nil
+}
+
+// resetBucket is like Reset, but attributes the events to b
+func (jd *JournalDecoder) resetBucket(b *Bucket) error {
+	if err := jd.Reset(b.Path); err != nil {
+		return err
 	}
 
-	return zstdReader, nil
+	jd.bucket = b
+	return nil
+}
+
+func (jd *JournalDecoder) reset(r io.Reader) error {
+	if jd.zr == nil {
+		opts := append([]zstd.DOption{zstd.WithDecoderConcurrency(0)}, jd.zstdOpts...)
+		zr, err := zstd.NewReader(r, opts...)
+		if err != nil {
+			return fmt.Errorf("zstd.NewReader: %v", err)
+		}
+		jd.zr = zr
+	} else if err := jd.zr.Reset(r); err != nil {
+		return fmt.Errorf("zstd.Reset: %v", err)
+	}
+
+	if jd.cr == nil {
+		jd.cr = newCountedReader(jd.zr)
+	} else {
+		jd.cr.reset(jd.zr)
+	}
+
+	jd.err = nil
+	jd.opcode = 0
+	jd.e.reset()
+
+	// a context of the previous journal, e.g. one that was cancelled,
+	// does not apply to the next one
+	if jd.stopCtx != nil {
+		jd.stopCtx()
+	}
+	jd.ctx = nil
+	jd.stopCtx = nil
+
+	// keep the slices to reuse their capacity
+	for o, f := range jd.s.fields {
+		jd.s.fields[o] = f[:0]
+	}
+	jd.s.baseTime = 0
+	jd.s.activeHost = 0
+	jd.s.activeSource = 0
+	jd.s.activeSourceType = 0
+
+	return nil
+}
+
+// ErrClosed is returned by JournalDecoder.Err after Close.
+var ErrClosed = errors.New("journal decoder is closed")
+
+// Close closes the journal file and releases the zstd decoder. Closing a
+// closed decoder does nothing. Scan returns false after Close, the
+// JournalDecoder can be reused by calling Reset.
+func (jd *JournalDecoder) Close() error {
+	jd.err = ErrClosed
+
+	if jd.stopCtx != nil {
+		jd.stopCtx()
+		jd.ctx = nil
+		jd.stopCtx = nil
+	}
+
+	if jd.zr != nil {
+		jd.zr.Close()
+		jd.zr = nil
+	}
+
+	jd.fMu.Lock()
+	defer jd.fMu.Unlock()
+
+	if jd.f == nil {
+		return nil
+	}
+
+	err := jd.f.Close()
+	jd.f = nil
+	return err
+}
+
+func (jd *JournalDecoder) closeFile() {
+	jd.fMu.Lock()
+	defer jd.fMu.Unlock()
+
+	if jd.f != nil {
+		_ = jd.f.Close()
+		jd.f = nil
+	}
 }
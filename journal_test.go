@@ -0,0 +1,183 @@
+package splunker
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventEpoch(t *testing.T) {
+	for _, tc := range []struct {
+		time time.Time
+		want string
+	}{
+		{time.Unix(1700000000, 0), "1700000000"},
+		{time.Unix(1700000000, 123456000), "1700000000.123456"},
+		{time.Unix(1700000000, 5000), "1700000000.000005"},
+		{time.Unix(0, 0), "0"},
+		{time.Unix(-2, 0), "-2"},
+		{time.Unix(-2, 750000000), "-1.250000"},
+		{time.Unix(-1, 500000000), "-0.500000"},
+		{time.Unix(-1, 999999000), "-0.000001"},
+	} {
+		e := NewEvent(EventInfo{Time: tc.time})
+		assert.Equal(t, tc.want, e.Epoch(), tc.time)
+	}
+}
+
+// writeJournal writes the events of infos with raw injected after the first one
+func writeJournal(t *testing.T, raw []byte, infos ...EventInfo) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	jw, err := NewJournalWriter(&buf)
+	require.NoError(t, err)
+	for i, info := range infos {
+		require.NoError(t, jw.WriteEvent(NewEvent(info)))
+		if i == 0 {
+			jw.size += int64(len(raw))
+			_, err = jw.zw.Write(raw)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, jw.Close())
+	return buf.Bytes()
+}
+
+type decoded struct {
+	events   []Event
+	privates []string
+}
+
+func collect(t *testing.T, jd *JournalDecoder, privates *[]string) decoded {
+	t.Helper()
+
+	*privates = nil
+	var d decoded
+	for e, err := range jd.All() {
+		require.NoError(t, err)
+		d.events = append(d.events, e.Clone())
+	}
+	d.privates = *privates
+	return d
+}
+
+func TestJournalDecoderReset(t *testing.T) {
+	first := writeJournal(t, lengthPrefixed(OpcodeSplunkPrivate, []byte("bookkeeping")),
+		EventInfo{Time: time.Unix(1700000000, 0), Host: "web01", Source: "/var/log/a.log", SourceType: "a",
+			Raw: []byte("a long first message"), IndexedFields: []Field{{Name: "env", Value: "prod"}}},
+		EventInfo{Time: time.Unix(1700000001, 0), Host: "web02", Raw: []byte("second"), HasHash: true, Hash: [hashSize]byte{1}},
+	)
+	// no host, source or sourcetype, so a decoder keeping the tables or
+	// the active entries of the first journal would attribute them
+	second := writeJournal(t, lengthPrefixed(OpcodeSplunkPrivate, []byte("bk")),
+		EventInfo{Time: time.Unix(1600000000, 0), Raw: []byte("short"), IndexedFields: []Field{{Name: "user", Value: "root"}}},
+		EventInfo{Time: time.Unix(1600000001, 0), Raw: []byte("x")},
+	)
+
+	var privates []string
+	opt := WithPrivateHandler(func(p SplunkPrivate) error {
+		privates = append(privates, string(p.Data))
+		return nil
+	})
+
+	var fresh []decoded
+	for _, journal := range [][]byte{first, second} {
+		jd, err := NewJournalDecoderFromReader(bytes.NewReader(journal), opt)
+		require.NoError(t, err)
+		fresh = append(fresh, collect(t, jd, &privates))
+		require.NoError(t, jd.Close())
+	}
+	assert.Equal(t, []string{"bk"}, fresh[1].privates)
+	assert.Empty(t, fresh[1].events[0].Host())
+
+	jd, err := NewJournalDecoderFromReader(bytes.NewReader(first), opt)
+	require.NoError(t, err)
+	defer jd.Close()
+	assert.Equal(t, fresh[0], collect(t, jd, &privates))
+
+	require.NoError(t, jd.ResetReader(bytes.NewReader(second)))
+	assert.Equal(t, fresh[1], collect(t, jd, &privates))
+
+	// Reset reads the journal of a bucket directory
+	b := writeBucket(t, first)
+	require.NoError(t, jd.Reset(b.Path))
+	got := collect(t, jd, &privates)
+	require.Len(t, got.events, len(fresh[0].events))
+	for i, e := range got.events {
+		assert.Equal(t, b.Path, e.Bucket().Path)
+		e.bucket = nil
+		assert.Equal(t, fresh[0].events[i], e)
+	}
+	assert.Equal(t, fresh[0].privates, got.privates)
+}
+
+func TestJournalDecoderClose(t *testing.T) {
+	journal, _ := testJournal(t, nil)
+	b := writeBucket(t, journal)
+	files := openFiles(t)
+
+	jd, err := NewJournalDecoder(b.Path)
+	require.NoError(t, err)
+	require.True(t, jd.Scan())
+
+	require.NoError(t, jd.Close())
+	assert.Equal(t, files, openFiles(t))
+	require.NoError(t, jd.Close())
+
+	// Scan stops even if the reader still buffers entries
+	assert.False(t, jd.Scan())
+	assert.ErrorIs(t, jd.Err(), ErrClosed)
+
+	require.NoError(t, jd.Reset(b.Path))
+	n := 0
+	for jd.Scan() {
+		n++
+	}
+	require.NoError(t, jd.Err())
+	assert.Equal(t, 2, n)
+	require.NoError(t, jd.Close())
+}
+
+// rewindow recompresses journal with a window of size bytes
+func rewindow(t *testing.T, journal []byte, size int) []byte {
+	t.Helper()
+
+	zr, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer zr.Close()
+	raw, err := zr.DecodeAll(journal, nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf, zstd.WithWindowSize(size))
+	require.NoError(t, err)
+	_, err = zw.Write(raw)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestJournalDecoderLimits(t *testing.T) {
+	// Nop opcodes make the journal bigger than the window
+	journal, _ := testJournal(t, make([]byte, 4<<20))
+	journal = rewindow(t, journal, 8<<20)
+
+	for name, opts := range map[string][]Option{
+		"window": {WithMaxWindow(1 << 20)},
+		"memory": {WithMaxMemory(1 << 20)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeAll(t, journal, opts...)
+			assert.ErrorIs(t, err, zstd.ErrWindowSizeExceeded)
+		})
+	}
+
+	messages, err := decodeAll(t, journal, WithMaxWindow(8<<20), WithConcurrency(1))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, messages)
+}
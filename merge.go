@@ -0,0 +1,282 @@
+package splunker
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// ReaderOption configures an IndexReader.
+type ReaderOption func(r *IndexReader)
+
+// WithWorkers sets the number of buckets that are decoded concurrently.
+// It defaults to GOMAXPROCS.
+func WithWorkers(n int) ReaderOption {
+	return func(r *IndexReader) {
+		r.workers = max(n, 1)
+	}
+}
+
+// WithTimeOrder makes the IndexReader emit the events ordered by their _time.
+// As events are not sorted within a journal, all buckets have to be decoded
+// before the first event can be emitted.
+func WithTimeOrder() ReaderOption {
+	return func(r *IndexReader) {
+		r.ordered = true
+	}
+}
+
+// WithMaxBufferedEvents limits the number of events kept in memory while
+// sorting. Additional events are spilled to temporary files.
+// It defaults to 100000.
+func WithMaxBufferedEvents(n int) ReaderOption {
+	return func(r *IndexReader) {
+		r.maxBuffered = max(n, 1)
+	}
+}
+
+// WithSpillDir sets the directory for the temporary files used for sorting.
+// It defaults to os.TempDir.
+func WithSpillDir(dir string) ReaderOption {
+	return func(r *IndexReader) {
+		r.spillDir = dir
+	}
+}
+
+// WithDecoderOptions sets the options for the JournalDecoder of every worker.
+// By default every zstd decoder uses a single goroutine.
+func WithDecoderOptions(opts ...Option) ReaderOption {
+	return func(r *IndexReader) {
+		r.decoderOpts = append(r.decoderOpts, opts...)
+	}
+}
+
+// IndexReader decodes multiple buckets concurrently.
+type IndexReader struct {
+	groups      []BucketGroup
+	workers     int
+	ordered     bool
+	maxBuffered int
+	spillDir    string
+	decoderOpts []Option
+}
+
+// NewIndexReader creates an IndexReader for buckets, which may be taken
+// from multiple indexes.
+func NewIndexReader(buckets []Bucket, opts ...ReaderOption) *IndexReader {
+	groups := make([]BucketGroup, len(buckets))
+	for i, b := range buckets {
+		groups[i] = BucketGroup{Index: b.Index, ID: b.ID, GUID: b.GUID, Copies: []Bucket{b}}
+	}
+
+	return NewGroupReader(groups, opts...)
+}
+
+// NewGroupReader creates an IndexReader for the copies of clustered
+// buckets, see GroupBuckets. Every group is read once like BucketGroup.All
+// does, so replicated events are only emitted once.
+func NewGroupReader(groups []BucketGroup, opts ...ReaderOption) *IndexReader {
+	r := &IndexReader{
+		groups:      groups,
+		workers:     runtime.GOMAXPROCS(0),
+		maxBuffered: 100000,
+		decoderOpts: []Option{WithConcurrency(1)},
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// All returns an iterator over the events of all buckets.
+// Buckets without a journal are skipped.
+// Unlike JournalDecoder.All, the yielded events stay valid.
+func (r *IndexReader) All() iter.Seq2[Event, error] {
+	return r.AllContext(context.Background())
+}
+
+// AllContext is like All, but stops once ctx is done.
+func (r *IndexReader) AllContext(ctx context.Context) iter.Seq2[Event, error] {
+	if r.ordered {
+		return r.sorted(ctx)
+	}
+	return r.unordered(ctx)
+}
+
+type eventOrErr struct {
+	e   Event
+	err error
+}
+
+// unordered emits the events as soon as one of the workers decoded them
+func (r *IndexReader) unordered(ctx context.Context) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		events := make(chan eventOrErr, r.workers*64)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer close(events)
+
+			err := r.run(ctx, func(int) func(Event) error {
+				return func(e Event) error {
+					select {
+					case events <- eventOrErr{e: e.Clone()}:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				events <- eventOrErr{err: err}
+			}
+		}()
+		// wait for the workers to release their journals
+		defer func() {
+			cancel()
+			for range events {
+			}
+			<-done
+		}()
+
+		for e := range events {
+			// stop early instead of draining the buffered events
+			if ctx.Err() != nil {
+				break
+			}
+			if !yield(e.e, e.err) || e.err != nil {
+				return
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			yield(Event{}, err)
+		}
+	}
+}
+
+// sorted sorts the events of each worker and merges them afterwards
+func (r *IndexReader) sorted(ctx context.Context) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		less := func(a, b *Event) bool {
+			if a.indexTime != b.indexTime {
+				return a.indexTime < b.indexTime
+			}
+			return a.subSeconds < b.subSeconds
+		}
+
+		buckets := &bucketTable{}
+		sorters := make([]*spillSorter, r.workers)
+		for i := range sorters {
+			sorters[i] = newSpillSorter(less, r.maxBuffered/r.workers, r.spillDir, buckets)
+		}
+		defer func() {
+			for _, s := range sorters {
+				s.close()
+			}
+		}()
+
+		err := r.run(ctx, func(worker int) func(Event) error {
+			return sorters[worker].add
+		})
+		if err != nil {
+			yield(Event{}, err)
+			return
+		}
+
+		for e, err := range mergeSorted(less, sorters...) {
+			if err == nil {
+				err = ctx.Err()
+			}
+			if !yield(e, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// run decodes the buckets with r.workers goroutines and passes the events
+// to the emit function of the worker. The first error stops all workers.
+func (r *IndexReader) run(ctx context.Context, emitter func(worker int) func(Event) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan *BucketGroup, len(r.groups))
+	for i := range r.groups {
+		jobs <- &r.groups[i]
+	}
+	close(jobs)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			if err := r.decodeBuckets(ctx, jobs, emitter(worker)); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+func (r *IndexReader) decodeBuckets(ctx context.Context, jobs <-chan *BucketGroup, emit func(Event) error) error {
+	jd := newJournalDecoder(r.decoderOpts)
+	defer jd.Close()
+
+	for g := range jobs {
+		// copies that differ are deduplicated, see BucketGroup.AllContext
+		if len(g.Copies) > 1 && !g.Clean() {
+			for e, err := range g.AllContext(ctx, r.decoderOpts...) {
+				if err != nil {
+					return fmt.Errorf("bucket %d of %s: %w", g.ID, g.Index, err)
+				}
+				if err := emit(e); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		b := &g.Copies[0]
+		err := ctx.Err()
+		if err == nil {
+			err = jd.resetBucket(b)
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("%s: %w", b.Path, err)
+		}
+
+		for jd.ScanContext(ctx) {
+			if err := emit(jd.Event()); err != nil {
+				return err
+			}
+		}
+		if err := jd.Err(); err != nil {
+			return fmt.Errorf("%s: %w", b.Path, err)
+		}
+	}
+
+	return nil
+}
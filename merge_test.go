@@ -0,0 +1,126 @@
+package splunker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIndex writes n events with shuffled times into multiple buckets
+func testIndex(t *testing.T, n int) []Bucket {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "main")
+	w, err := NewIndexWriter(dir, WithMaxBucketSize(500))
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		// a permutation of 0..n-1 as n is not a multiple of 7
+		sec := i * 7 % n
+		require.NoError(t, w.WriteEvent(NewEvent(EventInfo{
+			Time:         time.Unix(1700000000+int64(sec), 0),
+			Host:         "web01",
+			Source:       "/var/log/app.log",
+			SourceType:   "app",
+			Raw:          []byte(fmt.Sprintf("event %d", sec)),
+			StreamOffset: uint64(i) * 10,
+		})))
+	}
+	require.NoError(t, w.Close())
+
+	idx, err := OpenIndex(dir)
+	require.NoError(t, err)
+	buckets := idx.Buckets()
+	require.Greater(t, len(buckets), 2)
+	return buckets
+}
+
+func TestIndexReaderUnordered(t *testing.T) {
+	const n = 100
+	r := NewIndexReader(testIndex(t, n), WithWorkers(3))
+
+	seen := make(map[string]bool)
+	for e, err := range r.All() {
+		require.NoError(t, err)
+		require.NotNil(t, e.Bucket())
+		seen[e.MessageString()] = true
+	}
+	assert.Len(t, seen, n)
+	for i := 0; i < n; i++ {
+		assert.True(t, seen[fmt.Sprintf("event %d", i)], i)
+	}
+}
+
+func TestIndexReaderTimeOrder(t *testing.T) {
+	defer func(n int) { mergeFanIn = n }(mergeFanIn)
+	mergeFanIn = 3
+
+	const n = 100
+	spill := t.TempDir()
+	r := NewIndexReader(testIndex(t, n),
+		WithWorkers(2),
+		WithTimeOrder(),
+		WithMaxBufferedEvents(10),
+		WithSpillDir(spill),
+	)
+
+	i := 0
+	for e, err := range r.All() {
+		require.NoError(t, err)
+		assert.Equal(t, time.Unix(1700000000+int64(i), 0), e.Time())
+		assert.Equal(t, fmt.Sprintf("event %d", i), e.MessageString())
+		i++
+	}
+	assert.Equal(t, n, i)
+
+	files, err := os.ReadDir(spill)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestIndexReaderCancel(t *testing.T) {
+	buckets := testIndex(t, 100)
+
+	for name, opts := range map[string][]ReaderOption{
+		"unordered": {WithWorkers(2)},
+		"ordered":   {WithWorkers(2), WithTimeOrder()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			var err error
+			for _, err = range NewIndexReader(buckets, opts...).AllContext(ctx) {
+				if err != nil {
+					break
+				}
+			}
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+	}
+
+	t.Run("while reading", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var (
+			events int
+			err    error
+		)
+		for _, err = range NewIndexReader(buckets, WithWorkers(2)).AllContext(ctx) {
+			if err != nil {
+				break
+			}
+			if events++; events == 10 {
+				cancel()
+			}
+		}
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Less(t, events, 100)
+	})
+}
@@ -2,6 +2,9 @@ package splunker
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
 	"github.com/fionera/splunker/varint"
 )
@@ -39,10 +42,17 @@ func (r RawdataMetaKeyItemType) isFloatType() bool {
 	return (r.representation & 0x2) != 0
 }
 
-func readMetadata(peek []byte, o byte) (peekOffset int, err error) {
+// metadataItem is a single metadata entry of an event
+type metadataItem struct {
+	key    uint64
+	t      RawdataMetaKeyItemType
+	values [3]int64
+}
+
+func readMetadata(peek []byte, o byte) (item metadataItem, peekOffset int, err error) {
 	metaKey, n := varint.Uvarint(peek)
 	if n == -1 {
-		return 0, fmt.Errorf("cant read varint")
+		return item, 0, fmt.Errorf("cant read varint")
 	}
 	peekOffset += n
 
@@ -50,32 +60,90 @@ func readMetadata(peek []byte, o byte) (peekOffset int, err error) {
 
 	if o <= 2 {
 		metaKey <<= 3
-		//TODO: Add metaKey
+		item.t = rmkiTypeString
 		numToRead = 1
 	} else {
 		if o < 36 {
 			metaKey <<= 2
 		}
-		//TODO Add metaKey
 
-		t := getTypeFromCombined(metaKey)
-		numToRead = t.extraIntsNeeded
+		item.t = getTypeFromCombined(metaKey)
+		numToRead = item.t.extraIntsNeeded
 	}
+	item.key = metaKey
 
 	for i := 0; i < numToRead; i++ {
 		long, n := varint.Varint(peek[peekOffset:])
 		if n == -1 {
-			return 0, fmt.Errorf("cant read varint")
+			return item, 0, fmt.Errorf("cant read varint")
 		}
 		peekOffset += n
 
-		//long, err := binary.ReadVarint(r)
-		//if err != nil {
-		//	return err
-		//}
-		//TODO add long
-		_ = long
+		if i < len(item.values) {
+			item.values[i] = long
+		}
+	}
+
+	return item, peekOffset, nil
+}
+
+// Field is an indexed field of an event.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// fieldSeparator separates name and value of indexed fields stored as a single string
+const fieldSeparator = "::"
+
+// resolveMetadata converts the metadata of the current event to indexed fields
+// and appends them to fields.
+//
+// The encoding is not documented, so this is a best effort: the upper bits of
+// the key reference the name in the string table. String values reference the
+// string table as well, offset/length values a part of _raw and numbers are
+// stored inline.
+func (jd *JournalDecoder) resolveMetadata(fields []Field) []Field {
+	for _, m := range jd.meta {
+		name := jd.field(OpcodeNewString, m.key>>4)
+		var value string
+
+		switch m.t {
+		case rmkiTypeString:
+			if v := m.values[0]; v > 0 {
+				value = jd.field(OpcodeNewString, uint64(v))
+			}
+		case rmkiTypeOffsetLen, rmkiTypeOffsetLenWencoding:
+			start, l := m.values[0], m.values[1]
+			if start >= 0 && l >= 0 && start+l <= int64(len(jd.e.message)) {
+				value = string(jd.e.message[start : start+l])
+			}
+		case rmkiTypeUnsigned:
+			value = strconv.FormatUint(uint64(m.values[0]), 10)
+		case rmkiTypeSigned:
+			value = strconv.FormatInt(m.values[0], 10)
+		default:
+			if m.t.isFloatType() {
+				if m.t.representation < rmkiTypeFloat64.representation {
+					value = strconv.FormatFloat(float64(math.Float32frombits(uint32(m.values[0]))), 'g', -1, 32)
+				} else {
+					value = strconv.FormatFloat(math.Float64frombits(uint64(m.values[0])), 'g', -1, 64)
+				}
+			}
+		}
+
+		// fields can be stored as a single name::value string
+		if value == "" {
+			if n, v, ok := strings.Cut(name, fieldSeparator); ok {
+				name, value = n, v
+			}
+		}
+
+		if name == "" {
+			continue
+		}
+		fields = append(fields, Field{Name: name, Value: value})
 	}
 
-	return peekOffset, nil
+	return fields
 }
@@ -0,0 +1,48 @@
+package splunker
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveMetadata(t *testing.T) {
+	jd := &JournalDecoder{}
+	jd.s.fields = map[byte][]string{
+		byte(OpcodeNewString): {"user", "alice", "status", "env::prod", "took", "ratio", "delta", "load"},
+	}
+	jd.e.message = []byte("GET / 200 OK")
+
+	// the upper bits of the key are the id of the name in the string table
+	key := func(name uint64, t RawdataMetaKeyItemType) uint64 {
+		return name<<4 | uint64(t.representation)
+	}
+	jd.meta = []metadataItem{
+		{key: key(1, rmkiTypeString), t: rmkiTypeString, values: [3]int64{2}},
+		// name::value strings without a value
+		{key: key(4, rmkiTypeString), t: rmkiTypeString},
+		{key: key(3, rmkiTypeOffsetLen), t: rmkiTypeOffsetLen, values: [3]int64{6, 3}},
+		// out of the bounds of _raw
+		{key: key(3, rmkiTypeOffsetLenWencoding), t: rmkiTypeOffsetLenWencoding, values: [3]int64{10, 5}},
+		{key: key(5, rmkiTypeUnsigned), t: rmkiTypeUnsigned, values: [3]int64{1500}},
+		{key: key(7, rmkiTypeSigned), t: rmkiTypeSigned, values: [3]int64{-3}},
+		{key: key(6, rmkiTypeFloat32), t: rmkiTypeFloat32, values: [3]int64{int64(math.Float32bits(1.5))}},
+		{key: key(8, rmkiTypeFloat64Precision), t: rmkiTypeFloat64Precision, values: [3]int64{int64(math.Float64bits(0.25)), 2}},
+		// unknown names are dropped
+		{key: key(99, rmkiTypeUnsigned), t: rmkiTypeUnsigned, values: [3]int64{1}},
+	}
+
+	fields := jd.resolveMetadata([]Field{{"existing", "field"}})
+	assert.Equal(t, []Field{
+		{"existing", "field"},
+		{"user", "alice"},
+		{"env", "prod"},
+		{"status", "200"},
+		{"status", ""},
+		{"took", "1500"},
+		{"delta", "-3"},
+		{"ratio", "1.5"},
+		{"load", "0.25"},
+	}, fields)
+}
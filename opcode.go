@@ -1,5 +1,11 @@
 package splunker
 
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
 //go:generate stringer -type=Opcode
 type Opcode byte
 
@@ -20,37 +26,104 @@ const (
 func fetchDecoder(o Opcode) Decoder {
 	switch o {
 	case OpcodeHeader:
-		return decoderFunc((*JournalDecoder).headerDecoder)
+		return DecoderFunc((*JournalDecoder).headerDecoder)
 	case OpcodeSplunkPrivate:
-		return decoderFunc((*JournalDecoder).splunkPrivateDecoder)
+		return DecoderFunc((*JournalDecoder).splunkPrivateDecoder)
 	case OpcodeNewHost:
-		return decoderFunc((*JournalDecoder).hostDecoder)
+		return DecoderFunc((*JournalDecoder).hostDecoder)
 	case OpcodeNewSource:
-		return decoderFunc((*JournalDecoder).sourceDecoder)
+		return DecoderFunc((*JournalDecoder).sourceDecoder)
 	case OpcodeNewSourceType:
-		return decoderFunc((*JournalDecoder).sourceTypeDecoder)
+		return DecoderFunc((*JournalDecoder).sourceTypeDecoder)
 	case OpcodeNewString:
-		return decoderFunc((*JournalDecoder).stringDecoder)
+		return DecoderFunc((*JournalDecoder).stringDecoder)
 	case OpcodeOldstyleEvent:
-		return decoderFunc((*JournalDecoder).eventDecoder)
+		return DecoderFunc((*JournalDecoder).eventDecoder)
 	case OpcodeOldstyleEventWithHash:
-		return decoderFunc((*JournalDecoder).eventDecoder)
+		return DecoderFunc((*JournalDecoder).eventDecoder)
 	case OpcodeNop:
-		return decoderFunc(nil)
+		return DecoderFunc(nil)
 	}
 	return nil
 }
 
+// Decoder decodes the entry of a single opcode. The opcode itself has
+// already been consumed from the reader when Decode is called. Only
+// EventDecoder produces events, other decoders can only consume entries.
 type Decoder interface {
 	Decode(*JournalDecoder, *CountedReader, byte) error
 }
 
-type decoderFunc func(*JournalDecoder, *CountedReader, byte) error
+// DecoderFunc is an adapter to use ordinary functions as Decoder.
+// A nil DecoderFunc decodes entries without any payload.
+type DecoderFunc func(*JournalDecoder, *CountedReader, byte) error
 
-func (d decoderFunc) Decode(j *JournalDecoder, r *CountedReader, o byte) error {
+func (d DecoderFunc) Decode(j *JournalDecoder, r *CountedReader, o byte) error {
 	if d == nil {
 		return nil
 	}
 
 	return d(j, r, o)
 }
+
+// SkipDecoder skips entries that are prefixed with their uvarint encoded length,
+// like OpcodeSplunkPrivate.
+var SkipDecoder Decoder = DecoderFunc(skipDecoder)
+
+func skipDecoder(_ *JournalDecoder, r *CountedReader, o byte) error {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if l > math.MaxInt32 {
+		return fmt.Errorf("opcode 0x%02x at %d: length %d exceeds %d bytes", o, r.pos, l, math.MaxInt32)
+	}
+
+	_, err = r.Discard(int(l))
+	return err
+}
+
+// EventDecoder decodes an event in the layout of the builtin event opcodes.
+// A Decoder can not fill the Event returned by JournalDecoder.Event, so
+// registering EventDecoder is the only way to make a new opcode yield events.
+var EventDecoder Decoder = eventDecoder{}
+
+type eventDecoder struct{}
+
+func (eventDecoder) Decode(jd *JournalDecoder, r *CountedReader, o byte) error {
+	return jd.eventDecoder(r, o)
+}
+
+// RegisterDecoder makes jd use d for o instead of the builtin decoder.
+// Entries of o yield an event only if d is EventDecoder, so overriding a
+// builtin event opcode with another Decoder drops its events.
+func (jd *JournalDecoder) RegisterDecoder(o Opcode, d Decoder) {
+	jd.decoders[o] = d
+	_, jd.events[o] = d.(eventDecoder)
+}
+
+// RegisterDecoderRange registers d for all opcodes from lo to hi inclusive.
+// This can be used to handle opcodes unknown to this package, e.g.
+// RegisterDecoderRange(44, 255, SkipDecoder).
+func (jd *JournalDecoder) RegisterDecoderRange(lo, hi Opcode, d Decoder) {
+	for o := int(lo); o <= int(hi); o++ {
+		jd.RegisterDecoder(Opcode(o), d)
+	}
+}
+
+// WithDecoder registers d for o, see JournalDecoder.RegisterDecoder.
+// Unlike RegisterDecoder it reaches the decoders created by Bucket.All,
+// Index.All and IndexReader.
+func WithDecoder(o Opcode, d Decoder) Option {
+	return func(jd *JournalDecoder) {
+		jd.RegisterDecoder(o, d)
+	}
+}
+
+// WithDecoderRange registers d for all opcodes from lo to hi inclusive,
+// see JournalDecoder.RegisterDecoderRange.
+func WithDecoderRange(lo, hi Opcode, d Decoder) Option {
+	return func(jd *JournalDecoder) {
+		jd.RegisterDecoderRange(lo, hi, d)
+	}
+}
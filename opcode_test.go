@@ -0,0 +1,162 @@
+package splunker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterDecoder(t *testing.T) {
+	const custom Opcode = 50
+	journal, _ := testJournal(t, lengthPrefixed(custom, []byte("payload")))
+
+	_, err := decodeAll(t, journal)
+	assert.ErrorContains(t, err, "unknown opcode: 0x32")
+
+	var got []string
+	jd, err := NewJournalDecoderFromReader(bytes.NewReader(journal))
+	require.NoError(t, err)
+	defer jd.Close()
+	jd.RegisterDecoder(custom, DecoderFunc(func(_ *JournalDecoder, r *CountedReader, o byte) error {
+		assert.Equal(t, byte(custom), o)
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, l)
+		if _, err := r.Read(b); err != nil {
+			return err
+		}
+		got = append(got, string(b))
+		return nil
+	}))
+
+	var messages []string
+	for jd.Scan() {
+		messages = append(messages, string(jd.Event().Message()))
+	}
+	require.NoError(t, jd.Err())
+	assert.Equal(t, []string{"first", "second"}, messages)
+	assert.Equal(t, []string{"payload"}, got)
+}
+
+func TestRegisterDecoderRange(t *testing.T) {
+	journal, _ := testJournal(t, lengthPrefixed(200, []byte("payload")))
+
+	jd, err := NewJournalDecoderFromReader(bytes.NewReader(journal))
+	require.NoError(t, err)
+	defer jd.Close()
+	jd.RegisterDecoderRange(44, 255, SkipDecoder)
+
+	var messages []string
+	for jd.Scan() {
+		messages = append(messages, string(jd.Event().Message()))
+	}
+	require.NoError(t, jd.Err())
+	assert.Equal(t, []string{"first", "second"}, messages)
+}
+
+func TestRegisterDecoderOverride(t *testing.T) {
+	journal, _ := testJournal(t, lengthPrefixed(OpcodeSplunkPrivate, []byte("bookkeeping")))
+
+	jd, err := NewJournalDecoderFromReader(bytes.NewReader(journal), WithPrivateHandler(func(SplunkPrivate) error {
+		t.Fatal("builtin decoder called")
+		return nil
+	}))
+	require.NoError(t, err)
+	defer jd.Close()
+
+	// a registered decoder replaces the builtin one
+	jd.RegisterDecoder(OpcodeSplunkPrivate, SkipDecoder)
+	n := 0
+	for jd.Scan() {
+		n++
+	}
+	require.NoError(t, jd.Err())
+	assert.Equal(t, 2, n)
+}
+
+// writeBucket stores journal as the journal of a new bucket and returns it
+func writeBucket(t *testing.T, journal []byte) Bucket {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "db_1700000000_1700000000_0")
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "rawdata"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "rawdata", "journal.zst"), journal, 0o644))
+	b, err := ParseBucket(path)
+	require.NoError(t, err)
+	return b
+}
+
+func bucketMessages(t *testing.T, b Bucket, opts ...Option) ([]string, error) {
+	var messages []string
+	for e, err := range b.All(opts...) {
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, string(e.Message()))
+	}
+	return messages, nil
+}
+
+func TestWithDecoder(t *testing.T) {
+	journal, _ := testJournal(t, lengthPrefixed(50, []byte("payload")))
+	b := writeBucket(t, journal)
+
+	_, err := bucketMessages(t, b)
+	assert.ErrorContains(t, err, "unknown opcode: 0x32")
+
+	messages, err := bucketMessages(t, b, WithDecoder(50, SkipDecoder))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, messages)
+
+	messages, err = bucketMessages(t, b, WithDecoderRange(44, 255, SkipDecoder))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, messages)
+}
+
+func TestEventDecoder(t *testing.T) {
+	// an event in the builtin layout without hash and extended storage
+	const custom Opcode = 0x31
+	body := binary.LittleEndian.AppendUint64(nil, 7) // stream id
+	body = append(body, 20, 0, 10, 0, 0)             // offset, suboffset, zigzag time 5, subseconds, metadata
+	body = append(body, "custom"...)
+	journal, _ := testJournal(t, lengthPrefixed(custom, body))
+
+	jd, err := NewJournalDecoderFromReader(bytes.NewReader(journal), WithDecoder(custom, EventDecoder))
+	require.NoError(t, err)
+	defer jd.Close()
+
+	var messages []string
+	for e, err := range jd.All() {
+		require.NoError(t, err)
+		messages = append(messages, string(e.Message()))
+		if string(e.Message()) == "custom" {
+			assert.EqualValues(t, 7, e.StreamID())
+			assert.EqualValues(t, 20, e.StreamOffset())
+			assert.EqualValues(t, 1700000005, e.Time().Unix())
+			assert.Equal(t, "web01", e.Host())
+		}
+	}
+	assert.Equal(t, []string{"first", "custom", "second"}, messages)
+
+	// any other decoder drops the events of a builtin event opcode
+	messages, err = decodeAll(t, journal, WithDecoder(custom, SkipDecoder), WithDecoder(opcodeEvent, SkipDecoder))
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestSkipDecoderCorruptLength(t *testing.T) {
+	entry := binary.AppendUvarint([]byte{50}, math.MaxUint64)
+	journal, _ := testJournal(t, entry)
+
+	messages, err := decodeAll(t, journal, WithDecoder(50, SkipDecoder))
+	assert.ErrorContains(t, err, "exceeds")
+	assert.Equal(t, []string{"first"}, messages)
+}
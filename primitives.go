@@ -1,7 +1,6 @@
 package splunker
 
 import (
-	"reflect"
 	"unsafe"
 )
 
@@ -20,7 +19,5 @@ func readString(r *CountedReader, length uint64) (string, error) {
 // chunk may be either blocked from being freed by GC because of a single string or the buffer.Data
 // may be garbage-collected even when the string exists.
 func bytesToStr(data []byte) string {
-	h := (*reflect.SliceHeader)(unsafe.Pointer(&data))
-	shdr := reflect.StringHeader{Data: h.Data, Len: h.Len}
-	return *(*string)(unsafe.Pointer(&shdr))
+	return *(*string)(unsafe.Pointer(&data))
 }
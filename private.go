@@ -0,0 +1,21 @@
+package splunker
+
+// SplunkPrivate is the payload of an OpcodeSplunkPrivate entry.
+type SplunkPrivate struct {
+	// Offset is the position of the opcode in the decompressed journal
+	Offset int
+	// Data is only valid until the handler returns
+	Data []byte
+}
+
+// PrivateHandler receives every OpcodeSplunkPrivate payload of a journal.
+// Returning an error stops the decoding.
+type PrivateHandler func(p SplunkPrivate) error
+
+// WithPrivateHandler registers h to receive the OpcodeSplunkPrivate payloads
+// instead of discarding them.
+func WithPrivateHandler(h PrivateHandler) Option {
+	return func(jd *JournalDecoder) {
+		jd.privateHandler = h
+	}
+}
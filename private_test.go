@@ -0,0 +1,98 @@
+package splunker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testJournal writes a journal with an event before and after raw.
+// It returns the journal and the offset of raw in the decompressed journal.
+func testJournal(t *testing.T, raw []byte) ([]byte, int) {
+	var buf bytes.Buffer
+	jw, err := NewJournalWriter(&buf)
+	require.NoError(t, err)
+
+	event := func(msg string) Event {
+		return NewEvent(EventInfo{Time: time.Unix(1700000000, 0), Host: "web01", Raw: []byte(msg)})
+	}
+
+	require.NoError(t, jw.WriteEvent(event("first")))
+	offset := int(jw.Size())
+	jw.size += int64(len(raw))
+	_, err = jw.zw.Write(raw)
+	require.NoError(t, err)
+	require.NoError(t, jw.WriteEvent(event("second")))
+	require.NoError(t, jw.Close())
+
+	return buf.Bytes(), offset
+}
+
+// lengthPrefixed returns the entry of opcode o with payload p
+func lengthPrefixed(o Opcode, p []byte) []byte {
+	b := binary.AppendUvarint([]byte{byte(o)}, uint64(len(p)))
+	return append(b, p...)
+}
+
+func decodeAll(t *testing.T, journal []byte, opts ...Option) ([]string, error) {
+	jd, err := NewJournalDecoderFromReader(bytes.NewReader(journal), opts...)
+	require.NoError(t, err)
+	defer jd.Close()
+
+	var messages []string
+	for jd.Scan() {
+		messages = append(messages, string(jd.Event().Message()))
+	}
+	return messages, jd.Err()
+}
+
+func TestPrivateHandler(t *testing.T) {
+	journal, offset := testJournal(t, lengthPrefixed(OpcodeSplunkPrivate, []byte("bookkeeping")))
+
+	var got []SplunkPrivate
+	messages, err := decodeAll(t, journal, WithPrivateHandler(func(p SplunkPrivate) error {
+		p.Data = bytes.Clone(p.Data)
+		got = append(got, p)
+		return nil
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, messages)
+	assert.Equal(t, []SplunkPrivate{{Offset: offset, Data: []byte("bookkeeping")}}, got)
+
+	// without a handler the payload is skipped
+	messages, err = decodeAll(t, journal)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, messages)
+}
+
+func TestPrivateHandlerError(t *testing.T) {
+	journal, _ := testJournal(t, lengthPrefixed(OpcodeSplunkPrivate, []byte("bookkeeping")))
+
+	errStop := errors.New("stop")
+	messages, err := decodeAll(t, journal, WithPrivateHandler(func(SplunkPrivate) error { return errStop }))
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, []string{"first"}, messages)
+}
+
+func TestPrivateCorruptLength(t *testing.T) {
+	for name, l := range map[string]uint64{
+		"overflows int": 1 << 63,
+		"too large":     1 << 40,
+	} {
+		t.Run(name, func(t *testing.T) {
+			entry := binary.AppendUvarint([]byte{byte(OpcodeSplunkPrivate)}, l)
+			journal, _ := testJournal(t, entry)
+
+			for _, opts := range [][]Option{nil, {WithPrivateHandler(func(SplunkPrivate) error { return nil })}} {
+				messages, err := decodeAll(t, journal, opts...)
+				assert.ErrorContains(t, err, "exceeds")
+				assert.Equal(t, []string{"first"}, messages)
+			}
+		})
+	}
+}
@@ -8,12 +8,32 @@ import (
 type CountedReader struct {
 	pos int
 	r   *bufio.Reader
+	pad []byte
+}
+
+// Pos returns the number of bytes consumed so far.
+func (c *CountedReader) Pos() int {
+	return c.pos
 }
 
 func (c *CountedReader) Peek(n int) ([]byte, error) {
 	return c.r.Peek(n)
 }
 
+// peekPadded is like Peek but pads the data with zeros if the stream
+// ends before n bytes. Decoders that peek at the maximum size of an entry
+// use it to decode the last entries of a journal.
+func (c *CountedReader) peekPadded(n int) ([]byte, error) {
+	b, err := c.r.Peek(n)
+	if err != io.EOF || len(b) == 0 {
+		return b, err
+	}
+
+	c.pad = append(c.pad[:0], b...)
+	c.pad = append(c.pad, make([]byte, n-len(b))...)
+	return c.pad, nil
+}
+
 func (c *CountedReader) Discard(n int) (int, error) {
 	c.pos += n
 	return c.r.Discard(n)
@@ -31,6 +51,11 @@ func (c *CountedReader) Read(p []byte) (n int, err error) {
 	return
 }
 
+func (c *CountedReader) reset(r io.Reader) {
+	c.pos = 0
+	c.r.Reset(r)
+}
+
 func newCountedReader(r io.Reader) *CountedReader {
 	return &CountedReader{r: bufio.NewReaderSize(r, 8*4096)}
 }
@@ -0,0 +1,275 @@
+package splunker
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RestoredSource describes a file written by RestoreSources.
+type RestoredSource struct {
+	Host     string
+	Source   string
+	StreamID uint64
+	// Path of the restored file
+	Path   string
+	Events int
+	Bytes  int64
+	// Gaps are the ranges of the original stream no event was found for
+	Gaps []Gap
+}
+
+// Gap is a range of an input stream that is missing in the restored file.
+type Gap struct {
+	Offset uint64
+	Length uint64
+}
+
+// RestoreOption configures RestoreSources.
+type RestoreOption func(r *restorer)
+
+// WithGapMarker writes a line formatted with format at every gap,
+// the offset and length of the gap are passed as arguments.
+// By default gaps are only reported.
+func WithGapMarker(format string) RestoreOption {
+	return func(r *restorer) {
+		r.gapMarker = format
+	}
+}
+
+// WithLineBreak sets the separator written between two events.
+// It defaults to "\n".
+func WithLineBreak(sep string) RestoreOption {
+	return func(r *restorer) {
+		r.lineBreak = sep
+	}
+}
+
+// WithRestoreBuffer limits the number of events kept in memory while
+// sorting, additional events are spilled to temporary files in dir.
+func WithRestoreBuffer(maxEvents int, dir string) RestoreOption {
+	return func(r *restorer) {
+		r.maxBuffered = maxEvents
+		r.spillDir = dir
+	}
+}
+
+type restorer struct {
+	gapMarker   string
+	lineBreak   string
+	maxBuffered int
+	spillDir    string
+}
+
+// RestoreSources writes the events of seq back into the files they were
+// originally read from. The events are grouped by host, source and
+// streamID and ordered by their position in the stream, which is
+// streamOffset + streamSubOffset. Every stream is written to
+// dir/<host>/<source>, further streams of the same source get a numeric
+// suffix. Existing files are never overwritten, a stream whose path
+// exists gets a suffix as well.
+//
+// A gap is reported if the distance between two events is longer than
+// the line break, as the line breaker itself is not part of _raw.
+func RestoreSources(dir string, seq iter.Seq2[Event, error], opts ...RestoreOption) ([]RestoredSource, error) {
+	r := &restorer{
+		lineBreak:   "\n",
+		maxBuffered: 100000,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	less := func(a, b *Event) bool {
+		return cmp.Or(
+			cmp.Compare(a.host, b.host),
+			cmp.Compare(a.source, b.source),
+			cmp.Compare(a.streamID, b.streamID),
+			cmp.Compare(streamPos(a), streamPos(b)),
+		) < 0
+	}
+
+	sorter := newSpillSorter(less, r.maxBuffered, r.spillDir, &bucketTable{})
+	defer sorter.close()
+
+	for e, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		if err := sorter.add(e); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		restored []*RestoredSource
+		cur      *RestoredSource
+		f        *os.File
+		w        *bufio.Writer
+		end      uint64
+		// used are the paths of the restored files
+		used = make(map[string]bool)
+	)
+	closeFile := func() error {
+		if f == nil {
+			return nil
+		}
+		defer func() { f = nil }()
+
+		if err := w.Flush(); err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	defer closeFile()
+
+	for e, err := range mergeSorted(less, sorter) {
+		if err != nil {
+			return nil, err
+		}
+
+		pos := streamPos(&e)
+		if cur == nil || cur.Host != e.host || cur.Source != e.source || cur.StreamID != e.streamID {
+			if err := closeFile(); err != nil {
+				return nil, err
+			}
+
+			p, err := streamPath(used, restorePath(dir, e.host, e.source))
+			if err != nil {
+				return nil, err
+			}
+			used[p] = true
+
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				return nil, err
+			}
+			// fails instead of truncating a file created since streamPath
+			f, err = os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+			if err != nil {
+				return nil, err
+			}
+			if w == nil {
+				w = bufio.NewWriterSize(f, 1<<20)
+			} else {
+				w.Reset(f)
+			}
+
+			cur = &RestoredSource{
+				Host:     e.host,
+				Source:   e.source,
+				StreamID: e.streamID,
+				Path:     p,
+			}
+			restored = append(restored, cur)
+
+			if pos > 0 {
+				if err := r.gap(cur, w, 0, pos); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			if pos < end {
+				// overlaps with the previous event, e.g. a duplicate
+				continue
+			}
+			if _, err := w.WriteString(r.lineBreak); err != nil {
+				return nil, err
+			}
+			cur.Bytes += int64(len(r.lineBreak))
+			if pos-end > uint64(len(r.lineBreak)) {
+				if err := r.gap(cur, w, end, pos-end); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if _, err := w.Write(e.message); err != nil {
+			return nil, err
+		}
+		cur.Events++
+		cur.Bytes += int64(len(e.message))
+		end = pos + uint64(len(e.message))
+	}
+
+	if err := closeFile(); err != nil {
+		return nil, err
+	}
+
+	sources := make([]RestoredSource, len(restored))
+	for i, s := range restored {
+		sources[i] = *s
+	}
+
+	return sources, nil
+}
+
+// gap records a gap and writes the marker if configured
+func (r *restorer) gap(s *RestoredSource, w *bufio.Writer, offset, length uint64) error {
+	s.Gaps = append(s.Gaps, Gap{Offset: offset, Length: length})
+
+	if r.gapMarker == "" {
+		return nil
+	}
+
+	n, err := fmt.Fprintf(w, r.gapMarker, offset, length)
+	if err != nil {
+		return err
+	}
+	if _, err := w.WriteString(r.lineBreak); err != nil {
+		return err
+	}
+	s.Bytes += int64(n + len(r.lineBreak))
+
+	return nil
+}
+
+// streamPath returns p if it is neither used by another stream nor exists.
+// Otherwise p gets the first numeric suffix that is neither used nor
+// exists, so existing files, e.g. of a previous restore or a rotated file
+// named like the suffix, are not overwritten.
+func streamPath(used map[string]bool, p string) (string, error) {
+	for n := 0; ; n++ {
+		s := p
+		if n > 0 {
+			s = fmt.Sprintf("%s.%d", p, n)
+		}
+		if used[s] {
+			continue
+		}
+		_, err := os.Lstat(s)
+		switch {
+		case os.IsNotExist(err):
+			return s, nil
+		case err != nil:
+			return "", err
+		}
+	}
+}
+
+// streamPos returns the position of the event in its input stream
+func streamPos(e *Event) uint64 {
+	return e.streamOffset + e.streamSubOffset
+}
+
+// restorePath maps host and source to a path in dir.
+// Sources can not escape dir.
+func restorePath(dir, host, source string) string {
+	source = strings.ReplaceAll(source, "\\", "/")
+	source = strings.ReplaceAll(source, ":", "_")
+	source = filepath.Clean("/" + source)
+	if source == "/" {
+		source = "unknown"
+	}
+
+	host = strings.NewReplacer("/", "_", "\\", "_").Replace(host)
+	if host == "" || host == "." || host == ".." {
+		host = "_" + host
+	}
+
+	return filepath.Join(dir, host, filepath.FromSlash(source))
+}
@@ -0,0 +1,130 @@
+package splunker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestorePath(t *testing.T) {
+	for _, tc := range []struct {
+		host, source, want string
+	}{
+		{"web01", "/var/log/nginx/access.log", "out/web01/var/log/nginx/access.log"},
+		{"web01", "../../etc/passwd", "out/web01/etc/passwd"},
+		{"win01", `C:\Windows\System32\LogFiles\u_ex.log`, "out/win01/C_/Windows/System32/LogFiles/u_ex.log"},
+		{"../x", "udp:514", "out/.._x/udp_514"},
+		{"", "", "out/_/unknown"},
+	} {
+		assert.Equal(t, tc.want, restorePath("out", tc.host, tc.source))
+	}
+}
+
+// eventSeq yields the events of infos
+func eventSeq(infos ...EventInfo) func(yield func(Event, error) bool) {
+	return func(yield func(Event, error) bool) {
+		for _, info := range infos {
+			if !yield(NewEvent(info), nil) {
+				return
+			}
+		}
+	}
+}
+
+func restoreEvent(source string, stream, offset uint64, raw string) EventInfo {
+	return EventInfo{
+		Host:         "web01",
+		Source:       source,
+		StreamID:     stream,
+		StreamOffset: offset,
+		Raw:          []byte(raw),
+	}
+}
+
+func TestRestoreSources(t *testing.T) {
+	dir := t.TempDir()
+
+	restored, err := RestoreSources(dir, eventSeq(
+		restoreEvent("/var/log/app.log", 1, 12, "third"),
+		restoreEvent("/var/log/app.log", 1, 0, "first"),
+		restoreEvent("/var/log/app.log", 1, 6, "second"),
+		// a duplicate from another bucket copy
+		restoreEvent("/var/log/app.log", 1, 6, "second"),
+		// a second stream of the same file
+		restoreEvent("/var/log/app.log", 2, 0, "rotated"),
+	), WithRestoreBuffer(2, t.TempDir()))
+	require.NoError(t, err)
+	require.Len(t, restored, 2)
+
+	p := filepath.Join(dir, "web01", "var", "log", "app.log")
+	assert.Equal(t, RestoredSource{
+		Host: "web01", Source: "/var/log/app.log", StreamID: 1,
+		Path: p, Events: 3, Bytes: 18,
+	}, restored[0])
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\nthird", string(b))
+
+	assert.Equal(t, p+".1", restored[1].Path)
+	b, err = os.ReadFile(p + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", string(b))
+}
+
+func TestRestoreSourcesGaps(t *testing.T) {
+	dir := t.TempDir()
+
+	restored, err := RestoreSources(dir, eventSeq(
+		restoreEvent("/app.log", 1, 4, "aa"),
+		restoreEvent("/app.log", 1, 10, "bb"),
+		// overlaps with bb
+		restoreEvent("/app.log", 1, 11, "b"),
+		restoreEvent("/app.log", 1, 13, "cc"),
+	), WithGapMarker("<gap %d+%d>"), WithLineBreak("\r\n"))
+	require.NoError(t, err)
+	require.Len(t, restored, 1)
+
+	assert.Equal(t, []Gap{{Offset: 0, Length: 4}, {Offset: 6, Length: 4}}, restored[0].Gaps)
+	assert.Equal(t, 3, restored[0].Events)
+
+	b, err := os.ReadFile(restored[0].Path)
+	require.NoError(t, err)
+	assert.Equal(t, "<gap 0+4>\r\naa\r\n<gap 6+4>\r\nbb\r\ncc", string(b))
+	assert.Equal(t, int64(len(b)), restored[0].Bytes)
+}
+
+func TestRestoreSourcesCollision(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "web01", "app.log")
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+	// left from another restore
+	require.NoError(t, os.WriteFile(p, []byte("keep base"), 0o644))
+	require.NoError(t, os.WriteFile(p+".1", []byte("keep"), 0o644))
+
+	restored, err := RestoreSources(dir, eventSeq(
+		restoreEvent("/app.log", 1, 0, "one"),
+		restoreEvent("/app.log", 2, 0, "two"),
+		restoreEvent("/app.log.2", 1, 0, "rotated"),
+	))
+	require.NoError(t, err)
+	require.Len(t, restored, 3)
+
+	assert.Equal(t, p+".2", restored[0].Path)
+	assert.Equal(t, p+".3", restored[1].Path)
+	assert.Equal(t, p+".2.1", restored[2].Path)
+
+	for file, want := range map[string]string{
+		p:          "keep base",
+		p + ".1":   "keep",
+		p + ".2":   "one",
+		p + ".3":   "two",
+		p + ".2.1": "rotated",
+	} {
+		b, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(b), file)
+	}
+}
@@ -0,0 +1,11 @@
+package splunker
+
+// Sink writes events to a file or forwards them to another system.
+type Sink interface {
+	// WriteEvent writes e. Sinks buffering events copy them,
+	// so e may be reused after WriteEvent returns.
+	WriteEvent(e Event) error
+	// Close writes all buffered events and releases the resources of the sink.
+	// Writers passed to the sink are not closed.
+	Close() error
+}
@@ -0,0 +1,298 @@
+// Package arrow converts events to Apache Arrow record batches and writes
+// them as Arrow IPC stream, e.g. to pipe them into DuckDB or pandas.
+//
+// The columns of Schema match those of package parquet:
+//
+//	_time              timestamp[us, UTC]
+//	_raw               utf8
+//	host, source, sourcetype, index
+//	bucket             bucket as <index>~<id>[~<guid>], null if unknown
+//	_stream_id, _stream_offset, _stream_suboffset
+//	_hash              fixed_size_binary[20], null if the journal does not store one
+//	fields             map<utf8, list<utf8>> of the indexed fields
+package arrow
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"iter"
+	"unsafe"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+
+	"github.com/fionera/splunker"
+)
+
+// Schema is the schema of the records built by RecordBuilder.
+var Schema = arrow.NewSchema([]arrow.Field{
+	{Name: "_time", Type: arrow.FixedWidthTypes.Timestamp_us},
+	{Name: "_raw", Type: arrow.BinaryTypes.String},
+	{Name: "host", Type: arrow.BinaryTypes.String},
+	{Name: "source", Type: arrow.BinaryTypes.String},
+	{Name: "sourcetype", Type: arrow.BinaryTypes.String},
+	{Name: "index", Type: arrow.BinaryTypes.String},
+	{Name: "bucket", Type: arrow.BinaryTypes.String, Nullable: true},
+	{Name: "_stream_id", Type: arrow.PrimitiveTypes.Uint64},
+	{Name: "_stream_offset", Type: arrow.PrimitiveTypes.Uint64},
+	{Name: "_stream_suboffset", Type: arrow.PrimitiveTypes.Uint64},
+	{Name: "_hash", Type: &arrow.FixedSizeBinaryType{ByteWidth: 20}, Nullable: true},
+	{Name: "fields", Type: arrow.MapOf(arrow.BinaryTypes.String, arrow.ListOf(arrow.BinaryTypes.String))},
+}, nil)
+
+// column indexes of the string columns in RecordBuilder.strings
+const (
+	colRaw = iota
+	colHost
+	colSource
+	colSourceType
+	colIndex
+	colBucket
+	numStrings
+)
+
+// RecordBuilder builds records of Schema from events. Appending an event
+// copies it into the builders without allocating, so the events of a
+// JournalDecoder can be appended as they are decoded.
+type RecordBuilder struct {
+	mem memory.Allocator
+
+	time *array.TimestampBuilder
+	// strings are built as binary to append the []byte of the events
+	// without converting them to string
+	strings                     [numStrings]*array.BinaryBuilder
+	streamID, offset, subOffset *array.Uint64Builder
+	hash                        *array.FixedSizeBinaryBuilder
+	fields                      *array.MapBuilder
+	fieldNames                  *array.StringBuilder
+	fieldValues                 *array.ListBuilder
+	fieldValue                  *array.StringBuilder
+	bucketPath, bucketID        string
+}
+
+func NewRecordBuilder(mem memory.Allocator) *RecordBuilder {
+	b := &RecordBuilder{
+		mem:       mem,
+		time:      array.NewTimestampBuilder(mem, arrow.FixedWidthTypes.Timestamp_us.(*arrow.TimestampType)),
+		streamID:  array.NewUint64Builder(mem),
+		offset:    array.NewUint64Builder(mem),
+		subOffset: array.NewUint64Builder(mem),
+		hash:      array.NewFixedSizeBinaryBuilder(mem, &arrow.FixedSizeBinaryType{ByteWidth: 20}),
+		fields:    array.NewMapBuilder(mem, arrow.BinaryTypes.String, arrow.ListOf(arrow.BinaryTypes.String), false),
+	}
+	for i := range b.strings {
+		b.strings[i] = array.NewBinaryBuilder(mem, arrow.BinaryTypes.String)
+	}
+	b.fieldNames = b.fields.KeyBuilder().(*array.StringBuilder)
+	b.fieldValues = b.fields.ItemBuilder().(*array.ListBuilder)
+	b.fieldValue = b.fieldValues.ValueBuilder().(*array.StringBuilder)
+
+	return b
+}
+
+// stringBytes returns the bytes of s without copying, the builders copy them
+func stringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// Len returns the number of events appended since the last NewRecord.
+func (b *RecordBuilder) Len() int {
+	return b.time.Len()
+}
+
+// Append appends e as row.
+func (b *RecordBuilder) Append(e splunker.Event) {
+	bucket := e.Bucket()
+
+	b.time.Append(arrow.Timestamp(e.Time().UnixMicro()))
+	b.strings[colRaw].Append(e.Message())
+	b.strings[colHost].Append(stringBytes(e.Host()))
+	b.strings[colSource].Append(stringBytes(e.Source()))
+	b.strings[colSourceType].Append(stringBytes(e.SourceType()))
+	b.strings[colIndex].Append(stringBytes(bucket.Index))
+	if bucket.Path == "" {
+		b.strings[colBucket].AppendNull()
+	} else {
+		// the events of a bucket follow each other, format its id only once
+		if bucket.Path != b.bucketPath {
+			b.bucketPath, b.bucketID = bucket.Path, bucket.SplunkID()
+		}
+		b.strings[colBucket].Append(stringBytes(b.bucketID))
+	}
+	b.streamID.Append(e.StreamID())
+	b.offset.Append(e.StreamOffset())
+	b.subOffset.Append(e.StreamSubOffset())
+	if hash, ok := e.Hash(); ok {
+		b.hash.Append(hash[:])
+	} else {
+		b.hash.AppendNull()
+	}
+
+	b.fields.Append(true)
+	fields := e.IndexedFields()
+	for i, f := range fields {
+		if seenBefore(fields[:i], f.Name) {
+			continue
+		}
+
+		b.fieldNames.Append(f.Name)
+		b.fieldValues.Append(true)
+		b.fieldValue.Append(f.Value)
+		for _, other := range fields[i+1:] {
+			if other.Name == f.Name {
+				b.fieldValue.Append(other.Value)
+			}
+		}
+	}
+}
+
+func seenBefore(fields []splunker.Field, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRecord returns a record of the appended events and resets the builder.
+// The record has to be released by the caller.
+func (b *RecordBuilder) NewRecord() arrow.Record {
+	n := int64(b.Len())
+	cols := make([]arrow.Array, 0, len(Schema.Fields()))
+	cols = append(cols, b.time.NewArray())
+	for _, s := range b.strings {
+		bin := s.NewBinaryArray()
+		cols = append(cols, array.NewStringData(bin.Data()))
+		bin.Release()
+	}
+	cols = append(cols,
+		b.streamID.NewArray(),
+		b.offset.NewArray(),
+		b.subOffset.NewArray(),
+		b.hash.NewArray(),
+		b.fields.NewArray(),
+	)
+
+	rec := array.NewRecord(Schema, cols, n)
+	for _, c := range cols {
+		c.Release()
+	}
+	return rec
+}
+
+// Release frees the memory of the builders.
+func (b *RecordBuilder) Release() {
+	b.time.Release()
+	for _, s := range b.strings {
+		s.Release()
+	}
+	b.streamID.Release()
+	b.offset.Release()
+	b.subOffset.Release()
+	b.hash.Release()
+	b.fields.Release()
+}
+
+// Records converts events to records of up to size rows. A record is
+// released when yield returns, call Retain to keep it.
+func Records(events iter.Seq2[splunker.Event, error], size int) iter.Seq2[arrow.Record, error] {
+	return func(yield func(arrow.Record, error) bool) {
+		b := NewRecordBuilder(memory.NewGoAllocator())
+		defer b.Release()
+
+		emit := func() bool {
+			rec := b.NewRecord()
+			defer rec.Release()
+			return yield(rec, nil)
+		}
+
+		for e, err := range events {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			b.Append(e)
+			if b.Len() >= size && !emit() {
+				return
+			}
+		}
+
+		if b.Len() > 0 {
+			emit()
+		}
+	}
+}
+
+// Config configures the Writer. The zero value is usable.
+type Config struct {
+	// BatchSize is the number of rows per record batch, defaults to 65536
+	BatchSize int
+	// Allocator of the builders, defaults to the Go allocator
+	Allocator memory.Allocator
+}
+
+// Writer writes events as Arrow IPC stream.
+type Writer struct {
+	w         *bufio.Writer
+	b         *RecordBuilder
+	ipc       *ipc.Writer
+	batchSize int
+}
+
+var _ splunker.Sink = (*Writer)(nil)
+
+func NewWriter(w io.Writer, cfg Config) *Writer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 65536
+	}
+	if cfg.Allocator == nil {
+		cfg.Allocator = memory.NewGoAllocator()
+	}
+
+	bw := bufio.NewWriterSize(w, 1<<20)
+	return &Writer{
+		w:         bw,
+		b:         NewRecordBuilder(cfg.Allocator),
+		ipc:       ipc.NewWriter(bw, ipc.WithSchema(Schema), ipc.WithAllocator(cfg.Allocator)),
+		batchSize: cfg.BatchSize,
+	}
+}
+
+func (w *Writer) WriteEvent(e splunker.Event) error {
+	w.b.Append(e)
+	if w.b.Len() < w.batchSize {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *Writer) flush() error {
+	rec := w.b.NewRecord()
+	defer rec.Release()
+
+	if err := w.ipc.Write(rec); err != nil {
+		return fmt.Errorf("arrow: %w", err)
+	}
+	return nil
+}
+
+// Close writes the remaining events and the end of the stream. It does
+// not close the underlying writer.
+func (w *Writer) Close() error {
+	defer w.b.Release()
+
+	if w.b.Len() > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	if err := w.ipc.Close(); err != nil {
+		return fmt.Errorf("arrow: %w", err)
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,113 @@
+package arrow
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+func testEvent(i int) splunker.Event {
+	info := splunker.EventInfo{
+		Time:         time.Unix(1700000000+int64(i), 250000000),
+		Host:         "web01",
+		Source:       "/var/log/access.log",
+		SourceType:   "access_combined",
+		Raw:          []byte("GET /index.html 200"),
+		Bucket:       &splunker.Bucket{Index: "web", ID: 7, Path: "/opt/splunk/web/db/db_1_1_7"},
+		StreamID:     42,
+		StreamOffset: uint64(i) * 20,
+		IndexedFields: []splunker.Field{
+			{Name: "status", Value: "200"},
+			{Name: "tag", Value: "a"},
+			{Name: "tag", Value: "b"},
+		},
+	}
+	if i == 0 {
+		copy(info.Hash[:], "0123456789abcdefghij")
+		info.HasHash = true
+	}
+	return splunker.NewEvent(info)
+}
+
+func TestWriter(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Config{BatchSize: 2, Allocator: mem})
+	for i := 0; i < 3; i++ {
+		require.NoError(t, w.WriteEvent(testEvent(i)))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := ipc.NewReader(&buf, ipc.WithAllocator(mem))
+	require.NoError(t, err)
+	defer r.Release()
+	assert.True(t, r.Schema().Equal(Schema))
+
+	var rows []int64
+	for r.Next() {
+		rec := r.Record()
+		rows = append(rows, rec.NumRows())
+		if len(rows) > 1 {
+			continue
+		}
+
+		assert.EqualValues(t, time.Unix(1700000000, 250000000).UnixMicro(), rec.Column(0).(*array.Timestamp).Value(0))
+		assert.Equal(t, "GET /index.html 200", rec.Column(1).(*array.String).Value(0))
+		assert.Equal(t, "access_combined", rec.Column(4).(*array.String).Value(1))
+		assert.Equal(t, "web~7", rec.Column(6).(*array.String).Value(0))
+		assert.EqualValues(t, 20, rec.Column(8).(*array.Uint64).Value(1))
+
+		hash := rec.Column(10).(*array.FixedSizeBinary)
+		assert.Equal(t, "0123456789abcdefghij", string(hash.Value(0)))
+		assert.True(t, hash.IsNull(1))
+
+		fields := rec.Column(11).(*array.Map)
+		start, end := fields.Offsets()[0], fields.Offsets()[1]
+		require.EqualValues(t, 2, end-start)
+		keys := fields.Keys().(*array.String)
+		assert.Equal(t, "status", keys.Value(0))
+		assert.Equal(t, "tag", keys.Value(1))
+		values := fields.Items().(*array.List)
+		assert.Equal(t, []int32{0, 1, 3}, values.Offsets()[:3])
+		assert.Equal(t, "b", values.ListValues().(*array.String).Value(2))
+	}
+	require.NoError(t, r.Err())
+	assert.Equal(t, []int64{2, 1}, rows)
+}
+
+func TestRecords(t *testing.T) {
+	events := func(yield func(splunker.Event, error) bool) {
+		for i := 0; i < 5; i++ {
+			if !yield(testEvent(i), nil) {
+				return
+			}
+		}
+	}
+
+	var rows []int64
+	for rec, err := range Records(events, 2) {
+		require.NoError(t, err)
+		rows = append(rows, rec.NumRows())
+	}
+	assert.Equal(t, []int64{2, 2, 1}, rows)
+}
+
+func TestRecordBuilderAllocs(t *testing.T) {
+	b := NewRecordBuilder(memory.NewGoAllocator())
+	defer b.Release()
+
+	e := testEvent(0)
+	allocs := testing.AllocsPerRun(1000, func() { b.Append(e) })
+	// only the growth of the builders allocates
+	assert.Less(t, allocs, 1.0)
+}
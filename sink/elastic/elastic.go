@@ -0,0 +1,436 @@
+// Package elastic writes events into Elasticsearch or OpenSearch with the
+// _bulk API.
+//
+// The documents have the layout of package jsonl with an additional
+// @timestamp. Their id is derived from the stream offsets, index, host,
+// source, _time, _raw and the hash of the event if the journal stores one,
+// so exporting the same buckets again overwrites the documents instead of
+// duplicating them.
+package elastic
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/internal/httpsink"
+	"github.com/fionera/splunker/sink/jsonl"
+)
+
+// DefaultIndexTemplate is used if Config.IndexTemplate is empty.
+const DefaultIndexTemplate = "splunk-{index}-{yyyy.MM.dd}"
+
+// Batching configures the batch size and the retries of failed requests.
+type Batching = httpsink.Batching
+
+// Config configures the Sink. Only URL is required.
+type Config struct {
+	// URL of the cluster, e.g. https://elastic:9200
+	URL string
+	// Username and Password are sent with basic authentication
+	Username string
+	Password string
+	// APIKey is sent as ApiKey authorization if set
+	APIKey string
+
+	// IndexTemplate names the index of an event. {index}, {host},
+	// {source} and {sourcetype} are replaced by the fields of the event,
+	// other placeholders are formatted as _time in UTC with the letters
+	// yyyy, yy, MM, dd and HH, e.g. {yyyy.MM.dd}. Defaults to
+	// DefaultIndexTemplate.
+	IndexTemplate string
+
+	// Batching limits the number of documents per request. Its retries
+	// also apply to the documents rejected as temporary failure.
+	Batching
+	// BatchBytes is the maximum size of a request, defaults to 5 MiB
+	BatchBytes int
+
+	// TLSConfig is used for https URLs, ignored if Client is set
+	TLSConfig *tls.Config
+	// Client sends the requests, defaults to a client using TLSConfig
+	Client *http.Client
+}
+
+// Sink sends events in batches to the _bulk API. Writes block while the
+// cluster rejects requests, which throttles the export to its pace.
+type Sink struct {
+	cfg      Config
+	url      string
+	client   *http.Client
+	template []templatePart
+
+	// buf holds the action and document lines of the batch, items their
+	// position in it
+	buf   []byte
+	items []item
+	doc   []byte
+	index []byte
+}
+
+type item struct {
+	start, end int
+}
+
+var _ splunker.Sink = (*Sink)(nil)
+
+// New creates a Sink writing to the cluster at cfg.URL.
+func New(cfg Config) (*Sink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("elastic: URL is required")
+	}
+	if cfg.IndexTemplate == "" {
+		cfg.IndexTemplate = DefaultIndexTemplate
+	}
+	cfg.SetDefaults()
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = 5 << 20
+	}
+
+	template, err := parseTemplate(cfg.IndexTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("elastic: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/_bulk"
+
+	s := &Sink{
+		cfg:      cfg,
+		url:      u.String(),
+		client:   cfg.Client,
+		template: template,
+	}
+	if s.client == nil {
+		s.client = &http.Client{
+			Timeout: 2 * time.Minute,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: cfg.TLSConfig,
+			},
+		}
+	}
+
+	return s, nil
+}
+
+// templatePart is a literal or a placeholder of the index template
+type templatePart struct {
+	literal string
+	// field is the event field of the placeholder
+	field string
+	// layout is the time layout of the placeholder
+	layout string
+}
+
+var dateTokens = strings.NewReplacer("yyyy", "2006", "yy", "06", "MM", "01", "dd", "02", "HH", "15")
+
+func parseTemplate(s string) ([]templatePart, error) {
+	var parts []templatePart
+	for s != "" {
+		start := strings.IndexByte(s, '{')
+		if start < 0 {
+			parts = append(parts, templatePart{literal: s})
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("elastic: unterminated placeholder in index template %q", s)
+		}
+		end += start
+
+		if start > 0 {
+			parts = append(parts, templatePart{literal: s[:start]})
+		}
+		switch name := s[start+1 : end]; name {
+		case "index", "host", "source", "sourcetype":
+			parts = append(parts, templatePart{field: name})
+		default:
+			layout := dateTokens.Replace(name)
+			if layout == name {
+				return nil, fmt.Errorf("elastic: unknown placeholder {%s} in index template", name)
+			}
+			parts = append(parts, templatePart{layout: layout})
+		}
+		s = s[end+1:]
+	}
+	return parts, nil
+}
+
+// appendIndex appends the index name of e to b
+func (s *Sink) appendIndex(b []byte, e splunker.Event) []byte {
+	start := len(b)
+	for _, p := range s.template {
+		switch {
+		case p.literal != "":
+			b = append(b, p.literal...)
+		case p.layout != "":
+			b = e.Time().UTC().AppendFormat(b, p.layout)
+		default:
+			b = append(b, eventField(e, p.field)...)
+		}
+	}
+
+	// index names are lowercase and must not contain some characters
+	for i := start; i < len(b); i++ {
+		switch c := b[i]; {
+		case c >= 'A' && c <= 'Z':
+			b[i] = c + 'a' - 'A'
+		case strings.IndexByte(`\/*?"<>| ,#:`, c) >= 0:
+			b[i] = '_'
+		}
+	}
+	return b
+}
+
+func eventField(e splunker.Event, name string) string {
+	switch name {
+	case "index":
+		return e.Bucket().Index
+	case "host":
+		return e.Host()
+	case "source":
+		return e.Source()
+	case "sourcetype":
+		return e.SourceType()
+	}
+	return ""
+}
+
+// DocumentID returns the id of the document of e. It is derived from the
+// position of the event in its stream, its index, host, source, _time,
+// _raw and its hash if the journal stores one. Sending an event again
+// overwrites its document, while equal events at different positions,
+// which share the hash, get documents of their own.
+func DocumentID(e splunker.Event) string {
+	h := sha1.New()
+	var b [24]byte
+	binary.LittleEndian.PutUint64(b[0:], e.StreamID())
+	binary.LittleEndian.PutUint64(b[8:], e.StreamOffset())
+	binary.LittleEndian.PutUint64(b[16:], e.StreamSubOffset())
+	h.Write(b[:])
+	if hash, ok := e.Hash(); ok {
+		h.Write(hash[:])
+	}
+	for _, s := range []string{e.Bucket().Index, e.Host(), e.Source(), e.Epoch()} {
+		_, _ = io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+	h.Write(e.Message())
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *Sink) WriteEvent(e splunker.Event) error {
+	s.index = s.appendIndex(s.index[:0], e)
+
+	s.doc = append(s.doc[:0], `{"@timestamp":"`...)
+	s.doc = e.Time().UTC().AppendFormat(s.doc, "2006-01-02T15:04:05.000000Z")
+	s.doc = append(s.doc, `",`...)
+	// skip the opening brace of the object
+	s.doc = append(s.doc, jsonl.AppendEvent(nil, e)[1:]...)
+
+	start := len(s.buf)
+	s.buf = append(s.buf, `{"index":{"_index":"`...)
+	s.buf = append(s.buf, s.index...)
+	s.buf = append(s.buf, `","_id":"`...)
+	s.buf = append(s.buf, DocumentID(e)...)
+	s.buf = append(s.buf, "\"}}\n"...)
+	s.buf = append(s.buf, s.doc...)
+	s.buf = append(s.buf, '\n')
+
+	var err error
+	if len(s.items) > 0 && len(s.buf) > s.cfg.BatchBytes {
+		// send the batch without the new document, which stays buffered
+		// also if the batch could not be written
+		pending := append([]byte(nil), s.buf[start:]...)
+		s.buf = s.buf[:start]
+		err = s.Flush()
+		start = len(s.buf)
+		s.buf = append(s.buf, pending...)
+	}
+
+	s.items = append(s.items, item{start: start, end: len(s.buf)})
+	if err != nil {
+		return err
+	}
+	if len(s.items) >= s.cfg.BatchSize || len(s.buf) >= s.cfg.BatchBytes {
+		return s.Flush()
+	}
+
+	return nil
+}
+
+// bulkResponse is the part of the _bulk response needed to find failed items
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// BulkError is returned if documents were rejected permanently.
+type BulkError struct {
+	Failed int
+	// Status and Reason describe the first failed document
+	Status int
+	Reason string
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("elastic: %d documents rejected, first with status %d: %s", e.Failed, e.Status, e.Reason)
+}
+
+// Flush sends the batched events. Documents rejected as temporary
+// failure are sent again, also if others were rejected permanently.
+// The permanently rejected documents are reported as BulkError once the
+// others are written. If the retries are exhausted or the request was
+// rejected as a whole, the documents that were not written stay buffered
+// for the next Flush. A response that cannot be read is not retried, the
+// cluster may have written any of the documents and sending them again
+// would duplicate them, so the batch is dropped.
+func (s *Sink) Flush() error {
+	if len(s.items) == 0 {
+		return nil
+	}
+
+	items := s.items
+	var (
+		body     []byte
+		rejected *BulkError
+	)
+
+	err := s.cfg.Retry(func() error {
+		body = body[:0]
+		for _, it := range items {
+			body = append(body, s.buf[it.start:it.end]...)
+		}
+
+		retry, failed, err := s.send(body, items)
+		if errors.Is(err, errInvalidResponse) {
+			items = nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if failed != nil {
+			if rejected == nil {
+				rejected = failed
+			} else {
+				rejected.Failed += failed.Failed
+			}
+		}
+		if items = retry; len(items) > 0 {
+			return fmt.Errorf("%d documents were rejected temporarily", len(items))
+		}
+		return nil
+	})
+	s.keep(items)
+
+	if err != nil {
+		err = fmt.Errorf("elastic: %w", err)
+		if rejected != nil {
+			return errors.Join(err, rejected)
+		}
+		return err
+	}
+	if rejected != nil {
+		return rejected
+	}
+	return nil
+}
+
+// keep drops the buffered documents except items
+func (s *Sink) keep(items []item) {
+	if len(items) == 0 {
+		s.buf, s.items = s.buf[:0], s.items[:0]
+		return
+	}
+
+	var buf []byte
+	kept := make([]item, 0, len(items))
+	for _, it := range items {
+		start := len(buf)
+		buf = append(buf, s.buf[it.start:it.end]...)
+		kept = append(kept, item{start: start, end: len(buf)})
+	}
+	s.buf, s.items = buf, kept
+}
+
+// StatusError is returned for requests rejected as a whole.
+type StatusError = httpsink.StatusError
+
+// errInvalidResponse is returned for a _bulk response that cannot be
+// matched to the documents of the request
+var errInvalidResponse = errors.New("invalid response")
+
+// send posts body and returns the items to retry and the items that
+// were rejected permanently
+func (s *Sink) send(body []byte, items []item) ([]item, *BulkError, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	switch {
+	case s.cfg.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.cfg.APIKey)
+	case s.cfg.Username != "":
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	data, err := httpsink.Do(s.client, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var res bulkResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, nil, httpsink.Permanent(fmt.Errorf("%w: %w", errInvalidResponse, err))
+	}
+	if !res.Errors {
+		return nil, nil, nil
+	}
+	if len(res.Items) != len(items) {
+		return nil, nil, httpsink.Permanent(fmt.Errorf("%w: %d items for %d documents", errInvalidResponse, len(res.Items), len(items)))
+	}
+
+	var retry []item
+	var failed *BulkError
+	for i, ri := range res.Items {
+		for _, r := range ri {
+			switch {
+			case r.Status < 300:
+			case httpsink.RetryableStatus(r.Status):
+				retry = append(retry, items[i])
+			case failed == nil:
+				failed = &BulkError{Failed: 1, Status: r.Status, Reason: string(r.Error)}
+			default:
+				failed.Failed++
+			}
+		}
+	}
+
+	return retry, failed, nil
+}
+
+// Close sends the batched events.
+func (s *Sink) Close() error {
+	return s.Flush()
+}
@@ -0,0 +1,278 @@
+package elastic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/internal/httpsink/httpsinktest"
+)
+
+type action struct {
+	Index struct {
+		Index string `json:"_index"`
+		ID    string `json:"_id"`
+	} `json:"index"`
+}
+
+// readBulk returns the actions and documents of a _bulk request
+func readBulk(t *testing.T, r httpsinktest.Request) ([]action, []map[string]any) {
+	var actions []action
+	var docs []map[string]any
+
+	sc := bufio.NewScanner(bytes.NewReader(r.Body))
+	for sc.Scan() {
+		var a action
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &a))
+		require.True(t, sc.Scan())
+		var doc map[string]any
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &doc))
+
+		actions = append(actions, a)
+		docs = append(docs, doc)
+	}
+	return actions, docs
+}
+
+func itemsResponse(statuses ...int) string {
+	var items []string
+	errors := false
+	for _, status := range statuses {
+		if status >= 300 {
+			errors = true
+			items = append(items, fmt.Sprintf(`{"index":{"status":%d,"error":{"type":"x"}}}`, status))
+		} else {
+			items = append(items, fmt.Sprintf(`{"index":{"status":%d}}`, status))
+		}
+	}
+	return fmt.Sprintf(`{"took":1,"errors":%t,"items":[%s]}`, errors, strings.Join(items, ","))
+}
+
+func testEvents() []splunker.Event {
+	bucket := &splunker.Bucket{Index: "Web"}
+	e1 := splunker.EventInfo{
+		Time: time.Unix(1700000000, 250000000), Host: "web01", Source: "/var/log/app.log", SourceType: "app",
+		Raw: []byte("first"), Bucket: bucket, HasHash: true,
+	}
+	e1.Hash[0] = 0xab
+	return []splunker.Event{
+		splunker.NewEvent(e1),
+		splunker.NewEvent(splunker.EventInfo{Time: time.Unix(1700100000, 0), Host: "web02", Raw: []byte("second"), Bucket: bucket, StreamID: 1}),
+		splunker.NewEvent(splunker.EventInfo{Time: time.Unix(1700100000, 0), Host: "web02", Raw: []byte("second"), Bucket: bucket, StreamID: 2}),
+	}
+}
+
+func TestSink(t *testing.T) {
+	var requests [][]action
+	var docs []map[string]any
+
+	srv := httpsinktest.NewServer(t, func(r httpsinktest.Request) httpsinktest.Response {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		assert.Equal(t, "ApiKey key", r.Header.Get("Authorization"))
+
+		actions, d := readBulk(t, r)
+		requests = append(requests, actions)
+		switch len(requests) {
+		case 1:
+			// the cluster is overloaded
+			return httpsinktest.Response{Status: http.StatusTooManyRequests}
+		case 2:
+			// the second document has to be retried
+			docs = append(docs, d[0], d[2])
+			return httpsinktest.Response{Body: itemsResponse(201, 429, 201)}
+		default:
+			docs = append(docs, d...)
+			return httpsinktest.Response{Body: itemsResponse(201)}
+		}
+	})
+
+	s, err := New(Config{URL: srv.URL, APIKey: "key", Batching: httpsinktest.Batching})
+	require.NoError(t, err)
+	for _, e := range testEvents() {
+		require.NoError(t, s.WriteEvent(e))
+	}
+	require.NoError(t, s.Close())
+
+	require.Len(t, requests, 3)
+	assert.Equal(t, requests[0], requests[1])
+	assert.Equal(t, requests[1][1], requests[2][0])
+
+	first := requests[1]
+	assert.Equal(t, "splunk-web-2023.11.14", first[0].Index.Index)
+	assert.Len(t, first[0].Index.ID, 40)
+	assert.Equal(t, "splunk-web-2023.11.16", first[1].Index.Index)
+	assert.Len(t, first[1].Index.ID, 40)
+	// the events only differ in their stream
+	assert.NotEqual(t, first[1].Index.ID, first[2].Index.ID)
+
+	require.Len(t, docs, 3)
+	assert.Equal(t, "2023-11-14T22:13:20.250000Z", docs[0]["@timestamp"])
+	assert.Equal(t, "first", docs[0]["_raw"])
+	assert.Equal(t, "web01", docs[0]["host"])
+	assert.Equal(t, "second", docs[2]["_raw"])
+}
+
+func TestSinkRejected(t *testing.T) {
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(httpsinktest.Response{Body: itemsResponse(201, 400, 400)}))
+
+	s, err := New(Config{URL: srv.URL, IndexTemplate: "archive-{sourcetype}-{yyyy.MM}"})
+	require.NoError(t, err)
+	for _, e := range testEvents() {
+		require.NoError(t, s.WriteEvent(e))
+	}
+
+	var be *BulkError
+	require.ErrorAs(t, s.Close(), &be)
+	assert.Equal(t, 2, be.Failed)
+	assert.Equal(t, 400, be.Status)
+}
+
+func TestSinkRetryAndReject(t *testing.T) {
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(
+		httpsinktest.Response{Body: itemsResponse(201, 429, 400)},
+		httpsinktest.Response{Body: itemsResponse(201)},
+	))
+
+	s, err := New(Config{URL: srv.URL, Batching: httpsinktest.Batching})
+	require.NoError(t, err)
+	for _, e := range testEvents() {
+		require.NoError(t, s.WriteEvent(e))
+	}
+
+	var be *BulkError
+	require.ErrorAs(t, s.Flush(), &be)
+	assert.Equal(t, 1, be.Failed)
+	assert.Equal(t, 400, be.Status)
+
+	// the document rejected with 429 was sent again
+	requests := bulkActions(t, srv)
+	require.Len(t, requests, 2)
+	assert.Equal(t, []action{requests[0][1]}, requests[1])
+
+	// nothing is left for the next flush
+	require.NoError(t, s.Close())
+	assert.Len(t, srv.Requests(), 2)
+}
+
+func TestSinkGiveUp(t *testing.T) {
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(
+		httpsinktest.Response{Body: itemsResponse(201, 429, 201)},
+		httpsinktest.Response{Status: http.StatusServiceUnavailable},
+	))
+
+	batching := httpsinktest.Batching
+	batching.MaxRetries = 1
+	s, err := New(Config{URL: srv.URL, Batching: batching})
+	require.NoError(t, err)
+	for _, e := range testEvents() {
+		require.NoError(t, s.WriteEvent(e))
+	}
+	require.Error(t, s.Flush())
+
+	// only the document that was not written is sent by the next flush
+	require.Error(t, s.Flush())
+	requests := bulkActions(t, srv)
+	require.Len(t, requests, 4)
+	assert.Equal(t, []action{requests[0][1]}, requests[3])
+}
+
+func TestSinkInvalidResponse(t *testing.T) {
+	for name, body := range map[string]string{
+		"unparsable":     "<html>",
+		"items mismatch": itemsResponse(201, 429),
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := httpsinktest.NewServer(t, httpsinktest.Sequence(httpsinktest.Response{Body: body}))
+
+			s, err := New(Config{URL: srv.URL, Batching: httpsinktest.Batching})
+			require.NoError(t, err)
+			for _, e := range testEvents() {
+				require.NoError(t, s.WriteEvent(e))
+			}
+
+			// the cluster may have written the documents, they are
+			// neither retried nor sent by the next flush
+			assert.ErrorContains(t, s.Flush(), "elastic: invalid response")
+			require.NoError(t, s.Close())
+			assert.Len(t, srv.Requests(), 1)
+		})
+	}
+}
+
+func TestSinkWriteEventFlushFails(t *testing.T) {
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(
+		httpsinktest.Response{Status: http.StatusBadRequest},
+		httpsinktest.Response{Body: itemsResponse(201)},
+	))
+
+	// the second document does not fit into the batch of the first
+	s, err := New(Config{URL: srv.URL, Batching: httpsinktest.Batching, BatchBytes: 400})
+	require.NoError(t, err)
+	events := testEvents()
+	require.NoError(t, s.WriteEvent(events[0]))
+	var se *StatusError
+	require.ErrorAs(t, s.WriteEvent(events[1]), &se)
+	assert.Equal(t, http.StatusBadRequest, se.StatusCode)
+
+	// both documents are still buffered
+	require.NoError(t, s.Close())
+	requests := bulkActions(t, srv)
+	require.Len(t, requests, 2)
+	require.Len(t, requests[0], 1)
+	require.Len(t, requests[1], 2)
+	assert.Equal(t, requests[0][0], requests[1][0])
+	assert.Equal(t, DocumentID(events[1]), requests[1][1].Index.ID)
+}
+
+// bulkActions returns the actions of the requests received by srv
+func bulkActions(t *testing.T, srv *httpsinktest.Server) [][]action {
+	var requests [][]action
+	for _, r := range srv.Requests() {
+		actions, _ := readBulk(t, r)
+		requests = append(requests, actions)
+	}
+	return requests
+}
+
+func TestDocumentID(t *testing.T) {
+	info := splunker.EventInfo{
+		Time: time.Unix(1700000000, 0), Host: "web01", Raw: []byte("retry"),
+		Bucket: &splunker.Bucket{Index: "web"}, HasHash: true, StreamID: 1, StreamOffset: 10,
+	}
+	id := DocumentID(splunker.NewEvent(info))
+	assert.Equal(t, id, DocumentID(splunker.NewEvent(info)))
+
+	// the same line sent again has the same hash
+	again := info
+	again.StreamOffset = 20
+	assert.NotEqual(t, id, DocumentID(splunker.NewEvent(again)))
+
+	other := info
+	other.Bucket = &splunker.Bucket{Index: "copy"}
+	assert.NotEqual(t, id, DocumentID(splunker.NewEvent(other)))
+
+	other = info
+	other.Time = time.Unix(1700000001, 0)
+	assert.NotEqual(t, id, DocumentID(splunker.NewEvent(other)))
+}
+
+func TestParseTemplate(t *testing.T) {
+	_, err := parseTemplate("splunk-{nope}")
+	assert.Error(t, err)
+	_, err = parseTemplate("splunk-{index")
+	assert.Error(t, err)
+
+	s := &Sink{}
+	s.template, err = parseTemplate("Logs/{host}:{yy}{MM}{dd}-{HH}")
+	require.NoError(t, err)
+	assert.Equal(t, "logs_web01_231114-22", string(s.appendIndex(nil, testEvents()[0])))
+}
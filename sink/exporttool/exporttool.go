@@ -0,0 +1,100 @@
+// Package exporttool writes events as CSV in the layout of
+// `splunk cmd exporttool <bucket> <file> -csv`, so the output can
+// be compared with and used in place of the official tool.
+//
+// The columns are _time, source, host, sourcetype, _raw and _meta.
+// _time is the unix timestamp in seconds. _meta holds the indexed
+// fields as space separated name::value pairs followed by the
+// _subsecond of the event. The journal does not record when an event
+// was indexed, so no _indextime is written.
+// Fields are only quoted when necessary.
+package exporttool
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fionera/splunker"
+)
+
+// Header is the first line written by exporttool.
+var Header = []string{"_time", "source", "host", "sourcetype", "_raw", "_meta"}
+
+// Writer writes events as exporttool CSV.
+type Writer struct {
+	w      *csv.Writer
+	header bool
+	record []string
+	meta   strings.Builder
+}
+
+var _ splunker.Sink = (*Writer)(nil)
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:      csv.NewWriter(w),
+		record: make([]string, len(Header)),
+	}
+}
+
+func (w *Writer) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+
+	return w.w.Write(Header)
+}
+
+func (w *Writer) WriteEvent(e splunker.Event) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	t := e.Time()
+	w.record[0] = strconv.FormatInt(t.Unix(), 10)
+	w.record[1] = e.Source()
+	w.record[2] = e.Host()
+	w.record[3] = e.SourceType()
+	w.record[4] = e.MessageString()
+	w.record[5] = w.formatMeta(e)
+
+	return w.w.Write(w.record)
+}
+
+func (w *Writer) formatMeta(e splunker.Event) string {
+	w.meta.Reset()
+	for _, f := range e.IndexedFields() {
+		if w.meta.Len() > 0 {
+			w.meta.WriteByte(' ')
+		}
+		w.meta.WriteString(f.Name)
+		w.meta.WriteString("::")
+		w.meta.WriteString(f.Value)
+	}
+
+	// _subsecond is added to _time, so a negative time keeps its
+	// whole seconds rounded down
+	if us := e.Time().Nanosecond() / int(time.Microsecond); us != 0 {
+		if w.meta.Len() > 0 {
+			w.meta.WriteByte(' ')
+		}
+		fmt.Fprintf(&w.meta, "_subsecond::.%06d", us)
+	}
+
+	return w.meta.String()
+}
+
+// Close writes the header if no event was written and flushes the buffered events.
+func (w *Writer) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	w.w.Flush()
+	return w.w.Error()
+}
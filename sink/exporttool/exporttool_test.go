@@ -0,0 +1,87 @@
+package exporttool
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	require.NoError(t, w.WriteEvent(splunker.NewEvent(splunker.EventInfo{
+		Time:       time.Unix(1700000000, 250000000),
+		Host:       "web01",
+		Source:     "/var/log/app.log",
+		SourceType: "app",
+		Raw:        []byte(`GET "/index.html", 200`),
+		IndexedFields: []splunker.Field{
+			{Name: "punct", Value: `_"/.",_`},
+		},
+	})))
+	require.NoError(t, w.WriteEvent(splunker.NewEvent(splunker.EventInfo{
+		Time:       time.Unix(1700000001, 0),
+		Host:       "web01",
+		Source:     "/var/log/app.log",
+		SourceType: "app",
+		Raw:        []byte("plain"),
+	})))
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, "_time,source,host,sourcetype,_raw,_meta\n"+
+		`1700000000,/var/log/app.log,web01,app,"GET ""/index.html"", 200","punct::_""/."",_ _subsecond::.250000"`+"\n"+
+		"1700000001,/var/log/app.log,web01,app,plain,\n", buf.String())
+}
+
+func TestWriterGolden(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	for _, info := range []splunker.EventInfo{{
+		Time:       time.Unix(1700000000, 0),
+		Host:       "web01",
+		Source:     "/var/log/nginx/access.log",
+		SourceType: "access_combined",
+		Raw:        []byte(`10.0.0.1 - - [14/Nov/2023:22:13:20 +0000] "GET /index.html HTTP/1.1" 200 512`),
+		IndexedFields: []splunker.Field{
+			{Name: "punct", Value: `..._-_-_[//:::_+]_"_/.__/."__`},
+		},
+	}, {
+		Time:       time.Unix(1700000001, 123456000),
+		Host:       "db 01",
+		Source:     `C:\Logs\app,1.log`,
+		SourceType: "app",
+		Raw:        []byte("first line\nsecond line, with comma"),
+		IndexedFields: []splunker.Field{
+			{Name: "env", Value: "prod"},
+			{Name: "dc", Value: "eu-west"},
+		},
+	}, {
+		Time:       time.Unix(-2, 750000000),
+		Host:       "",
+		Source:     " leading space",
+		SourceType: "epoch",
+		Raw:        []byte(`"quoted"`),
+	}, {
+		Time:       time.Unix(1700000002, 1000),
+		Host:       "web02",
+		Source:     "stdin",
+		SourceType: "app",
+		Raw:        []byte("tab\tseparated"),
+	}} {
+		require.NoError(t, w.WriteEvent(splunker.NewEvent(info)))
+	}
+	require.NoError(t, w.Close())
+
+	golden, err := os.ReadFile(filepath.Join("testdata", "export.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, string(golden), buf.String())
+}
@@ -0,0 +1,390 @@
+// Package fluent forwards events to Fluentd or Fluent Bit with the
+// Forward protocol.
+//
+// Events are sent in PackedForward mode, one message per tag with the
+// entries of the batch, optionally gzip compressed. The tag is
+// <prefix>.<index>.<sourcetype>, characters other than letters, digits, _
+// and - are replaced by _. The record of an entry holds the _raw as
+// message, host, source, sourcetype, index and the indexed fields.
+package fluent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/fionera/splunker"
+)
+
+// Config configures the Sink. Only Addr is required.
+type Config struct {
+	// Addr of the forward input, usually on port 24224
+	Addr string
+	// TLSConfig enables TLS for the connection
+	TLSConfig *tls.Config
+
+	// TagPrefix is the first part of the tags, defaults to splunk
+	TagPrefix string
+	// Compress gzip compresses the entries (CompressedPackedForward)
+	Compress bool
+	// RequireAck waits for the acknowledgement of every message
+	RequireAck bool
+
+	// BatchSize is the maximum number of entries per flush, defaults to 1000
+	BatchSize int
+	// BatchBytes is the maximum size of the entries per flush, defaults to 1 MiB
+	BatchBytes int
+
+	// MaxRetries is the number of times a message is sent again on a new
+	// connection after it failed, defaults to 5
+	MaxRetries int
+	// Backoff is the wait time before the first retry, it doubles with
+	// every retry up to 30s. Defaults to 1s.
+	Backoff time.Duration
+	// Timeout of connecting, writing a message and waiting for its
+	// acknowledgement, defaults to 30s
+	Timeout time.Duration
+}
+
+const (
+	// MessageKey is the key of the _raw in the records
+	MessageKey = "message"
+
+	maxBackoff = 30 * time.Second
+	// eventTimeExt is the msgpack extension type of EventTime
+	eventTimeExt = 0
+)
+
+// reserved are the keys of a record that indexed fields can not use
+var reserved = map[string]bool{
+	MessageKey:   true,
+	"host":       true,
+	"source":     true,
+	"sourcetype": true,
+	"index":      true,
+}
+
+type tagBatch struct {
+	tag     string
+	entries bytes.Buffer
+	count   int
+}
+
+// Sink sends events to a forward input.
+type Sink struct {
+	cfg  Config
+	conn net.Conn
+
+	// tags holds the batch in the order the tags were seen
+	tags    []*tagBatch
+	index   map[string]*tagBatch
+	batched int
+	bytes   int
+
+	enc    *msgpack.Encoder
+	msg    bytes.Buffer
+	gzBuf  bytes.Buffer
+	gz     *gzip.Writer
+	tagBuf []byte
+}
+
+var _ splunker.Sink = (*Sink)(nil)
+
+// New connects to the forward input at cfg.Addr.
+func New(cfg Config) (*Sink, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("fluent: Addr is required")
+	}
+	if cfg.TagPrefix == "" {
+		cfg.TagPrefix = "splunk"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = 1 << 20
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+
+	s := &Sink{
+		cfg:   cfg,
+		index: make(map[string]*tagBatch),
+		enc:   msgpack.NewEncoder(nil),
+	}
+	if cfg.Compress {
+		s.gz = gzip.NewWriter(&s.gzBuf)
+	}
+
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Sink) dial() error {
+	dialer := &net.Dialer{Timeout: s.cfg.Timeout, KeepAlive: 30 * time.Second}
+
+	var err error
+	if s.cfg.TLSConfig != nil {
+		s.conn, err = tls.DialWithDialer(dialer, "tcp", s.cfg.Addr, s.cfg.TLSConfig)
+	} else {
+		s.conn, err = dialer.Dial("tcp", s.cfg.Addr)
+	}
+	if err != nil {
+		return fmt.Errorf("fluent: %w", err)
+	}
+	return nil
+}
+
+// appendTag appends the tag of e to b
+func (s *Sink) appendTag(b []byte, e splunker.Event) []byte {
+	b = append(b, s.cfg.TagPrefix...)
+	for _, part := range []string{e.Bucket().Index, e.SourceType()} {
+		b = append(b, '.')
+		if part == "" {
+			part = "none"
+		}
+		for i := 0; i < len(part); i++ {
+			c := part[i]
+			if c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+				b = append(b, c)
+			} else {
+				b = append(b, '_')
+			}
+		}
+	}
+	return b
+}
+
+func (s *Sink) WriteEvent(e splunker.Event) error {
+	s.tagBuf = s.appendTag(s.tagBuf[:0], e)
+	tb, ok := s.index[string(s.tagBuf)]
+	if !ok {
+		tb = &tagBatch{tag: string(s.tagBuf)}
+		s.index[tb.tag] = tb
+		s.tags = append(s.tags, tb)
+	}
+
+	n := tb.entries.Len()
+	s.enc.Reset(&tb.entries)
+	if err := s.encodeEntry(e); err != nil {
+		return fmt.Errorf("fluent: %w", err)
+	}
+	tb.count++
+	s.batched++
+	s.bytes += tb.entries.Len() - n
+
+	if s.batched >= s.cfg.BatchSize || s.bytes >= s.cfg.BatchBytes {
+		return s.Flush()
+	}
+	return nil
+}
+
+// encodeEntry encodes the [time, record] entry of e
+func (s *Sink) encodeEntry(e splunker.Event) error {
+	enc := s.enc
+	if err := enc.EncodeArrayLen(2); err != nil {
+		return err
+	}
+
+	// EventTime is an extension with seconds and nanoseconds as big endian uint32
+	t := e.Time()
+	if err := enc.EncodeExtHeader(eventTimeExt, 8); err != nil {
+		return err
+	}
+	var ts [8]byte
+	binary.BigEndian.PutUint32(ts[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(ts[4:], uint32(t.Nanosecond()))
+	if _, err := enc.Writer().Write(ts[:]); err != nil {
+		return err
+	}
+
+	type kv struct {
+		key    string
+		values []string
+	}
+	record := []kv{
+		{MessageKey, []string{e.MessageString()}},
+		{"host", []string{e.Host()}},
+		{"source", []string{e.Source()}},
+		{"sourcetype", []string{e.SourceType()}},
+		{"index", []string{e.Bucket().Index}},
+	}
+	for _, f := range e.IndexedFields() {
+		if reserved[f.Name] {
+			continue
+		}
+		if i := slices.IndexFunc(record, func(r kv) bool { return r.key == f.Name }); i >= 0 {
+			record[i].values = append(record[i].values, f.Value)
+		} else {
+			record = append(record, kv{f.Name, []string{f.Value}})
+		}
+	}
+
+	if err := enc.EncodeMapLen(len(record)); err != nil {
+		return err
+	}
+	for _, f := range record {
+		if err := enc.EncodeString(f.key); err != nil {
+			return err
+		}
+		// fields with multiple values are arrays
+		if len(f.values) > 1 {
+			if err := enc.EncodeArrayLen(len(f.values)); err != nil {
+				return err
+			}
+		}
+		for _, v := range f.values {
+			if err := enc.EncodeString(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Flush sends one message per tag with the batched entries.
+func (s *Sink) Flush() error {
+	for len(s.tags) > 0 {
+		tb := s.tags[0]
+		if err := s.send(tb); err != nil {
+			return err
+		}
+		s.tags = s.tags[1:]
+		delete(s.index, tb.tag)
+	}
+
+	s.tags = s.tags[:0]
+	s.batched, s.bytes = 0, 0
+	return nil
+}
+
+// send sends the message of tb, retrying on a new connection
+func (s *Sink) send(tb *tagBatch) error {
+	chunk, err := s.encodeMessage(tb)
+	if err != nil {
+		return fmt.Errorf("fluent: %w", err)
+	}
+
+	backoff := s.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			err = s.dial()
+		}
+		if s.conn != nil {
+			if err = s.sendOnce(chunk); err != nil {
+				_ = s.conn.Close()
+				s.conn = nil
+			}
+		}
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= s.cfg.MaxRetries {
+			return fmt.Errorf("fluent: giving up after %d attempts: %w", attempt+1, err)
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// encodeMessage encodes the PackedForward message of tb into s.msg and
+// returns its chunk id
+func (s *Sink) encodeMessage(tb *tagBatch) (string, error) {
+	entries := tb.entries.Bytes()
+	if s.gz != nil {
+		s.gzBuf.Reset()
+		s.gz.Reset(&s.gzBuf)
+		_, _ = s.gz.Write(entries)
+		if err := s.gz.Close(); err != nil {
+			return "", err
+		}
+		entries = s.gzBuf.Bytes()
+	}
+
+	var chunk string
+	options := map[string]any{"size": tb.count}
+	if s.gz != nil {
+		options["compressed"] = "gzip"
+	}
+	if s.cfg.RequireAck {
+		var id [16]byte
+		_, _ = rand.Read(id[:])
+		chunk = base64.StdEncoding.EncodeToString(id[:])
+		options["chunk"] = chunk
+	}
+
+	s.msg.Reset()
+	enc := s.enc
+	enc.Reset(&s.msg)
+	if err := enc.EncodeArrayLen(3); err != nil {
+		return "", err
+	}
+	if err := enc.EncodeString(tb.tag); err != nil {
+		return "", err
+	}
+	if err := enc.EncodeBytes(entries); err != nil {
+		return "", err
+	}
+	if err := enc.Encode(options); err != nil {
+		return "", err
+	}
+
+	return chunk, nil
+}
+
+func (s *Sink) sendOnce(chunk string) error {
+	if err := s.conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		return err
+	}
+	if _, err := s.conn.Write(s.msg.Bytes()); err != nil {
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+
+	var res struct {
+		Ack string `msgpack:"ack"`
+	}
+	if err := msgpack.NewDecoder(s.conn).Decode(&res); err != nil {
+		return fmt.Errorf("reading ack: %w", err)
+	}
+	if res.Ack != chunk {
+		return fmt.Errorf("ack %q does not match chunk %q", res.Ack, chunk)
+	}
+	return nil
+}
+
+// Close sends the batched events and closes the connection.
+func (s *Sink) Close() error {
+	err := s.Flush()
+	if s.conn != nil {
+		if cerr := s.conn.Close(); err == nil {
+			err = cerr
+		}
+		s.conn = nil
+	}
+	return err
+}
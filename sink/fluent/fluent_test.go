@@ -0,0 +1,149 @@
+package fluent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/fionera/splunker"
+)
+
+type entry struct {
+	tag    string
+	time   time.Time
+	record map[string]any
+}
+
+// receive decodes the PackedForward messages of a single connection
+// on l and acknowledges them
+func receive(t *testing.T, l net.Listener) <-chan []entry {
+	res := make(chan []entry, 1)
+	go func() {
+		defer close(res)
+
+		conn, err := l.Accept()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		var entries []entry
+		dec := msgpack.NewDecoder(conn)
+		for {
+			n, err := dec.DecodeArrayLen()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(t, err) || !assert.Equal(t, 3, n) {
+				return
+			}
+
+			tag, err := dec.DecodeString()
+			require.NoError(t, err)
+			data, err := dec.DecodeBytes()
+			require.NoError(t, err)
+			options, err := dec.DecodeMap()
+			require.NoError(t, err)
+
+			if options["compressed"] == "gzip" {
+				zr, err := gzip.NewReader(bytes.NewReader(data))
+				require.NoError(t, err)
+				data, err = io.ReadAll(zr)
+				require.NoError(t, err)
+			}
+
+			ed := msgpack.NewDecoder(bytes.NewReader(data))
+			count := 0
+			for {
+				if _, err := ed.DecodeArrayLen(); err == io.EOF {
+					break
+				}
+				id, l, err := ed.DecodeExtHeader()
+				require.NoError(t, err)
+				require.Equal(t, int8(eventTimeExt), id)
+				require.Equal(t, 8, l)
+				var ts [8]byte
+				_, err = io.ReadFull(ed.Buffered(), ts[:])
+				require.NoError(t, err)
+				record, err := ed.DecodeMap()
+				require.NoError(t, err)
+
+				entries = append(entries, entry{
+					tag:    tag,
+					time:   time.Unix(int64(binary.BigEndian.Uint32(ts[:4])), int64(binary.BigEndian.Uint32(ts[4:]))),
+					record: record,
+				})
+				count++
+			}
+			assert.EqualValues(t, options["size"], count)
+
+			if chunk, ok := options["chunk"]; ok {
+				b, err := msgpack.Marshal(map[string]any{"ack": chunk})
+				require.NoError(t, err)
+				_, err = conn.Write(b)
+				require.NoError(t, err)
+			}
+		}
+		res <- entries
+	}()
+	return res
+}
+
+func TestSink(t *testing.T) {
+	for _, cfg := range []Config{
+		{},
+		{Compress: true, RequireAck: true},
+	} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		entries := receive(t, l)
+
+		cfg.Addr = l.Addr().String()
+		cfg.BatchSize = 2
+		s, err := New(cfg)
+		require.NoError(t, err)
+
+		web := &splunker.Bucket{Index: "web"}
+		for _, info := range []splunker.EventInfo{
+			{
+				Time: time.Unix(1700000000, 250000000), Host: "web01", SourceType: "access_combined", Raw: []byte("first"), Bucket: web,
+				IndexedFields: []splunker.Field{{Name: "env", Value: "prod"}, {Name: "env", Value: "eu"}, {Name: "host", Value: "dropped"}},
+			},
+			{Time: time.Unix(1700000001, 0), Host: "win01", SourceType: "WinEventLog:Security", Raw: []byte("second"), Bucket: web},
+			{Time: time.Unix(1700000002, 0), Host: "web01", Raw: []byte("third")},
+		} {
+			require.NoError(t, s.WriteEvent(splunker.NewEvent(info)))
+		}
+		require.NoError(t, s.Close())
+
+		assert.Equal(t, []entry{
+			{
+				tag:  "splunk.web.access_combined",
+				time: time.Unix(1700000000, 250000000),
+				record: map[string]any{
+					"message": "first", "host": "web01", "source": "", "sourcetype": "access_combined", "index": "web",
+					"env": []any{"prod", "eu"},
+				},
+			},
+			{
+				tag:    "splunk.web.WinEventLog_Security",
+				time:   time.Unix(1700000001, 0),
+				record: map[string]any{"message": "second", "host": "win01", "source": "", "sourcetype": "WinEventLog:Security", "index": "web"},
+			},
+			{
+				tag:    "splunk.none.none",
+				time:   time.Unix(1700000002, 0),
+				record: map[string]any{"message": "third", "host": "web01", "source": "", "sourcetype": "", "index": ""},
+			},
+		}, <-entries)
+		_ = l.Close()
+	}
+}
@@ -0,0 +1,343 @@
+// Package hec forwards events to the HTTP Event Collector of Splunk.
+package hec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/internal/httpsink"
+)
+
+// Batching configures the batch size and the retries of failed requests.
+type Batching = httpsink.Batching
+
+// Config configures the Sink. Only URL and Token are required.
+type Config struct {
+	// URL of the collector, e.g. https://splunk:8088. The event endpoint
+	// /services/collector/event is appended if URL has no path.
+	URL   string
+	Token string
+	// Index overrides the index of the events if set
+	Index string
+
+	Batching
+	// BatchBytes is the maximum uncompressed size of a request, defaults to 1 MiB
+	BatchBytes int
+	// Gzip compresses the requests
+	Gzip bool
+
+	// TLSConfig is used for https URLs, ignored if Client is set
+	TLSConfig *tls.Config
+	// Client sends the requests, defaults to a client using TLSConfig
+	Client *http.Client
+
+	// Ack enables indexer acknowledgement. Close waits until all events
+	// are acknowledged or AckTimeout passed.
+	Ack bool
+	// Channel is sent with every request, required by HEC for
+	// acknowledgement. A random one is used if empty.
+	Channel string
+	// AckTimeout defaults to 5m
+	AckTimeout time.Duration
+	// AckInterval is the time between two acknowledgement polls, defaults to 1s
+	AckInterval time.Duration
+}
+
+const (
+	eventPath = "/services/collector/event"
+	ackPath   = "/services/collector/ack"
+
+	// maxPendingAcks triggers a poll while writing events
+	maxPendingAcks = 1000
+)
+
+// Sink sends events in batches to HEC.
+type Sink struct {
+	cfg      Config
+	eventURL string
+	ackURL   string
+	client   *http.Client
+
+	buf     bytes.Buffer
+	enc     *json.Encoder
+	batched int
+	gzBuf   bytes.Buffer
+	gz      *gzip.Writer
+
+	pending []int64
+}
+
+var _ splunker.Sink = (*Sink)(nil)
+
+// New creates a Sink sending to the collector at cfg.URL.
+func New(cfg Config) (*Sink, error) {
+	if cfg.URL == "" || cfg.Token == "" {
+		return nil, errors.New("hec: URL and Token are required")
+	}
+
+	cfg.SetDefaults()
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = 1 << 20
+	}
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = 5 * time.Minute
+	}
+	if cfg.AckInterval <= 0 {
+		cfg.AckInterval = time.Second
+	}
+	if cfg.Channel == "" {
+		cfg.Channel = newChannel()
+	}
+
+	s := &Sink{
+		cfg:    cfg,
+		client: cfg.Client,
+	}
+	if s.client == nil {
+		s.client = &http.Client{
+			Timeout: time.Minute,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: cfg.TLSConfig,
+			},
+		}
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("hec: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = eventPath
+	}
+	s.eventURL = u.String()
+	u.Path, u.RawQuery = ackPath, "channel="+url.QueryEscape(cfg.Channel)
+	s.ackURL = u.String()
+
+	s.enc = json.NewEncoder(&s.buf)
+	s.enc.SetEscapeHTML(false)
+	if cfg.Gzip {
+		s.gz = gzip.NewWriter(&s.gzBuf)
+	}
+
+	return s, nil
+}
+
+// newChannel returns a random UUID
+func newChannel() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// event is the JSON format of the event endpoint
+type event struct {
+	Time       json.Number    `json:"time"`
+	Host       string         `json:"host,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	SourceType string         `json:"sourcetype,omitempty"`
+	Index      string         `json:"index,omitempty"`
+	Event      string         `json:"event"`
+	Fields     map[string]any `json:"fields,omitempty"`
+}
+
+func (s *Sink) WriteEvent(e splunker.Event) error {
+	ev := event{
+		Time:       json.Number(e.Epoch()),
+		Host:       e.Host(),
+		Source:     e.Source(),
+		SourceType: e.SourceType(),
+		Index:      s.cfg.Index,
+		Event:      e.MessageString(),
+	}
+	if ev.Index == "" {
+		ev.Index = e.Bucket().Index
+	}
+	if fields := e.IndexedFields(); len(fields) > 0 {
+		// multiple values of a field are sent as array
+		ev.Fields = make(map[string]any, len(fields))
+		for _, f := range fields {
+			switch v := ev.Fields[f.Name].(type) {
+			case nil:
+				ev.Fields[f.Name] = f.Value
+			case string:
+				ev.Fields[f.Name] = []string{v, f.Value}
+			case []string:
+				ev.Fields[f.Name] = append(v, f.Value)
+			}
+		}
+	}
+
+	mark := s.buf.Len()
+	if err := s.enc.Encode(&ev); err != nil {
+		return fmt.Errorf("hec: %w", err)
+	}
+
+	// send the batch without the new event if it became too big
+	if s.batched > 0 && s.buf.Len() > s.cfg.BatchBytes {
+		encoded := append([]byte(nil), s.buf.Bytes()[mark:]...)
+		s.buf.Truncate(mark)
+		if err := s.Flush(); err != nil {
+			return err
+		}
+		s.buf.Write(encoded)
+	}
+
+	s.batched++
+	if s.batched >= s.cfg.BatchSize || s.buf.Len() >= s.cfg.BatchBytes {
+		return s.Flush()
+	}
+
+	return nil
+}
+
+// Flush sends the batched events.
+func (s *Sink) Flush() error {
+	if s.batched == 0 {
+		return nil
+	}
+
+	body := s.buf.Bytes()
+	if s.gz != nil {
+		s.gzBuf.Reset()
+		s.gz.Reset(&s.gzBuf)
+		_, _ = s.gz.Write(body)
+		if err := s.gz.Close(); err != nil {
+			return fmt.Errorf("hec: %w", err)
+		}
+		body = s.gzBuf.Bytes()
+	}
+
+	var res struct {
+		Text  string `json:"text"`
+		Code  int    `json:"code"`
+		AckID *int64 `json:"ackId"`
+	}
+	if err := s.post(s.eventURL, body, s.gz != nil, &res); err != nil {
+		return err
+	}
+
+	s.buf.Reset()
+	s.batched = 0
+
+	if s.cfg.Ack {
+		if res.AckID == nil {
+			return errors.New("hec: acknowledgement is not enabled for the token")
+		}
+		s.pending = append(s.pending, *res.AckID)
+		if len(s.pending) >= maxPendingAcks {
+			return s.pollAcks()
+		}
+	}
+
+	return nil
+}
+
+// StatusError is returned for requests rejected by HEC. Its Message
+// holds the text and the code of the HEC error.
+type StatusError = httpsink.StatusError
+
+// post sends body to u, retrying failed requests, and decodes the response into res
+func (s *Sink) post(u string, body []byte, gzipped bool, res any) error {
+	err := s.cfg.Retry(func() error {
+		return s.postOnce(u, body, gzipped, res)
+	})
+	if err != nil {
+		return fmt.Errorf("hec: %w", err)
+	}
+	return nil
+}
+
+func (s *Sink) postOnce(u string, body []byte, gzipped bool, res any) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Splunk "+s.cfg.Token)
+	req.Header.Set("X-Splunk-Request-Channel", s.cfg.Channel)
+	req.Header.Set("Content-Type", "application/json")
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	data, err := httpsink.Do(s.client, req)
+	if se := (*StatusError)(nil); errors.As(err, &se) {
+		var he struct {
+			Text string `json:"text"`
+			Code int    `json:"code"`
+		}
+		if json.Unmarshal(data, &he) == nil && he.Text != "" {
+			se.Message = fmt.Sprintf("%s (code %d)", he.Text, he.Code)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, res); err != nil {
+		// HEC accepted the request, sending it again would duplicate the events
+		return httpsink.Permanent(fmt.Errorf("invalid response: %w", err))
+	}
+
+	return nil
+}
+
+// pollAcks queries the acknowledgement status of the pending requests once
+func (s *Sink) pollAcks() error {
+	body, err := json.Marshal(map[string][]int64{"acks": s.pending})
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		Acks map[string]bool `json:"acks"`
+	}
+	if err := s.post(s.ackURL, body, false, &res); err != nil {
+		return err
+	}
+
+	pending := s.pending[:0]
+	for _, id := range s.pending {
+		if !res.Acks[strconv.FormatInt(id, 10)] {
+			pending = append(pending, id)
+		}
+	}
+	s.pending = pending
+
+	return nil
+}
+
+// Close sends the batched events and waits for the pending acknowledgements.
+func (s *Sink) Close() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(s.cfg.AckTimeout)
+	for len(s.pending) > 0 {
+		if err := s.pollAcks(); err != nil {
+			return err
+		}
+		if len(s.pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("hec: %d requests were not acknowledged within %s", len(s.pending), s.cfg.AckTimeout)
+		}
+		time.Sleep(s.cfg.AckInterval)
+	}
+
+	return nil
+}
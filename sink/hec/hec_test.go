@@ -0,0 +1,145 @@
+package hec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/internal/httpsink/httpsinktest"
+)
+
+func TestSink(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []map[string]any
+		acked  bool
+	)
+
+	srv := httpsinktest.NewServer(t, func(r httpsinktest.Request) httpsinktest.Response {
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.Equal(t, "Splunk secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "channel", r.Header.Get("X-Splunk-Request-Channel"))
+
+		switch r.URL.Path {
+		case eventPath:
+			// the first request fails and has to be retried
+			if events == nil {
+				events = []map[string]any{}
+				return httpsinktest.Response{Status: http.StatusServiceUnavailable, Body: `{"text":"Server is busy","code":9}`}
+			}
+
+			assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+			sc := bufio.NewScanner(bytes.NewReader(r.Body))
+			for sc.Scan() {
+				var e map[string]any
+				require.NoError(t, json.Unmarshal(sc.Bytes(), &e))
+				events = append(events, e)
+			}
+			return httpsinktest.Response{Body: `{"text":"Success","code":0,"ackId":7}`}
+		case ackPath:
+			assert.Equal(t, "channel", r.URL.Query().Get("channel"))
+			var req struct{ Acks []int64 }
+			require.NoError(t, json.Unmarshal(r.Body, &req))
+			assert.Equal(t, []int64{7}, req.Acks)
+			// acknowledge on the second poll
+			res, _ := json.Marshal(map[string]any{"acks": map[string]bool{"7": acked}})
+			acked = true
+			return httpsinktest.Response{Body: string(res)}
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+			return httpsinktest.Response{Status: http.StatusNotFound}
+		}
+	})
+
+	s, err := New(Config{
+		URL:         srv.URL,
+		Token:       "secret",
+		Index:       "copy",
+		Gzip:        true,
+		Batching:    httpsinktest.Batching,
+		Ack:         true,
+		Channel:     "channel",
+		AckInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, s.WriteEvent(splunker.NewEvent(splunker.EventInfo{
+		Time:       time.Unix(1700000000, 250000000),
+		Host:       "web01",
+		Source:     "/var/log/app.log",
+		SourceType: "app",
+		Raw:        []byte(`GET "/index.html" <200>`),
+		IndexedFields: []splunker.Field{
+			{Name: "env", Value: "prod"},
+			{Name: "env", Value: "eu"},
+			{Name: "team", Value: "ops"},
+		},
+	})))
+	require.NoError(t, s.WriteEvent(splunker.NewEvent(splunker.EventInfo{
+		Time: time.Unix(1700000001, 0),
+		Raw:  []byte("plain"),
+	})))
+	require.NoError(t, s.Close())
+
+	assert.Len(t, srv.Requests(), 4)
+	assert.True(t, acked)
+	assert.Equal(t, []map[string]any{
+		{
+			"time":       1700000000.25,
+			"host":       "web01",
+			"source":     "/var/log/app.log",
+			"sourcetype": "app",
+			"index":      "copy",
+			"event":      `GET "/index.html" <200>`,
+			"fields":     map[string]any{"env": []any{"prod", "eu"}, "team": "ops"},
+		},
+		{
+			"time":  float64(1700000001),
+			"index": "copy",
+			"event": "plain",
+		},
+	}, events)
+}
+
+func TestSinkRejected(t *testing.T) {
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(httpsinktest.Response{
+		Status: http.StatusForbidden,
+		Body:   `{"text":"Invalid token","code":4}`,
+	}))
+
+	s, err := New(Config{URL: srv.URL, Token: "wrong", Batching: httpsinktest.Batching})
+	require.NoError(t, err)
+
+	require.NoError(t, s.WriteEvent(splunker.NewEvent(splunker.EventInfo{Raw: []byte("x")})))
+	err = s.Close()
+
+	var se *StatusError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, http.StatusForbidden, se.StatusCode)
+	assert.Equal(t, "Invalid token (code 4)", se.Message)
+	assert.EqualError(t, err, "hec: status 403: Invalid token (code 4)")
+	assert.Len(t, srv.Requests(), 1)
+}
+
+func TestSinkInvalidResponse(t *testing.T) {
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(httpsinktest.Response{Body: "<html>"}))
+
+	s, err := New(Config{URL: srv.URL, Token: "secret", Batching: httpsinktest.Batching})
+	require.NoError(t, err)
+
+	require.NoError(t, s.WriteEvent(splunker.NewEvent(splunker.EventInfo{Raw: []byte("x")})))
+
+	// the events were accepted, a retry would duplicate them
+	assert.ErrorContains(t, s.Close(), "hec: invalid response")
+	assert.Len(t, srv.Requests(), 1)
+}
@@ -0,0 +1,324 @@
+// Package hive writes events as JSON Lines into a directory tree
+// partitioned like Hive tables, e.g.
+//
+//	index=main/sourcetype=syslog/date=2023-11-14/hour=22/part-0.jsonl.zst
+//
+// The lines have the layout of package jsonl and are zstd compressed.
+// Parts roll at a configurable size. Close writes manifest.json with the
+// row count and time bounds of every part.
+package hive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/jsonl"
+)
+
+// DefaultKeys are used if Config.Keys is empty.
+var DefaultKeys = []string{"index", "sourcetype", "date", "hour"}
+
+// ManifestName is the name of the manifest in the export directory.
+const ManifestName = "manifest.json"
+
+// defaultPartition is the value of empty partition keys, like Hive
+const defaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// Config configures the Writer. Only Dir is required.
+type Config struct {
+	// Dir is the root of the directory tree. It must not contain
+	// an export already.
+	Dir string
+	// Keys are the partition keys in order. date (YYYY-MM-DD) and hour
+	// (HH) are taken from _time in UTC, index, host, source and sourcetype
+	// from the fields of the same name, any other key is an indexed field.
+	// Defaults to DefaultKeys.
+	Keys []string
+	// MaxPartSize rolls a part once it reaches this many uncompressed
+	// bytes, defaults to 128 MiB
+	MaxPartSize int64
+	// MaxOpenParts limits the number of parts written at the same time.
+	// The least recently written part is closed when it is exceeded.
+	// Defaults to 64.
+	MaxOpenParts int
+}
+
+// Part is a file of the export as listed in the manifest.
+type Part struct {
+	// Path relative to the export directory
+	Path string `json:"path"`
+	// Partition holds the values of the partition keys
+	Partition map[string]string `json:"partition"`
+	Rows      int64             `json:"rows"`
+	// Bytes is the uncompressed size
+	Bytes    int64     `json:"bytes"`
+	Earliest time.Time `json:"earliest"`
+	Latest   time.Time `json:"latest"`
+}
+
+// Manifest is written to ManifestName when the Writer is closed.
+type Manifest struct {
+	Keys  []string `json:"keys"`
+	Rows  int64    `json:"rows"`
+	Parts []Part   `json:"parts"`
+}
+
+type openPart struct {
+	Part
+	f        *os.File
+	zw       *zstd.Encoder
+	lastUsed uint64
+}
+
+type partition struct {
+	dir    string
+	values map[string]string
+	next   int
+	open   *openPart
+}
+
+// Writer writes events into the partitioned directory tree.
+type Writer struct {
+	cfg        Config
+	partitions map[string]*partition
+	open       int
+	parts      []Part
+	// clock orders the parts by their last write
+	clock uint64
+
+	key  []byte
+	line []byte
+}
+
+var _ splunker.Sink = (*Writer)(nil)
+
+func New(cfg Config) (*Writer, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("hive: Dir is required")
+	}
+	if len(cfg.Keys) == 0 {
+		cfg.Keys = DefaultKeys
+	}
+	if cfg.MaxPartSize <= 0 {
+		cfg.MaxPartSize = 128 << 20
+	}
+	if cfg.MaxOpenParts <= 0 {
+		cfg.MaxOpenParts = 64
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.Dir, ManifestName)); err == nil {
+		return nil, fmt.Errorf("hive: %s already contains an export", cfg.Dir)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		cfg:        cfg,
+		partitions: make(map[string]*partition),
+	}, nil
+}
+
+// keyValue returns the value of the partition key for e
+func keyValue(e splunker.Event, key string) string {
+	switch key {
+	case "date":
+		return e.Time().UTC().Format("2006-01-02")
+	case "hour":
+		return e.Time().UTC().Format("15")
+	case "index":
+		return e.Bucket().Index
+	case "host":
+		return e.Host()
+	case "source":
+		return e.Source()
+	case "sourcetype":
+		return e.SourceType()
+	}
+
+	for _, f := range e.IndexedFields() {
+		if f.Name == key {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// appendEscaped appends s with the characters that Hive escapes in
+// partition values percent encoded
+func appendEscaped(b []byte, s string) []byte {
+	if s == "" {
+		return append(b, defaultPartition...)
+	}
+
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			b = append(b, '%', hex[c>>4], hex[c&0xF])
+			continue
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+func (w *Writer) WriteEvent(e splunker.Event) error {
+	w.key = w.key[:0]
+	for i, k := range w.cfg.Keys {
+		if i > 0 {
+			w.key = append(w.key, '/')
+		}
+		w.key = append(w.key, k...)
+		w.key = append(w.key, '=')
+		w.key = appendEscaped(w.key, keyValue(e, k))
+	}
+
+	p, ok := w.partitions[string(w.key)]
+	if !ok {
+		p = &partition{dir: string(w.key), values: make(map[string]string, len(w.cfg.Keys))}
+		for _, k := range w.cfg.Keys {
+			p.values[k] = keyValue(e, k)
+		}
+		w.partitions[p.dir] = p
+	}
+
+	if p.open == nil {
+		if err := w.openPart(p); err != nil {
+			return err
+		}
+	}
+
+	op := p.open
+	w.line = jsonl.AppendEvent(w.line[:0], e)
+	w.line = append(w.line, '\n')
+	if _, err := op.zw.Write(w.line); err != nil {
+		return fmt.Errorf("hive: %s: %w", op.Path, err)
+	}
+
+	t := e.Time()
+	if op.Rows == 0 || t.Before(op.Earliest) {
+		op.Earliest = t
+	}
+	if op.Rows == 0 || t.After(op.Latest) {
+		op.Latest = t
+	}
+	op.Rows++
+	op.Bytes += int64(len(w.line))
+	w.clock++
+	op.lastUsed = w.clock
+
+	if op.Bytes >= w.cfg.MaxPartSize {
+		return w.closePart(p)
+	}
+	return nil
+}
+
+func (w *Writer) openPart(p *partition) error {
+	if w.open >= w.cfg.MaxOpenParts {
+		if err := w.closeLeastRecent(); err != nil {
+			return err
+		}
+	}
+
+	dir := filepath.Join(w.cfg.Dir, filepath.FromSlash(p.dir))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	name := "part-" + strconv.Itoa(p.next) + ".jsonl.zst"
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	// the encoders of all open parts exist at the same time, keep them small
+	zw, err := zstd.NewWriter(f, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("zstd.NewWriter: %v", err)
+	}
+
+	p.next++
+	p.open = &openPart{
+		Part: Part{Path: p.dir + "/" + name, Partition: p.values},
+		f:    f,
+		zw:   zw,
+	}
+	w.open++
+
+	return nil
+}
+
+func (w *Writer) closeLeastRecent() error {
+	var oldest *partition
+	for _, p := range w.partitions {
+		if p.open != nil && (oldest == nil || p.open.lastUsed < oldest.open.lastUsed) {
+			oldest = p
+		}
+	}
+	if oldest == nil {
+		return nil
+	}
+	return w.closePart(oldest)
+}
+
+// closePart finishes the open part of p and adds it to the manifest
+func (w *Writer) closePart(p *partition) error {
+	op := p.open
+	p.open = nil
+	w.open--
+
+	err := op.zw.Close()
+	if cerr := op.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("hive: %s: %w", op.Path, err)
+	}
+
+	w.parts = append(w.parts, op.Part)
+	return nil
+}
+
+// Close finishes all parts and writes the manifest.
+func (w *Writer) Close() error {
+	var errs []error
+	for _, p := range w.partitions {
+		if p.open != nil {
+			if err := w.closePart(p); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	m := Manifest{Keys: w.cfg.Keys, Parts: w.parts}
+	if m.Parts == nil {
+		m.Parts = []Part{}
+	}
+	slices.SortFunc(m.Parts, func(a, b Part) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	for _, p := range m.Parts {
+		m.Rows += p.Rows
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(w.cfg.Dir, ManifestName), append(data, '\n'), 0o644)
+}
@@ -0,0 +1,118 @@
+package hive
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+func readLines(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	require.NoError(t, err)
+	defer zr.Close()
+
+	var lines []string
+	s := bufio.NewScanner(zr)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	require.NoError(t, s.Err())
+	return lines
+}
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Dir: dir, MaxPartSize: 400, MaxOpenParts: 1})
+	require.NoError(t, err)
+
+	main := &splunker.Bucket{Index: "main"}
+	base := time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		// alternate between two partitions to close the parts by MaxOpenParts
+		st := "access_combined"
+		if i%2 == 1 {
+			st = "a/b"
+		}
+		require.NoError(t, w.WriteEvent(splunker.NewEvent(splunker.EventInfo{
+			Time:       base.Add(time.Duration(i) * time.Minute),
+			Host:       "web01",
+			SourceType: st,
+			Raw:        []byte("GET /index.html 200"),
+			Bucket:     main,
+		})))
+	}
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	require.NoError(t, err)
+	var m Manifest
+	require.NoError(t, json.Unmarshal(data, &m))
+
+	assert.Equal(t, DefaultKeys, m.Keys)
+	assert.EqualValues(t, 6, m.Rows)
+	require.Len(t, m.Parts, 6)
+
+	p := m.Parts[0]
+	assert.Equal(t, "index=main/sourcetype=a%2Fb/date=2023-11-14/hour=22/part-0.jsonl.zst", p.Path)
+	assert.Equal(t, map[string]string{"index": "main", "sourcetype": "a/b", "date": "2023-11-14", "hour": "22"}, p.Partition)
+	assert.EqualValues(t, 1, p.Rows)
+	assert.True(t, base.Add(time.Minute).Equal(p.Earliest))
+	assert.True(t, p.Earliest.Equal(p.Latest))
+
+	lines := readLines(t, filepath.Join(dir, filepath.FromSlash(p.Path)))
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], `"sourcetype":"a/b"`)
+	assert.EqualValues(t, len(lines[0])+1, p.Bytes)
+
+	_, err = New(Config{Dir: dir})
+	assert.Error(t, err)
+}
+
+func TestWriterRoll(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Dir: dir, Keys: []string{"host", "vendor"}, MaxPartSize: 250})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, w.WriteEvent(splunker.NewEvent(splunker.EventInfo{
+			Time: time.Unix(1700000000+int64(i), 0),
+			Host: "fw01",
+			Raw:  []byte("allow tcp 10.0.0.1 -> 10.0.0.2"),
+		})))
+	}
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	require.NoError(t, err)
+	var m Manifest
+	require.NoError(t, json.Unmarshal(data, &m))
+
+	require.Len(t, m.Parts, 3)
+	assert.Equal(t, "host=fw01/vendor=__HIVE_DEFAULT_PARTITION__/part-0.jsonl.zst", m.Parts[0].Path)
+	var rows int64
+	for _, p := range m.Parts {
+		rows += p.Rows
+		assert.Len(t, readLines(t, filepath.Join(dir, filepath.FromSlash(p.Path))), int(p.Rows))
+	}
+	assert.EqualValues(t, 5, rows)
+	assert.True(t, time.Unix(1700000004, 0).Equal(m.Parts[2].Latest))
+}
+
+func TestAppendEscaped(t *testing.T) {
+	assert.Equal(t, "C%3A%5Clogs%5Cweb.log", string(appendEscaped(nil, `C:\logs\web.log`)))
+	assert.Equal(t, "web 01", string(appendEscaped(nil, "web 01")))
+	assert.Equal(t, defaultPartition, string(appendEscaped(nil, "")))
+}
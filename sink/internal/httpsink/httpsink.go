@@ -0,0 +1,148 @@
+// Package httpsink holds the batching and retry logic shared by the sinks
+// posting to HTTP endpoints, so all of them classify failed requests alike.
+package httpsink
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxBackoff is the longest wait between two attempts.
+const MaxBackoff = 30 * time.Second
+
+// Batching configures the batches of a sink and the retries of its
+// failed requests.
+type Batching struct {
+	// BatchSize is the maximum number of events per request, defaults to 1000
+	BatchSize int
+	// MaxRetries is the number of retries of a failed request, defaults to 5
+	MaxRetries int
+	// Backoff is the wait time before the first retry, it doubles with
+	// every retry up to 30s. Defaults to 1s.
+	Backoff time.Duration
+}
+
+// SetDefaults sets the zero fields of b to their defaults.
+func (b *Batching) SetDefaults() {
+	if b.BatchSize <= 0 {
+		b.BatchSize = 1000
+	}
+	if b.MaxRetries <= 0 {
+		b.MaxRetries = 5
+	}
+	if b.Backoff <= 0 {
+		b.Backoff = time.Second
+	}
+}
+
+// Retry calls send until it succeeds, fails with an error that is not
+// Retryable or MaxRetries retries failed. The wait between two attempts
+// doubles from Backoff up to MaxBackoff, but is at least the Retry-After
+// of a StatusError.
+func (b Batching) Retry(send func() error) error {
+	backoff := b.Backoff
+
+	for attempt := 0; ; attempt++ {
+		err := send()
+		if err == nil || !Retryable(err) {
+			return err
+		}
+		if attempt >= b.MaxRetries {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		}
+
+		wait := backoff
+		var se *StatusError
+		if errors.As(err, &se) {
+			wait = max(wait, se.RetryAfter)
+		}
+		time.Sleep(wait)
+		backoff = min(backoff*2, MaxBackoff)
+	}
+}
+
+// StatusError is returned for requests answered with a status other than 2xx.
+type StatusError struct {
+	StatusCode int
+	Header     http.Header
+	// Message is the error message of the response, by default its body
+	Message string
+	// RetryAfter is the wait time requested by the Retry-After header
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+}
+
+// permanentError marks an error that must not be retried
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not Retryable, e.g. because the request was
+// accepted and sending it again would duplicate the events.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// Retryable reports whether the request that failed with err may succeed
+// when sent again. Errors marked Permanent and a StatusError with a
+// status that is not RetryableStatus are not, any other error, e.g. a
+// connection failure, is.
+func Retryable(err error) bool {
+	if errors.As(err, new(permanentError)) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return RetryableStatus(se.StatusCode)
+	}
+	return true
+}
+
+// RetryableStatus reports whether a request answered with code may
+// succeed when sent again, which are 429 Too Many Requests and 5xx.
+func RetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// Do sends req with client and returns the body of the response. A
+// status other than 2xx is returned as StatusError.
+func Do(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		se := &StatusError{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Message:    strings.TrimSpace(string(data)),
+		}
+		if sec, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			se.RetryAfter = time.Duration(sec) * time.Second
+		}
+		return data, se
+	}
+
+	return data, nil
+}
@@ -0,0 +1,82 @@
+package httpsink_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker/sink/internal/httpsink"
+	"github.com/fionera/splunker/sink/internal/httpsink/httpsinktest"
+)
+
+func TestRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{errors.New("connection refused"), true},
+		{&httpsink.StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{&httpsink.StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&httpsink.StatusError{StatusCode: http.StatusBadRequest}, false},
+		{httpsink.Permanent(errors.New("invalid response")), false},
+		{httpsink.Permanent(&httpsink.StatusError{StatusCode: http.StatusServiceUnavailable}), false},
+	} {
+		assert.Equal(t, tc.retryable, httpsink.Retryable(tc.err), tc.err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(
+		httpsinktest.Response{Status: http.StatusServiceUnavailable, Body: "busy\n"},
+		httpsinktest.Response{Status: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"0"}}},
+		httpsinktest.Response{Body: "ok"},
+		httpsinktest.Response{Status: http.StatusBadRequest, Body: "bad"},
+	))
+
+	post := func() ([]byte, error) {
+		req, err := http.NewRequest(http.MethodPost, srv.URL, nil)
+		require.NoError(t, err)
+		return httpsink.Do(http.DefaultClient, req)
+	}
+
+	b := httpsinktest.Batching
+	b.SetDefaults()
+
+	// the temporary failures are retried
+	var data []byte
+	require.NoError(t, b.Retry(func() (err error) {
+		data, err = post()
+		return err
+	}))
+	assert.Equal(t, "ok", string(data))
+	assert.Len(t, srv.Requests(), 3)
+
+	// the permanent one is not
+	err := b.Retry(func() error {
+		_, err := post()
+		return err
+	})
+	var se *httpsink.StatusError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, http.StatusBadRequest, se.StatusCode)
+	assert.Equal(t, "bad", se.Message)
+	assert.Len(t, srv.Requests(), 4)
+}
+
+func TestRetryGiveUp(t *testing.T) {
+	b := httpsinktest.Batching
+	b.MaxRetries = 2
+
+	attempts := 0
+	busy := &httpsink.StatusError{StatusCode: http.StatusServiceUnavailable, Message: "busy"}
+	err := b.Retry(func() error {
+		attempts++
+		return busy
+	})
+	assert.EqualError(t, err, "giving up after 3 attempts: status 503: busy")
+	assert.ErrorIs(t, err, busy)
+	assert.Equal(t, 3, attempts)
+}
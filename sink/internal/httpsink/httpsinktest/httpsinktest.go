@@ -0,0 +1,104 @@
+// Package httpsinktest provides the test server of the HTTP sinks.
+package httpsinktest
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fionera/splunker/sink/internal/httpsink"
+)
+
+// Batching retries quickly, so tests of failed requests do not wait.
+var Batching = httpsink.Batching{Backoff: time.Millisecond}
+
+// Request is a request received by the Server. Body is decompressed if
+// the request is gzip encoded.
+type Request struct {
+	*http.Request
+	Body []byte
+}
+
+// Response is the answer to a Request.
+type Response struct {
+	// Status defaults to 200 OK
+	Status int
+	Header http.Header
+	Body   string
+}
+
+// Server is an httptest.Server recording the requests it receives.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// NewServer starts a Server answering every request with respond. It is
+// closed at the end of the test.
+func NewServer(t testing.TB, respond func(r Request) Response) *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("invalid gzip body: %v", err)
+				return
+			}
+			body = zr
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+			return
+		}
+
+		req := Request{Request: r, Body: data}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+
+		res := respond(req)
+		for k, v := range res.Header {
+			w.Header()[k] = v
+		}
+		if res.Status != 0 {
+			w.WriteHeader(res.Status)
+		}
+		_, _ = io.WriteString(w, res.Body)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Sequence answers the requests with responses in order and repeats the
+// last one.
+func Sequence(responses ...Response) func(Request) Response {
+	var (
+		mu sync.Mutex
+		i  int
+	)
+	return func(Request) Response {
+		mu.Lock()
+		defer mu.Unlock()
+
+		res := responses[min(i, len(responses)-1)]
+		i++
+		return res
+	}
+}
+
+// Requests returns the received requests.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
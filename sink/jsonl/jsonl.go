@@ -0,0 +1,202 @@
+// Package jsonl writes events as JSON Lines, one object per line.
+//
+// Every object contains the following keys, using the names of the
+// corresponding Splunk fields where one exists:
+//
+//	_raw               the event text
+//	_time              unix timestamp, the subseconds are the fraction
+//	host, source, sourcetype, index
+//	_bkt               bucket as <index>~<id>[~<guid>]
+//	_stream_id         id of the input stream the event was read from
+//	_stream_offset     offset of the chunk within the stream
+//	_stream_suboffset  offset of the event within the chunk
+//	_hash              hex encoded hash, only if the journal stores one
+//
+// The indexed fields of the event follow as additional keys. Fields with
+// multiple values are written as array, fields named like one of the keys
+// above are dropped.
+package jsonl
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/fionera/splunker"
+)
+
+// reserved are the keys that can not be used by indexed fields
+var reserved = map[string]bool{
+	"_raw":              true,
+	"_time":             true,
+	"host":              true,
+	"source":            true,
+	"sourcetype":        true,
+	"index":             true,
+	"_bkt":              true,
+	"_stream_id":        true,
+	"_stream_offset":    true,
+	"_stream_suboffset": true,
+	"_hash":             true,
+}
+
+// Writer writes events as JSON Lines.
+type Writer struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+var _ splunker.Sink = (*Writer)(nil)
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriterSize(w, 1<<20)}
+}
+
+func (w *Writer) WriteEvent(e splunker.Event) error {
+	w.buf = AppendEvent(w.buf[:0], e)
+	w.buf = append(w.buf, '\n')
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Close flushes the buffered events.
+func (w *Writer) Close() error {
+	return w.w.Flush()
+}
+
+// AppendEvent appends the JSON object of e to b.
+func AppendEvent(b []byte, e splunker.Event) []byte {
+	bucket := e.Bucket()
+
+	b = append(b, `{"_raw":`...)
+	b = appendString(b, e.Message())
+	b = append(b, `,"_time":`...)
+	b = append(b, e.Epoch()...)
+	b = appendKeyValue(b, "host", e.Host())
+	b = appendKeyValue(b, "source", e.Source())
+	b = appendKeyValue(b, "sourcetype", e.SourceType())
+	b = appendKeyValue(b, "index", bucket.Index)
+	if bucket.Path != "" {
+		b = appendKeyValue(b, "_bkt", bucket.SplunkID())
+	}
+	b = append(b, `,"_stream_id":`...)
+	b = strconv.AppendUint(b, e.StreamID(), 10)
+	b = append(b, `,"_stream_offset":`...)
+	b = strconv.AppendUint(b, e.StreamOffset(), 10)
+	b = append(b, `,"_stream_suboffset":`...)
+	b = strconv.AppendUint(b, e.StreamSubOffset(), 10)
+	if hash, ok := e.Hash(); ok {
+		b = append(b, `,"_hash":"`...)
+		b = hex.AppendEncode(b, hash[:])
+		b = append(b, '"')
+	}
+
+	fields := e.IndexedFields()
+	for i, f := range fields {
+		if reserved[f.Name] || seenBefore(fields[:i], f.Name) {
+			continue
+		}
+
+		b = append(b, ',')
+		b = appendString(b, f.Name)
+		b = append(b, ':')
+
+		n := 0
+		for _, other := range fields[i+1:] {
+			if other.Name == f.Name {
+				n++
+			}
+		}
+		if n == 0 {
+			b = appendString(b, f.Value)
+			continue
+		}
+
+		b = append(b, '[')
+		b = appendString(b, f.Value)
+		for _, other := range fields[i+1:] {
+			if other.Name == f.Name {
+				b = append(b, ',')
+				b = appendString(b, other.Value)
+			}
+		}
+		b = append(b, ']')
+	}
+
+	return append(b, '}')
+}
+
+func seenBefore(fields []splunker.Field, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func appendKeyValue(b []byte, key, value string) []byte {
+	b = append(b, ',', '"')
+	b = append(b, key...)
+	b = append(b, '"', ':')
+	return appendString(b, value)
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendString appends s as JSON string. Invalid UTF-8 is replaced
+// with U+FFFD like encoding/json does.
+func appendString[T string | []byte](b []byte, s T) []byte {
+	b = append(b, '"')
+
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(string(s[i:min(i+utf8.UTFMax, len(s))]))
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\uFFFD"...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 break JavaScript parsers
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
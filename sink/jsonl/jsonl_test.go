@@ -0,0 +1,52 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+func TestAppendEvent(t *testing.T) {
+	e := splunker.NewEvent(splunker.EventInfo{
+		Time:       time.Unix(1700000000, 123000),
+		Host:       "web01",
+		Source:     "/var/log/app.log",
+		SourceType: "app",
+		Raw:        []byte("line \"one\"\n\tand \xff two"),
+		Bucket:     &splunker.Bucket{Path: "/data/web/db/db_2_1_42", Index: "web", ID: 42},
+		StreamID:   7,
+		HasHash:    true,
+		Hash:       [20]byte{0xab},
+		IndexedFields: []splunker.Field{
+			{Name: "user", Value: "root"},
+			{Name: "tag", Value: "a"},
+			{Name: "host", Value: "ignored"},
+			{Name: "tag", Value: "b"},
+		},
+	})
+
+	b := AppendEvent(nil, e)
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(b, &got), string(b))
+
+	assert.Equal(t, map[string]any{
+		"_raw":              "line \"one\"\n\tand � two",
+		"_time":             1700000000.000123,
+		"host":              "web01",
+		"source":            "/var/log/app.log",
+		"sourcetype":        "app",
+		"index":             "web",
+		"_bkt":              "web~42",
+		"_stream_id":        float64(7),
+		"_stream_offset":    float64(0),
+		"_stream_suboffset": float64(0),
+		"_hash":             "ab00000000000000000000000000000000000000",
+		"user":              "root",
+		"tag":               []any{"a", "b"},
+	}, got)
+}
@@ -0,0 +1,336 @@
+// Package loki pushes events to Grafana Loki.
+//
+// Events are grouped into streams by a set of labels taken from the
+// event. The labels host, source, sourcetype and index are the fields of
+// the same name, any other label is the indexed field with that name.
+// Requests are protobuf encoded and snappy compressed like the ones of
+// promtail. Loki rejects entries older than the newest of their stream
+// unless unordered writes are enabled, so sorted input (-sorted) is
+// recommended; within a batch the entries of a stream are sorted by time.
+package loki
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/internal/httpsink"
+)
+
+// DefaultLabels are used if Config.Labels is empty.
+var DefaultLabels = []string{"host", "sourcetype", "index"}
+
+// Batching configures the batch size and the retries of failed requests.
+type Batching = httpsink.Batching
+
+// Config configures the Sink. Only URL is required.
+type Config struct {
+	// URL of Loki, e.g. http://loki:3100. The push endpoint
+	// /loki/api/v1/push is appended if URL has no path.
+	URL string
+	// TenantID is sent as X-Scope-OrgID if set
+	TenantID string
+	// Username and Password are sent with basic authentication if set
+	Username string
+	Password string
+
+	// Labels selects the stream labels, defaults to DefaultLabels
+	Labels []string
+	// StructuredMetadata attaches the indexed fields that are not labels
+	// to the entries. Requires Loki 3 or newer.
+	StructuredMetadata bool
+
+	// Batching limits the number of entries per request
+	Batching
+	// BatchBytes is the maximum size of the lines in a request, defaults to 1 MiB
+	BatchBytes int
+
+	// Client sends the requests, defaults to a client with a timeout of 1m
+	Client *http.Client
+}
+
+const pushPath = "/loki/api/v1/push"
+
+type entry struct {
+	time     time.Time
+	line     string
+	metadata []splunker.Field
+}
+
+type stream struct {
+	labels  string
+	entries []entry
+}
+
+// Sink pushes events in batches to Loki.
+type Sink struct {
+	cfg    Config
+	url    string
+	client *http.Client
+	labels []string
+
+	// streams holds the batch in the order the streams were seen
+	streams []*stream
+	index   map[string]*stream
+	batched int
+	bytes   int
+
+	labelBuf []byte
+	req      []byte
+	body     []byte
+}
+
+var _ splunker.Sink = (*Sink)(nil)
+
+// New creates a Sink pushing to Loki at cfg.URL.
+func New(cfg Config) (*Sink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("loki: URL is required")
+	}
+	if len(cfg.Labels) == 0 {
+		cfg.Labels = DefaultLabels
+	}
+	cfg.SetDefaults()
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = 1 << 20
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("loki: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = pushPath
+	}
+
+	s := &Sink{
+		cfg:    cfg,
+		url:    u.String(),
+		client: cfg.Client,
+		index:  make(map[string]*stream),
+	}
+	if s.client == nil {
+		s.client = &http.Client{Timeout: time.Minute}
+	}
+
+	// labels are sorted by name like Loki does
+	s.labels = slices.Clone(cfg.Labels)
+	slices.Sort(s.labels)
+	s.labels = slices.Compact(s.labels)
+
+	return s, nil
+}
+
+// labelValue returns the value of the label name for e
+func labelValue(e splunker.Event, name string) string {
+	switch name {
+	case "host":
+		return e.Host()
+	case "source":
+		return e.Source()
+	case "sourcetype":
+		return e.SourceType()
+	case "index":
+		return e.Bucket().Index
+	}
+
+	for _, f := range e.IndexedFields() {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// FallbackLabels are the labels of events without any of the configured
+// labels, as Loki rejects streams without labels. Loki uses the same
+// label for logs without a service.
+const FallbackLabels = `{service_name="unknown_service"}`
+
+// appendLabels appends the label set of e in the Prometheus format,
+// e.g. {host="web01", sourcetype="app"}. Empty labels are omitted,
+// without any label FallbackLabels are appended.
+func (s *Sink) appendLabels(b []byte, e splunker.Event) []byte {
+	start := len(b)
+	b = append(b, '{')
+	first := true
+	for _, name := range s.labels {
+		value := labelValue(e, name)
+		if value == "" {
+			continue
+		}
+
+		if !first {
+			b = append(b, ", "...)
+		}
+		first = false
+		b = appendLabelName(b, name)
+		b = append(b, '=')
+		b = strconv.AppendQuote(b, strings.ToValidUTF8(value, "�"))
+	}
+	if first {
+		return append(b[:start], FallbackLabels...)
+	}
+	return append(b, '}')
+}
+
+// appendLabelName appends name with every character that is not
+// allowed in label names replaced by _
+func appendLabelName(b []byte, name string) []byte {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			b = append(b, c)
+		} else {
+			b = append(b, '_')
+		}
+	}
+	return b
+}
+
+func (s *Sink) WriteEvent(e splunker.Event) error {
+	// copied, the message of e may be reused
+	line := string(e.Message())
+	if !utf8.ValidString(line) {
+		line = strings.ToValidUTF8(line, "�")
+	}
+
+	if s.batched > 0 && s.bytes+len(line) > s.cfg.BatchBytes {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+	}
+
+	s.labelBuf = s.appendLabels(s.labelBuf[:0], e)
+	st, ok := s.index[string(s.labelBuf)]
+	if !ok {
+		st = &stream{labels: string(s.labelBuf)}
+		s.index[st.labels] = st
+		s.streams = append(s.streams, st)
+	}
+
+	en := entry{time: e.Time(), line: line}
+	if s.cfg.StructuredMetadata {
+		for _, f := range e.IndexedFields() {
+			if !slices.Contains(s.labels, f.Name) {
+				en.metadata = append(en.metadata, f)
+			}
+		}
+	}
+	st.entries = append(st.entries, en)
+
+	s.batched++
+	s.bytes += len(line)
+	if s.batched >= s.cfg.BatchSize {
+		return s.Flush()
+	}
+
+	return nil
+}
+
+// appendPushRequest appends the PushRequest of the batch to b
+func (s *Sink) appendPushRequest(b []byte) []byte {
+	var st, en, ts, md []byte
+	for _, stream := range s.streams {
+		slices.SortStableFunc(stream.entries, func(a, b entry) int {
+			return a.time.Compare(b.time)
+		})
+
+		st = protowire.AppendTag(st[:0], 1, protowire.BytesType)
+		st = protowire.AppendString(st, stream.labels)
+		for _, e := range stream.entries {
+			ts = protowire.AppendTag(ts[:0], 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(e.time.Unix()))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(e.time.Nanosecond()))
+
+			en = appendMessage(en[:0], 1, ts)
+			en = protowire.AppendTag(en, 2, protowire.BytesType)
+			en = protowire.AppendString(en, e.line)
+			for _, f := range e.metadata {
+				md = protowire.AppendTag(md[:0], 1, protowire.BytesType)
+				md = protowire.AppendString(md, f.Name)
+				md = protowire.AppendTag(md, 2, protowire.BytesType)
+				md = protowire.AppendString(md, strings.ToValidUTF8(f.Value, "�"))
+				en = appendMessage(en, 3, md)
+			}
+
+			st = appendMessage(st, 2, en)
+		}
+
+		b = appendMessage(b, 1, st)
+	}
+	return b
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// Flush pushes the batched events.
+func (s *Sink) Flush() error {
+	if s.batched == 0 {
+		return nil
+	}
+
+	s.req = s.appendPushRequest(s.req[:0])
+	s.body = snappy.Encode(s.body[:cap(s.body)], s.req)
+
+	if err := s.post(s.body); err != nil {
+		return err
+	}
+
+	s.streams = s.streams[:0]
+	clear(s.index)
+	s.batched, s.bytes = 0, 0
+
+	return nil
+}
+
+// StatusError is returned for requests rejected by Loki.
+type StatusError = httpsink.StatusError
+
+// post sends body, retrying failed requests
+func (s *Sink) post(body []byte) error {
+	err := s.cfg.Retry(func() error {
+		return s.postOnce(body)
+	})
+	if err != nil {
+		return fmt.Errorf("loki: %w", err)
+	}
+	return nil
+}
+
+func (s *Sink) postOnce(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if s.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.cfg.TenantID)
+	}
+	if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	_, err = httpsink.Do(s.client, req)
+	return err
+}
+
+// Close pushes the batched events.
+func (s *Sink) Close() error {
+	return s.Flush()
+}
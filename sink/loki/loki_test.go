@@ -0,0 +1,181 @@
+package loki
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/internal/httpsink/httpsinktest"
+)
+
+// walk calls fn for every length delimited field of the message m
+func walk(t *testing.T, m []byte, fn func(num protowire.Number, v []byte)) {
+	for len(m) > 0 {
+		num, typ, n := protowire.ConsumeTag(m)
+		require.Positive(t, n)
+		m = m[n:]
+
+		if typ == protowire.VarintType {
+			x, n := protowire.ConsumeVarint(m)
+			require.Positive(t, n)
+			fn(num, protowire.AppendVarint(nil, x))
+			m = m[n:]
+			continue
+		}
+
+		v, n := protowire.ConsumeBytes(m)
+		require.Positive(t, n)
+		fn(num, v)
+		m = m[n:]
+	}
+}
+
+type testEntry struct {
+	time     time.Time
+	line     string
+	metadata map[string]string
+}
+
+func decodePush(t *testing.T, data []byte) map[string][]testEntry {
+	streams := map[string][]testEntry{}
+	walk(t, data, func(_ protowire.Number, st []byte) {
+		var labels string
+		var entries []testEntry
+		walk(t, st, func(num protowire.Number, v []byte) {
+			switch num {
+			case 1:
+				labels = string(v)
+			case 2:
+				e := testEntry{metadata: map[string]string{}}
+				walk(t, v, func(num protowire.Number, v []byte) {
+					switch num {
+					case 1:
+						var sec, nsec uint64
+						walk(t, v, func(num protowire.Number, v []byte) {
+							x, _ := protowire.ConsumeVarint(v)
+							if num == 1 {
+								sec = x
+							} else {
+								nsec = x
+							}
+						})
+						e.time = time.Unix(int64(sec), int64(nsec))
+					case 2:
+						e.line = string(v)
+					case 3:
+						var name, value string
+						walk(t, v, func(num protowire.Number, v []byte) {
+							if num == 1 {
+								name = string(v)
+							} else {
+								value = string(v)
+							}
+						})
+						e.metadata[name] = value
+					}
+				})
+				entries = append(entries, e)
+			}
+		})
+		streams[labels] = append(streams[labels], entries...)
+	})
+	return streams
+}
+
+func TestSink(t *testing.T) {
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(
+		httpsinktest.Response{Status: http.StatusTooManyRequests},
+		httpsinktest.Response{Status: http.StatusNoContent},
+	))
+
+	s, err := New(Config{
+		URL:                srv.URL,
+		TenantID:           "tenant",
+		Labels:             []string{"sourcetype", "host", "k8s.pod"},
+		StructuredMetadata: true,
+		Batching:           httpsinktest.Batching,
+	})
+	require.NoError(t, err)
+
+	fields := []splunker.Field{{Name: "k8s.pod", Value: "api-1"}, {Name: "trace", Value: "abc"}}
+	for _, info := range []splunker.EventInfo{
+		{Time: time.Unix(1700000002, 0), Host: "web01", SourceType: "app", Raw: []byte("third"), IndexedFields: fields},
+		{Time: time.Unix(1700000001, 5000), Host: "web01", SourceType: "app", Raw: []byte("second"), IndexedFields: fields},
+		{Time: time.Unix(1700000000, 0), Host: "web02", SourceType: `a"b`, Raw: []byte("first")},
+	} {
+		require.NoError(t, s.WriteEvent(splunker.NewEvent(info)))
+	}
+	require.NoError(t, s.Close())
+
+	requests := srv.Requests()
+	require.Len(t, requests, 2)
+	for _, r := range requests {
+		assert.Equal(t, pushPath, r.URL.Path)
+		assert.Equal(t, "tenant", r.Header.Get("X-Scope-OrgID"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+	}
+	assert.Equal(t, map[string][]testEntry{
+		`{host="web01", k8s_pod="api-1", sourcetype="app"}`: {
+			{time: time.Unix(1700000001, 5000), line: "second", metadata: map[string]string{"trace": "abc"}},
+			{time: time.Unix(1700000002, 0), line: "third", metadata: map[string]string{"trace": "abc"}},
+		},
+		`{host="web02", sourcetype="a\"b"}`: {
+			{time: time.Unix(1700000000, 0), line: "first", metadata: map[string]string{}},
+		},
+	}, pushed(t, requests[1]))
+}
+
+// pushed returns the streams of a push request
+func pushed(t *testing.T, r httpsinktest.Request) map[string][]testEntry {
+	data, err := snappy.Decode(nil, r.Body)
+	require.NoError(t, err)
+	return decodePush(t, data)
+}
+
+func TestSinkReusedEvent(t *testing.T) {
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(httpsinktest.Response{Status: http.StatusNoContent}))
+
+	s, err := New(Config{URL: srv.URL, Labels: []string{"host"}})
+	require.NoError(t, err)
+
+	// decoders reuse the buffer of the message for the next event
+	buf := make([]byte, 5)
+	for i, line := range []string{"first", "other"} {
+		copy(buf, line)
+		require.NoError(t, s.WriteEvent(splunker.NewEvent(splunker.EventInfo{Time: time.Unix(1700000000+int64(i), 0), Raw: buf})))
+	}
+	require.NoError(t, s.Close())
+	requests := srv.Requests()
+	require.Len(t, requests, 1)
+
+	// events without any label get the fallback labels
+	assert.Equal(t, map[string][]testEntry{
+		FallbackLabels: {
+			{time: time.Unix(1700000000, 0), line: "first", metadata: map[string]string{}},
+			{time: time.Unix(1700000001, 0), line: "other", metadata: map[string]string{}},
+		},
+	}, pushed(t, requests[0]))
+}
+
+func TestSinkRejected(t *testing.T) {
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(httpsinktest.Response{
+		Status: http.StatusBadRequest,
+		Body:   "entry too far behind\n",
+	}))
+
+	s, err := New(Config{URL: srv.URL, Batching: httpsinktest.Batching})
+	require.NoError(t, err)
+	require.NoError(t, s.WriteEvent(splunker.NewEvent(splunker.EventInfo{Raw: []byte("x")})))
+
+	err = s.Close()
+	var se *StatusError
+	require.ErrorAs(t, err, &se)
+	assert.EqualError(t, err, "loki: status 400: entry too far behind")
+	assert.Len(t, srv.Requests(), 1)
+}
@@ -0,0 +1,439 @@
+// Package otlp exports events as OpenTelemetry log records over OTLP/HTTP
+// with protobuf encoding.
+//
+// The _raw of an event becomes the body and _time the timestamp of the
+// record. Host, source, sourcetype and index are resource attributes named
+// like the Splunk HEC receiver of the collector does:
+//
+//	host.name              host
+//	com.splunk.source      source
+//	com.splunk.sourcetype  sourcetype
+//	com.splunk.index       index
+//
+// The indexed fields are attributes of the record, fields with multiple
+// values are arrays.
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/internal/httpsink"
+)
+
+// Batching configures the batch size and the retries of failed requests.
+type Batching = httpsink.Batching
+
+// Config configures the Sink. Only URL is required.
+type Config struct {
+	// URL of the collector, e.g. http://collector:4318. The logs endpoint
+	// /v1/logs is appended if URL has no path.
+	URL string
+	// Headers are added to every request, e.g. for authentication
+	Headers map[string]string
+
+	// Batching limits the number of records per request
+	Batching
+	// BatchBytes is the maximum size of the bodies in a request, defaults to 4 MiB
+	BatchBytes int
+	// Gzip compresses the requests
+	Gzip bool
+
+	// Client sends the requests, defaults to a client with a timeout of 1m
+	Client *http.Client
+}
+
+const (
+	logsPath = "/v1/logs"
+
+	// scopeName is the instrumentation scope of all records
+	scopeName = "github.com/fionera/splunker"
+)
+
+// Resource attribute names
+const (
+	AttrHost       = "host.name"
+	AttrSource     = "com.splunk.source"
+	AttrSourceType = "com.splunk.sourcetype"
+	AttrIndex      = "com.splunk.index"
+)
+
+// resource identifies the resource of a record
+type resource struct {
+	host, source, sourceType, index string
+}
+
+// resourceLogs are the encoded records of a resource
+type resourceLogs struct {
+	resource resource
+	records  []byte
+}
+
+// Sink sends events in batches to an OTLP/HTTP collector.
+type Sink struct {
+	cfg    Config
+	url    string
+	client *http.Client
+
+	// resources holds the batch in the order the resources were seen
+	resources []*resourceLogs
+	index     map[resource]*resourceLogs
+	batched   int
+	bytes     int
+
+	record []byte
+	body   []byte
+	gzBuf  bytes.Buffer
+	gz     *gzip.Writer
+}
+
+var _ splunker.Sink = (*Sink)(nil)
+
+// New creates a Sink sending to the collector at cfg.URL.
+func New(cfg Config) (*Sink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("otlp: URL is required")
+	}
+
+	cfg.SetDefaults()
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = 4 << 20
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("otlp: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = logsPath
+	}
+
+	s := &Sink{
+		cfg:    cfg,
+		url:    u.String(),
+		client: cfg.Client,
+		index:  make(map[resource]*resourceLogs),
+	}
+	if s.client == nil {
+		s.client = &http.Client{Timeout: time.Minute}
+	}
+	if cfg.Gzip {
+		s.gz = gzip.NewWriter(&s.gzBuf)
+	}
+
+	return s, nil
+}
+
+func (s *Sink) WriteEvent(e splunker.Event) error {
+	res := resource{
+		host:       e.Host(),
+		source:     e.Source(),
+		sourceType: e.SourceType(),
+		index:      e.Bucket().Index,
+	}
+
+	s.record = appendLogRecord(s.record[:0], e)
+	if s.batched > 0 && s.bytes+len(s.record) > s.cfg.BatchBytes {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+	}
+
+	rl, ok := s.index[res]
+	if !ok {
+		rl = &resourceLogs{resource: res}
+		s.index[res] = rl
+		s.resources = append(s.resources, rl)
+	}
+	// field 2 of ScopeLogs
+	rl.records = appendMessage(rl.records, 2, s.record)
+
+	s.batched++
+	s.bytes += len(s.record)
+	if s.batched >= s.cfg.BatchSize {
+		return s.Flush()
+	}
+
+	return nil
+}
+
+// appendLogRecord appends the LogRecord message of e to b
+func appendLogRecord(b []byte, e splunker.Event) []byte {
+	b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, uint64(e.Time().UnixNano()))
+
+	// body
+	var body []byte
+	if raw := e.Message(); utf8.Valid(raw) {
+		body = appendStringValue(body, e.MessageString())
+	} else {
+		body = protowire.AppendTag(body, 7, protowire.BytesType)
+		body = protowire.AppendBytes(body, raw)
+	}
+	b = appendMessage(b, 5, body)
+
+	fields := e.IndexedFields()
+	for i, f := range fields {
+		if seenBefore(fields[:i], f.Name) {
+			continue
+		}
+
+		var values []string
+		for _, other := range fields[i:] {
+			if other.Name == f.Name {
+				values = append(values, other.Value)
+			}
+		}
+		b = appendAttribute(b, 6, f.Name, values...)
+	}
+
+	return b
+}
+
+func seenBefore(fields []splunker.Field, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// appendAttribute appends a KeyValue as field num. Multiple values are an array.
+func appendAttribute(b []byte, num protowire.Number, key string, values ...string) []byte {
+	var value []byte
+	if len(values) == 1 {
+		value = appendStringValue(value, values[0])
+	} else {
+		var array []byte
+		for _, v := range values {
+			array = appendMessage(array, 1, appendStringValue(nil, v))
+		}
+		value = appendMessage(value, 5, array)
+	}
+
+	var kv []byte
+	kv = protowire.AppendTag(kv, 1, protowire.BytesType)
+	kv = protowire.AppendString(kv, key)
+	kv = appendMessage(kv, 2, value)
+
+	return appendMessage(b, num, kv)
+}
+
+// appendStringValue appends the string_value field of an AnyValue
+func appendStringValue(b []byte, s string) []byte {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "�")
+	}
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// appendRequest appends the ExportLogsServiceRequest of the batch to b
+func (s *Sink) appendRequest(b []byte) []byte {
+	var scope []byte
+	scope = protowire.AppendTag(scope, 1, protowire.BytesType)
+	scope = protowire.AppendString(scope, scopeName)
+
+	for _, rl := range s.resources {
+		var res []byte
+		for _, attr := range [...]struct{ key, value string }{
+			{AttrHost, rl.resource.host},
+			{AttrSource, rl.resource.source},
+			{AttrSourceType, rl.resource.sourceType},
+			{AttrIndex, rl.resource.index},
+		} {
+			if attr.value != "" {
+				res = appendAttribute(res, 1, attr.key, attr.value)
+			}
+		}
+
+		scopeLogs := appendMessage(nil, 1, scope)
+		scopeLogs = append(scopeLogs, rl.records...)
+
+		var resourceLogs []byte
+		resourceLogs = appendMessage(resourceLogs, 1, res)
+		resourceLogs = appendMessage(resourceLogs, 2, scopeLogs)
+
+		b = appendMessage(b, 1, resourceLogs)
+	}
+
+	return b
+}
+
+// Flush sends the batched events.
+func (s *Sink) Flush() error {
+	if s.batched == 0 {
+		return nil
+	}
+
+	s.body = s.appendRequest(s.body[:0])
+	body := s.body
+	if s.gz != nil {
+		s.gzBuf.Reset()
+		s.gz.Reset(&s.gzBuf)
+		_, _ = s.gz.Write(body)
+		if err := s.gz.Close(); err != nil {
+			return fmt.Errorf("otlp: %w", err)
+		}
+		body = s.gzBuf.Bytes()
+	}
+
+	err := s.post(body)
+	// the other records were accepted
+	var pe *PartialSuccessError
+	if err != nil && !errors.As(err, &pe) {
+		return err
+	}
+
+	s.resources = s.resources[:0]
+	clear(s.index)
+	s.batched, s.bytes = 0, 0
+
+	return err
+}
+
+// StatusError is returned for requests rejected by the collector.
+type StatusError = httpsink.StatusError
+
+// retryableStatus reports whether a request failed with code may succeed
+// when sent again, following the OTLP/HTTP specification
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// PartialSuccessError is returned if the collector rejected some records.
+type PartialSuccessError struct {
+	Rejected int64
+	Message  string
+}
+
+func (e *PartialSuccessError) Error() string {
+	return fmt.Sprintf("%d log records rejected: %s", e.Rejected, e.Message)
+}
+
+// post sends body, retrying failed requests
+func (s *Sink) post(body []byte) error {
+	err := s.cfg.Retry(func() error {
+		return s.postOnce(body)
+	})
+	if err != nil {
+		return fmt.Errorf("otlp: %w", err)
+	}
+	return nil
+}
+
+func (s *Sink) postOnce(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if s.gz != nil {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	data, err := httpsink.Do(s.client, req)
+	if se := (*StatusError)(nil); errors.As(err, &se) {
+		se.Message = statusMessage(se.Header.Get("Content-Type"), data)
+		if !retryableStatus(se.StatusCode) {
+			return httpsink.Permanent(err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// the collector accepted the other records, sending them again
+	// would duplicate them
+	if rejected, msg := partialSuccess(data); rejected > 0 {
+		return httpsink.Permanent(&PartialSuccessError{Rejected: rejected, Message: msg})
+	}
+
+	return nil
+}
+
+// statusMessage returns the message of a google.rpc.Status or the body
+// itself if it is not protobuf encoded
+func statusMessage(contentType string, data []byte) string {
+	if contentType != "application/x-protobuf" {
+		return strings.TrimSpace(string(data))
+	}
+
+	var msg string
+	walk(data, func(num protowire.Number, typ protowire.Type, v []byte) {
+		if num == 2 && typ == protowire.BytesType {
+			msg = string(v)
+		}
+	})
+	return msg
+}
+
+// partialSuccess decodes the partial_success of an ExportLogsServiceResponse
+func partialSuccess(data []byte) (rejected int64, msg string) {
+	walk(data, func(num protowire.Number, typ protowire.Type, v []byte) {
+		if num != 1 || typ != protowire.BytesType {
+			return
+		}
+		walk(v, func(num protowire.Number, typ protowire.Type, v []byte) {
+			switch {
+			case num == 1 && typ == protowire.VarintType:
+				x, _ := protowire.ConsumeVarint(v)
+				rejected = int64(x)
+			case num == 2 && typ == protowire.BytesType:
+				msg = string(v)
+			}
+		})
+	})
+	return rejected, msg
+}
+
+// walk calls fn for every field of the message m. The value of length
+// delimited fields is passed without the length.
+func walk(m []byte, fn func(num protowire.Number, typ protowire.Type, v []byte)) {
+	for len(m) > 0 {
+		num, typ, n := protowire.ConsumeTag(m)
+		if n < 0 {
+			return
+		}
+		m = m[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, m)
+		if n < 0 {
+			return
+		}
+		v := m[:n]
+		if typ == protowire.BytesType {
+			v, _ = protowire.ConsumeBytes(v)
+		}
+		fn(num, typ, v)
+		m = m[n:]
+	}
+}
+
+// Close sends the batched events.
+func (s *Sink) Close() error {
+	return s.Flush()
+}
@@ -0,0 +1,211 @@
+package otlp
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/fionera/splunker"
+	"github.com/fionera/splunker/sink/internal/httpsink/httpsinktest"
+)
+
+// record is the decoded form of a LogRecord in a test request
+type record struct {
+	resource map[string]any
+	time     uint64
+	body     any
+	attrs    map[string]any
+}
+
+func decodeAnyValue(v []byte) any {
+	var res any
+	walk(v, func(num protowire.Number, typ protowire.Type, v []byte) {
+		switch num {
+		case 1:
+			res = string(v)
+		case 5:
+			var values []any
+			walk(v, func(_ protowire.Number, _ protowire.Type, v []byte) {
+				values = append(values, decodeAnyValue(v))
+			})
+			res = values
+		case 7:
+			res = append([]byte(nil), v...)
+		}
+	})
+	return res
+}
+
+func decodeKeyValue(v []byte, attrs map[string]any) {
+	var key string
+	var value any
+	walk(v, func(num protowire.Number, typ protowire.Type, v []byte) {
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			value = decodeAnyValue(v)
+		}
+	})
+	attrs[key] = value
+}
+
+func decodeRequest(data []byte) []record {
+	var records []record
+	walk(data, func(_ protowire.Number, _ protowire.Type, rl []byte) {
+		resource := map[string]any{}
+		walk(rl, func(num protowire.Number, _ protowire.Type, v []byte) {
+			switch num {
+			case 1:
+				walk(v, func(_ protowire.Number, _ protowire.Type, kv []byte) {
+					decodeKeyValue(kv, resource)
+				})
+			case 2:
+				walk(v, func(num protowire.Number, _ protowire.Type, lr []byte) {
+					if num != 2 {
+						return
+					}
+					r := record{resource: resource, attrs: map[string]any{}}
+					walk(lr, func(num protowire.Number, _ protowire.Type, v []byte) {
+						switch num {
+						case 1:
+							r.time, _ = protowire.ConsumeFixed64(v)
+						case 5:
+							r.body = decodeAnyValue(v)
+						case 6:
+							decodeKeyValue(v, r.attrs)
+						}
+					})
+					records = append(records, r)
+				})
+			}
+		})
+	})
+	return records
+}
+
+func TestSink(t *testing.T) {
+	// the first request is throttled
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(
+		httpsinktest.Response{Status: http.StatusServiceUnavailable},
+		httpsinktest.Response{},
+	))
+
+	batching := httpsinktest.Batching
+	batching.BatchSize = 2
+	s, err := New(Config{
+		URL:      srv.URL,
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+		Batching: batching,
+		Gzip:     true,
+	})
+	require.NoError(t, err)
+
+	bucket := &splunker.Bucket{Index: "web"}
+	for _, info := range []splunker.EventInfo{
+		{
+			Time: time.Unix(1700000000, 250000000), Host: "web01", Source: "/var/log/app.log", SourceType: "app",
+			Raw: []byte("first"), Bucket: bucket,
+			IndexedFields: []splunker.Field{{Name: "env", Value: "prod"}, {Name: "env", Value: "eu"}, {Name: "team", Value: "ops"}},
+		},
+		{Time: time.Unix(1700000001, 0), Host: "web02", Source: "/var/log/app.log", SourceType: "app", Raw: []byte("second"), Bucket: bucket},
+		{Time: time.Unix(1700000002, 0), Host: "web01", Raw: []byte{0xff, 'x'}},
+	} {
+		require.NoError(t, s.WriteEvent(splunker.NewEvent(info)))
+	}
+	require.NoError(t, s.Close())
+
+	requests := srv.Requests()
+	require.Len(t, requests, 3)
+	var records []record
+	for _, r := range requests {
+		assert.Equal(t, logsPath, r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+	}
+	for _, r := range requests[1:] {
+		records = append(records, decodeRequest(r.Body)...)
+	}
+	assert.Equal(t, []record{
+		{
+			resource: map[string]any{AttrHost: "web01", AttrSource: "/var/log/app.log", AttrSourceType: "app", AttrIndex: "web"},
+			time:     1700000000250000000,
+			body:     "first",
+			attrs:    map[string]any{"env": []any{"prod", "eu"}, "team": "ops"},
+		},
+		{
+			resource: map[string]any{AttrHost: "web02", AttrSource: "/var/log/app.log", AttrSourceType: "app", AttrIndex: "web"},
+			time:     1700000001000000000,
+			body:     "second",
+			attrs:    map[string]any{},
+		},
+		{
+			resource: map[string]any{AttrHost: "web01"},
+			time:     1700000002000000000,
+			body:     []byte{0xff, 'x'},
+			attrs:    map[string]any{},
+		},
+	}, records)
+}
+
+func TestSinkPartialSuccess(t *testing.T) {
+	var ps []byte
+	ps = protowire.AppendTag(ps, 1, protowire.VarintType)
+	ps = protowire.AppendVarint(ps, 1)
+	ps = protowire.AppendTag(ps, 2, protowire.BytesType)
+	ps = protowire.AppendString(ps, "too old")
+	srv := httpsinktest.NewServer(t, httpsinktest.Sequence(
+		httpsinktest.Response{Body: string(appendMessage(nil, 1, ps))},
+		httpsinktest.Response{},
+	))
+
+	s, err := New(Config{URL: srv.URL, Batching: httpsinktest.Batching})
+	require.NoError(t, err)
+	require.NoError(t, s.WriteEvent(splunker.NewEvent(splunker.EventInfo{Raw: []byte("x")})))
+	require.NoError(t, s.WriteEvent(splunker.NewEvent(splunker.EventInfo{Raw: []byte("y")})))
+
+	var pe *PartialSuccessError
+	require.ErrorAs(t, s.Flush(), &pe)
+	assert.Equal(t, int64(1), pe.Rejected)
+	assert.Equal(t, "too old", pe.Message)
+
+	// the accepted records are not sent again
+	require.NoError(t, s.WriteEvent(splunker.NewEvent(splunker.EventInfo{Raw: []byte("z")})))
+	require.NoError(t, s.Close())
+	requests := srv.Requests()
+	require.Len(t, requests, 2)
+	assert.Len(t, decodeRequest(requests[0].Body), 2)
+	second := decodeRequest(requests[1].Body)
+	require.Len(t, second, 1)
+	assert.Equal(t, "z", second[0].body)
+}
+
+func TestSinkRejected(t *testing.T) {
+	var status []byte
+	status = protowire.AppendTag(status, 2, protowire.BytesType)
+	status = protowire.AppendString(status, "invalid request")
+
+	for _, code := range []int{http.StatusBadRequest, http.StatusInternalServerError} {
+		srv := httpsinktest.NewServer(t, httpsinktest.Sequence(httpsinktest.Response{
+			Status: code,
+			Header: http.Header{"Content-Type": {"application/x-protobuf"}},
+			Body:   string(status),
+		}))
+
+		s, err := New(Config{URL: srv.URL, Batching: httpsinktest.Batching})
+		require.NoError(t, err)
+		require.NoError(t, s.WriteEvent(splunker.NewEvent(splunker.EventInfo{Raw: []byte("x")})))
+
+		// only 429, 502, 503 and 504 are retried
+		var se *StatusError
+		require.ErrorAs(t, s.Close(), &se)
+		assert.Equal(t, code, se.StatusCode)
+		assert.Equal(t, "invalid request", se.Message)
+		assert.Len(t, srv.Requests(), 1)
+	}
+}
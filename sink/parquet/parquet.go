@@ -0,0 +1,221 @@
+// Package parquet writes events as Apache Parquet file with a stable schema:
+//
+//	_time              timestamp in microseconds, UTC
+//	_raw               the event text
+//	host, source, sourcetype, index
+//	bucket             bucket as <index>~<id>[~<guid>], null if unknown
+//	_stream_id         id of the input stream the event was read from
+//	_stream_offset     offset of the chunk within the stream
+//	_stream_suboffset  offset of the event within the chunk
+//	_hash              20 byte hash, null if the journal does not store one
+//	fields             map of the indexed field names to their values
+//
+// The columns are named like the keys of package jsonl. fields maps every
+// name to a list, as a field can have multiple values.
+package parquet
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+
+	"github.com/fionera/splunker"
+)
+
+// columns declares the schema. The writer can not store the []string
+// values of fields as lists, row holds them in the layout of the list.
+type columns struct {
+	Time            int64               `parquet:"_time,timestamp(microsecond)"`
+	Raw             string              `parquet:"_raw"`
+	Host            string              `parquet:"host,dict"`
+	Source          string              `parquet:"source,dict"`
+	SourceType      string              `parquet:"sourcetype,dict"`
+	Index           string              `parquet:"index,dict"`
+	Bucket          *string             `parquet:"bucket,optional,dict"`
+	StreamID        uint64              `parquet:"_stream_id"`
+	StreamOffset    uint64              `parquet:"_stream_offset"`
+	StreamSubOffset uint64              `parquet:"_stream_suboffset"`
+	Hash            *[20]byte           `parquet:"_hash,optional"`
+	Fields          map[string][]string `parquet:"fields" parquet-value:",list"`
+}
+
+var schema = parquet.NewSchema("splunk_event", parquet.SchemaOf(columns{}))
+
+// row is a single event
+type row struct {
+	Time            int64           `parquet:"_time"`
+	Raw             string          `parquet:"_raw"`
+	Host            string          `parquet:"host"`
+	Source          string          `parquet:"source"`
+	SourceType      string          `parquet:"sourcetype"`
+	Index           string          `parquet:"index"`
+	Bucket          *string         `parquet:"bucket"`
+	StreamID        uint64          `parquet:"_stream_id"`
+	StreamOffset    uint64          `parquet:"_stream_offset"`
+	StreamSubOffset uint64          `parquet:"_stream_suboffset"`
+	Hash            *[20]byte       `parquet:"_hash"`
+	Fields          map[string]list `parquet:"fields"`
+}
+
+// list is a LIST of strings
+type list struct {
+	List []element `parquet:"list"`
+}
+
+type element struct {
+	Element string `parquet:"element"`
+}
+
+// Compression is the codec of the column chunks.
+type Compression int
+
+const (
+	Zstd Compression = iota
+	Snappy
+	Gzip
+	Uncompressed
+)
+
+var codecs = map[Compression]compress.Codec{
+	Zstd:         &parquet.Zstd,
+	Snappy:       &parquet.Snappy,
+	Gzip:         &parquet.Gzip,
+	Uncompressed: &parquet.Uncompressed,
+}
+
+// ParseCompression returns the Compression named zstd, snappy, gzip or none.
+func ParseCompression(name string) (Compression, error) {
+	switch name {
+	case "zstd":
+		return Zstd, nil
+	case "snappy":
+		return Snappy, nil
+	case "gzip":
+		return Gzip, nil
+	case "none":
+		return Uncompressed, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q", name)
+}
+
+// Config configures the Writer. The zero value is usable.
+type Config struct {
+	// RowGroupSize is the approximate uncompressed size of a row group,
+	// defaults to 128 MiB
+	RowGroupSize int64
+	// PageSize is the approximate uncompressed size of a page, defaults to 1 MiB
+	PageSize int64
+	// Compression of the column chunks, defaults to Zstd
+	Compression Compression
+}
+
+// Writer writes events as Parquet file.
+type Writer struct {
+	pw           *parquet.GenericWriter[row]
+	rowGroupSize int64
+	// size is the approximate uncompressed size of the row group
+	size int64
+	// rows are passed to pw in batches
+	rows []row
+}
+
+const batchSize = 1024
+
+var _ splunker.Sink = (*Writer)(nil)
+
+func NewWriter(w io.Writer, cfg Config) (*Writer, error) {
+	if cfg.RowGroupSize <= 0 {
+		cfg.RowGroupSize = 128 << 20
+	}
+	if cfg.PageSize <= 0 {
+		cfg.PageSize = 1 << 20
+	}
+	codec, ok := codecs[cfg.Compression]
+	if !ok {
+		return nil, fmt.Errorf("parquet: unknown compression %d", cfg.Compression)
+	}
+
+	pw := parquet.NewGenericWriter[row](w, schema,
+		parquet.PageBufferSize(int(cfg.PageSize)),
+		parquet.Compression(codec),
+	)
+
+	return &Writer{pw: pw, rowGroupSize: cfg.RowGroupSize, rows: make([]row, 0, batchSize)}, nil
+}
+
+func (w *Writer) WriteEvent(e splunker.Event) error {
+	bucket := e.Bucket()
+	r := row{
+		Time: e.Time().UnixMicro(),
+		// the message is only valid until the next event
+		Raw:             string(e.Message()),
+		Host:            e.Host(),
+		Source:          e.Source(),
+		SourceType:      e.SourceType(),
+		Index:           bucket.Index,
+		StreamID:        e.StreamID(),
+		StreamOffset:    e.StreamOffset(),
+		StreamSubOffset: e.StreamSubOffset(),
+		Fields:          make(map[string]list),
+	}
+	size := int64(len(r.Raw)+len(r.Host)+len(r.Source)+len(r.SourceType)+len(r.Index)) + 32
+	if bucket.Path != "" {
+		id := bucket.SplunkID()
+		r.Bucket = &id
+		size += int64(len(id))
+	}
+	if hash, ok := e.Hash(); ok {
+		r.Hash = &hash
+		size += int64(len(hash))
+	}
+	for _, f := range e.IndexedFields() {
+		l := r.Fields[f.Name]
+		l.List = append(l.List, element{f.Value})
+		r.Fields[f.Name] = l
+		size += int64(len(f.Name) + len(f.Value))
+	}
+
+	w.rows = append(w.rows, r)
+	w.size += size
+	if len(w.rows) < batchSize && w.size < w.rowGroupSize {
+		return nil
+	}
+	if err := w.writeRows(); err != nil {
+		return err
+	}
+
+	// Flush ends the row group
+	if w.size >= w.rowGroupSize {
+		w.size = 0
+		if err := w.pw.Flush(); err != nil {
+			return fmt.Errorf("parquet: %w", err)
+		}
+	}
+	return nil
+}
+
+func (w *Writer) writeRows() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+	if _, err := w.pw.Write(w.rows); err != nil {
+		return fmt.Errorf("parquet: %w", err)
+	}
+	clear(w.rows)
+	w.rows = w.rows[:0]
+	return nil
+}
+
+// Close writes the buffered rows and the footer. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if err := w.writeRows(); err != nil {
+		return err
+	}
+	if err := w.pw.Close(); err != nil {
+		return fmt.Errorf("parquet: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package parquet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+func TestWriter(t *testing.T) {
+	var hash [20]byte
+	copy(hash[:], "0123456789abcdefghij")
+	bucket := &splunker.Bucket{Index: "web", ID: 7, Path: "/opt/splunk/web/db/db_1_1_7"}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Config{RowGroupSize: 1, PageSize: 1})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		info := splunker.EventInfo{
+			Time:         time.Unix(1700000000+int64(i), 250000000),
+			Host:         "web01",
+			Source:       "/var/log/access.log",
+			SourceType:   "access_combined",
+			Raw:          []byte("GET /index.html 200"),
+			Bucket:       bucket,
+			StreamID:     42,
+			StreamOffset: uint64(i) * 20,
+			IndexedFields: []splunker.Field{
+				{Name: "status", Value: "200"},
+				{Name: "tag", Value: "a"},
+				{Name: "tag", Value: "b"},
+			},
+		}
+		if i == 0 {
+			info.Hash, info.HasHash = hash, true
+		}
+		require.NoError(t, w.WriteEvent(splunker.NewEvent(info)))
+	}
+	require.NoError(t, w.Close())
+
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	require.EqualValues(t, 3, f.NumRows())
+	assert.Greater(t, len(f.RowGroups()), 1)
+	assert.Equal(t, format.Zstd, f.Metadata().RowGroups[0].Columns[0].MetaData.Codec)
+
+	var columns []string
+	for _, path := range f.Schema().Columns() {
+		columns = append(columns, path[len(path)-1])
+	}
+	assert.Equal(t, []string{"_time", "_raw", "host", "source", "sourcetype", "index", "bucket",
+		"_stream_id", "_stream_offset", "_stream_suboffset", "_hash", "key", "element"}, columns)
+
+	rows := readRows(t, buf.Bytes())
+	require.Len(t, rows, 3)
+
+	assert.Equal(t, time.Unix(1700000000, 250000000).UnixMicro(), rows[0].Time)
+	assert.Equal(t, "GET /index.html 200", rows[0].Raw)
+	assert.Equal(t, "access_combined", rows[0].SourceType)
+	assert.Equal(t, "web", rows[0].Index)
+	require.NotNil(t, rows[0].Bucket)
+	assert.Equal(t, "web~7", *rows[0].Bucket)
+	require.NotNil(t, rows[0].Hash)
+	assert.Equal(t, hash, *rows[0].Hash)
+	assert.Nil(t, rows[1].Hash)
+	assert.EqualValues(t, 40, rows[2].StreamOffset)
+	assert.Equal(t, map[string]list{
+		"status": {[]element{{"200"}}},
+		"tag":    {[]element{{"a"}, {"b"}}},
+	}, rows[2].Fields)
+}
+
+func readRows(t *testing.T, b []byte) []row {
+	t.Helper()
+
+	rows, err := parquet.Read[row](bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	return rows
+}
+
+func TestWriterReusedEvent(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Config{})
+	require.NoError(t, err)
+
+	// the decoder reuses the buffer of the message
+	raw := []byte("first")
+	for _, msg := range []string{"first", "other"} {
+		copy(raw, msg)
+		require.NoError(t, w.WriteEvent(splunker.NewEvent(splunker.EventInfo{Time: time.Unix(1700000000, 0), Raw: raw})))
+	}
+	require.NoError(t, w.Close())
+
+	rows := readRows(t, buf.Bytes())
+	require.Len(t, rows, 2)
+	assert.Equal(t, "first", rows[0].Raw)
+	assert.Equal(t, "other", rows[1].Raw)
+}
+
+func TestParseCompression(t *testing.T) {
+	c, err := ParseCompression("none")
+	require.NoError(t, err)
+	assert.Equal(t, Uncompressed, c)
+
+	_, err = ParseCompression("lzo")
+	assert.Error(t, err)
+}
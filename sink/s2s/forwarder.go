@@ -0,0 +1,89 @@
+package s2s
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"time"
+
+	"github.com/fionera/splunker"
+)
+
+// Config configures the connection of a Forwarder.
+type Config struct {
+	// ServerName is sent in the handshake, defaults to the hostname
+	ServerName string
+	// MgmtPort is sent in the handshake, defaults to 8089
+	MgmtPort string
+	// Index overrides the index of the events if set
+	Index string
+	// TLSConfig enables TLS for the connection
+	TLSConfig *tls.Config
+	// DialTimeout defaults to 30s
+	DialTimeout time.Duration
+}
+
+// Forwarder sends events to a receiving Splunk instance.
+type Forwarder struct {
+	conn  net.Conn
+	w     *Writer
+	index string
+}
+
+var _ splunker.Sink = (*Forwarder)(nil)
+
+// Dial connects to the receiver at addr, usually on port 9997.
+func Dial(addr string, cfg Config) (*Forwarder, error) {
+	if cfg.ServerName == "" {
+		cfg.ServerName, _ = os.Hostname()
+	}
+	if cfg.MgmtPort == "" {
+		cfg.MgmtPort = "8089"
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 30 * time.Second
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if cfg.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, cfg.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := NewForwarder(conn, Handshake{ServerName: cfg.ServerName, MgmtPort: cfg.MgmtPort})
+	f.index = cfg.Index
+	return f, nil
+}
+
+// NewForwarder sends events over an established connection. Close closes conn.
+func NewForwarder(conn net.Conn, h Handshake) *Forwarder {
+	return &Forwarder{
+		conn: conn,
+		w:    NewWriter(conn, h),
+	}
+}
+
+func (f *Forwarder) WriteEvent(e splunker.Event) error {
+	frame := EventFrame(e, f.index)
+	return f.w.WriteFrame(append(frame, KeyValue{KeyLineBreaker, KeyLineBreaker}))
+}
+
+// Close marks the end of the stream, flushes the buffered events and
+// closes the connection.
+func (f *Forwarder) Close() error {
+	err := f.w.WriteFrame(Frame{{KeyDone, KeyDone}})
+	if err == nil {
+		err = f.w.Flush()
+	}
+	if cerr := f.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,429 @@
+package s2s
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/fionera/splunker"
+)
+
+// ReceiverOption configures a Receiver.
+type ReceiverOption func(r *Receiver)
+
+// WithDefaultIndex sets the index of events that do not name a valid
+// index. Defaults to main.
+func WithDefaultIndex(name string) ReceiverOption {
+	return func(r *Receiver) {
+		r.defaultIndex = name
+	}
+}
+
+// WithIndexOptions passes opts to the IndexWriter of every index.
+func WithIndexOptions(opts ...splunker.IndexWriterOption) ReceiverOption {
+	return func(r *Receiver) {
+		r.indexOpts = append(r.indexOpts, opts...)
+	}
+}
+
+// WithCheckInterval sets how often hot buckets older than the maximum
+// bucket age are rolled and the stream offsets are saved. Defaults to 10s.
+func WithCheckInterval(d time.Duration) ReceiverOption {
+	return func(r *Receiver) {
+		r.interval = d
+	}
+}
+
+// streamsFile stores the stream offsets in the directory of the receiver
+const streamsFile = "s2s_streams.json"
+
+// validIndex matches the index names accepted by Splunk
+var validIndex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_-]*$`)
+
+// Receiver accepts forwarder connections and writes the received events
+// into one index directory per index below its directory, like $SPLUNK_DB.
+//
+// Every host and source is a stream of its own. The stream offsets count
+// the received bytes, with a newline after every event, so the sources can
+// be restored from the buckets. They are saved in s2s_streams.json and
+// continue after a restart.
+//
+// Frames with _linebreaker but without _time are unparsed chunks of a
+// source, as sent by universal forwarders. They are broken into one event
+// per line. Events without _time get the time they were received.
+type Receiver struct {
+	dir          string
+	defaultIndex string
+	indexOpts    []splunker.IndexWriterOption
+	interval     time.Duration
+
+	mu        sync.Mutex
+	closed    bool
+	done      chan struct{}
+	indexes   map[string]*splunker.IndexWriter
+	streams   map[uint64]uint64
+	dirty     bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewReceiver creates a Receiver writing into dir. The stream offsets of
+// a previous Receiver in dir are loaded.
+func NewReceiver(dir string, opts ...ReceiverOption) (*Receiver, error) {
+	r := &Receiver{
+		dir:          dir,
+		defaultIndex: "main",
+		interval:     10 * time.Second,
+		done:         make(chan struct{}),
+		indexes:      make(map[string]*splunker.IndexWriter),
+		streams:      make(map[uint64]uint64),
+		listeners:    make(map[net.Listener]struct{}),
+		conns:        make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, streamsFile))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &r.streams); err != nil {
+			return nil, fmt.Errorf("s2s: %s: %w", streamsFile, err)
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("s2s: %w", err)
+	}
+
+	r.wg.Add(1)
+	go r.maintain()
+
+	return r, nil
+}
+
+// maintain rolls expired hot buckets and saves the stream offsets
+// every interval until Close
+func (r *Receiver) maintain() {
+	defer r.wg.Done()
+
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-t.C:
+		}
+
+		r.mu.Lock()
+		for name, w := range r.indexes {
+			if err := w.RollExpired(); err != nil {
+				log.Printf("s2s: index %s: %v", name, err)
+			}
+		}
+		if err := r.saveStreams(); err != nil {
+			log.Printf("s2s: %v", err)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// saveStreams writes the stream offsets if they changed. r.mu has to be held.
+func (r *Receiver) saveStreams() error {
+	if !r.dirty {
+		return nil
+	}
+
+	b, err := json.Marshal(r.streams)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+
+	// replaced atomically to keep the old offsets if writing fails
+	p := filepath.Join(r.dir, streamsFile)
+	if err := os.WriteFile(p+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(p+".tmp", p); err != nil {
+		return err
+	}
+
+	r.dirty = false
+	return nil
+}
+
+// ErrReceiverClosed is returned by Serve after Close.
+var ErrReceiverClosed = errors.New("s2s: receiver closed")
+
+// ListenAndServe listens on addr, with TLS if tlsConfig is not nil, and
+// serves the connections.
+func (r *Receiver) ListenAndServe(addr string, tlsConfig *tls.Config) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	return r.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called. l is closed on return.
+func (r *Receiver) Serve(l net.Listener) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		_ = l.Close()
+		return ErrReceiverClosed
+	}
+	r.listeners[l] = struct{}{}
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.listeners, l)
+		r.mu.Unlock()
+		_ = l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			r.mu.Lock()
+			closed := r.closed
+			r.mu.Unlock()
+			if closed {
+				return ErrReceiverClosed
+			}
+			return err
+		}
+
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			_ = conn.Close()
+			return ErrReceiverClosed
+		}
+		r.conns[conn] = struct{}{}
+		r.wg.Add(1)
+		r.mu.Unlock()
+
+		go func() {
+			defer r.wg.Done()
+			err := r.handle(conn)
+
+			r.mu.Lock()
+			delete(r.conns, conn)
+			// connections are expected to fail after Close
+			if err != nil && !r.closed {
+				log.Printf("s2s: %s: %v", conn.RemoteAddr(), err)
+			}
+			r.mu.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+func (r *Receiver) handle(conn net.Conn) error {
+	rd := NewReader(conn)
+	if _, err := rd.ReadHandshake(); err != nil {
+		return err
+	}
+
+	// partial holds the incomplete last line of the unparsed streams
+	partial := make(map[uint64]*partialLine)
+
+	for {
+		f, err := rd.ReadFrame()
+		if err != nil {
+			if err == io.EOF {
+				return r.flushLines(partial)
+			}
+			return err
+		}
+
+		if f.Done() {
+			if err := r.flushLines(partial); err != nil {
+				return err
+			}
+		}
+
+		// frames without _raw only carry control information
+		if _, ok := f.Get(KeyRaw); !ok {
+			continue
+		}
+
+		info, err := f.EventInfo()
+		if err != nil {
+			return err
+		}
+		info.StreamID = streamID(info.Host, info.Source)
+
+		if _, ok := f.Get(KeyTime); !ok {
+			info.Time = time.Now()
+			if _, ok := f.Get(KeyLineBreaker); ok {
+				if err := r.writeLines(f.Index(), info, partial); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if err := r.write(f.Index(), info, uint64(len(info.Raw))+1); err != nil {
+			return err
+		}
+	}
+}
+
+// partialLine is the end of an unparsed chunk without line break
+type partialLine struct {
+	index string
+	info  splunker.EventInfo
+}
+
+// writeLines breaks the unparsed chunk in info.Raw into lines and writes
+// them as events. The last line is kept in partial until the next chunk
+// of the stream completes it.
+func (r *Receiver) writeLines(index string, info splunker.EventInfo, partial map[uint64]*partialLine) error {
+	data := info.Raw
+	if p, ok := partial[info.StreamID]; ok {
+		data = append(p.info.Raw, data...)
+		delete(partial, info.StreamID)
+	}
+
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := info
+		line.Raw = bytes.TrimSuffix(data[:i], []byte{'\r'})
+		if len(line.Raw) == 0 {
+			r.skip(info.StreamID, uint64(i)+1)
+		} else if err := r.write(index, line, uint64(i)+1); err != nil {
+			return err
+		}
+		data = data[i+1:]
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+	info.Raw = data
+	// a line can not grow beyond the size of a frame
+	if len(data) >= maxFrameSize {
+		return r.write(index, info, uint64(len(data)))
+	}
+	partial[info.StreamID] = &partialLine{index: index, info: info}
+	return nil
+}
+
+// flushLines writes the partial lines at the end of their streams
+func (r *Receiver) flushLines(partial map[uint64]*partialLine) error {
+	for id, p := range partial {
+		delete(partial, id)
+		if err := r.write(p.index, p.info, uint64(len(p.info.Raw))+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamID returns the id of the stream of host and source
+func streamID(host, source string) uint64 {
+	h := fnv.New64a()
+	_, _ = io.WriteString(h, host)
+	_, _ = h.Write([]byte{0})
+	_, _ = io.WriteString(h, source)
+	return h.Sum64()
+}
+
+// skip advances the offset of the stream id by size without an event
+func (r *Receiver) skip(id, size uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.streams[id] += size
+	r.dirty = true
+}
+
+// write writes the event into its index. It takes size bytes of its stream.
+func (r *Receiver) write(index string, info splunker.EventInfo, size uint64) error {
+	if !validIndex.MatchString(index) {
+		index = r.defaultIndex
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return ErrReceiverClosed
+	}
+
+	info.StreamOffset = r.streams[info.StreamID]
+	r.streams[info.StreamID] += size
+	r.dirty = true
+
+	w, ok := r.indexes[index]
+	if !ok {
+		var err error
+		if w, err = splunker.NewIndexWriter(filepath.Join(r.dir, index), r.indexOpts...); err != nil {
+			return fmt.Errorf("index %s: %w", index, err)
+		}
+		r.indexes[index] = w
+	}
+
+	return w.WriteEvent(splunker.NewEvent(info))
+}
+
+// Close stops all listeners and connections, rolls the hot buckets and
+// saves the stream offsets.
+func (r *Receiver) Close() error {
+	r.mu.Lock()
+	if !r.closed {
+		close(r.done)
+	}
+	r.closed = true
+	for l := range r.listeners {
+		_ = l.Close()
+	}
+	for conn := range r.conns {
+		_ = conn.Close()
+	}
+	r.mu.Unlock()
+
+	r.wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	for name, w := range r.indexes {
+		if err := w.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("index %s: %w", name, err))
+		}
+	}
+	clear(r.indexes)
+
+	if err := r.saveStreams(); err != nil {
+		errs = append(errs, fmt.Errorf("s2s: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,208 @@
+package s2s
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+func TestReceiver(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	r, err := NewReceiver(dir, WithDefaultIndex("fallback"))
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- r.Serve(l) }()
+
+	fwd, err := Dial(l.Addr().String(), Config{ServerName: "fwd01"})
+	require.NoError(t, err)
+	for i, index := range []string{"web", "web", "../etc", ""} {
+		require.NoError(t, fwd.WriteEvent(splunker.NewEvent(splunker.EventInfo{
+			Time:          time.Unix(1700000000+int64(i), 0),
+			Host:          "web01",
+			Source:        "/var/log/app.log",
+			SourceType:    "app",
+			Raw:           []byte("line"),
+			Bucket:        &splunker.Bucket{Index: index},
+			IndexedFields: []splunker.Field{{Name: "n", Value: "v"}},
+		})))
+	}
+	require.NoError(t, fwd.Close())
+
+	// wait until the connection is drained
+	assert.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.indexes) == 2 && len(r.conns) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, r.Close())
+	assert.ErrorIs(t, <-served, ErrReceiverClosed)
+
+	idx, err := splunker.OpenIndex(filepath.Join(dir, "web"))
+	require.NoError(t, err)
+	buckets := idx.Buckets()
+	require.Len(t, buckets, 1)
+	assert.Equal(t, "db_1700000001_1700000000_0", filepath.Base(buckets[0].Path))
+
+	var offsets []uint64
+	for e, err := range idx.All() {
+		require.NoError(t, err)
+		assert.Equal(t, "web01", e.Host())
+		assert.Equal(t, "app", e.SourceType())
+		assert.Equal(t, "line", e.MessageString())
+		assert.Equal(t, []splunker.Field{{Name: "n", Value: "v"}}, e.IndexedFields())
+		offsets = append(offsets, e.StreamOffset())
+	}
+	assert.Equal(t, []uint64{0, 5}, offsets)
+
+	idx, err = splunker.OpenIndex(filepath.Join(dir, "fallback"))
+	require.NoError(t, err)
+	n := 0
+	for _, err := range idx.All() {
+		require.NoError(t, err)
+		n++
+	}
+	assert.Equal(t, 2, n)
+}
+
+// startReceiver serves r on a local listener and returns its address
+func startReceiver(t *testing.T, r *Receiver) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = r.Serve(l) }()
+	return l.Addr().String()
+}
+
+// sendFrames sends frames on a new connection and waits until r handled it
+func sendFrames(t *testing.T, r *Receiver, addr string, frames ...Frame) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	w := NewWriter(conn, Handshake{ServerName: "uf01"})
+	for _, f := range frames {
+		require.NoError(t, w.WriteFrame(f))
+	}
+	require.NoError(t, w.Flush())
+	require.NoError(t, conn.Close())
+
+	assert.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.indexes) > 0 && len(r.conns) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func chunkFrame(raw string) Frame {
+	return Frame{
+		{KeyRaw, raw},
+		{KeyHost, "host::uf01"},
+		{KeySource, "source::/var/log/app.log"},
+		{KeySourceType, "sourcetype::app"},
+		{KeyIndex, "web"},
+		{KeyLineBreaker, KeyLineBreaker},
+	}
+}
+
+type received struct {
+	raw    string
+	offset uint64
+}
+
+func readIndex(t *testing.T, dir string) []received {
+	t.Helper()
+
+	idx, err := splunker.OpenIndex(dir)
+	require.NoError(t, err)
+
+	var events []received
+	for e, err := range idx.All() {
+		require.NoError(t, err)
+		events = append(events, received{string(e.Message()), e.StreamOffset()})
+	}
+	return events
+}
+
+func TestReceiverChunks(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewReceiver(dir)
+	require.NoError(t, err)
+	addr := startReceiver(t, r)
+
+	start := time.Now().Add(-time.Second)
+	sendFrames(t, r, addr,
+		chunkFrame("first line\r\nsecond "),
+		chunkFrame("line\n\nthird"),
+		Frame{{KeyDone, KeyDone}},
+	)
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, []received{
+		{"first line", 0},
+		{"second line", 12},
+		{"third", 25},
+	}, readIndex(t, filepath.Join(dir, "web")))
+
+	idx, err := splunker.OpenIndex(filepath.Join(dir, "web"))
+	require.NoError(t, err)
+	for e, err := range idx.All() {
+		require.NoError(t, err)
+		// chunks have no _time, the events get the time they were received
+		assert.True(t, e.Time().After(start), e.Time())
+	}
+}
+
+func TestReceiverRestart(t *testing.T) {
+	dir := t.TempDir()
+	event := Frame{
+		{KeyRaw, "line"},
+		{KeyTime, "1700000000"},
+		{KeyHost, "host::web01"},
+		{KeySource, "source::/var/log/app.log"},
+		{KeyIndex, "web"},
+	}
+
+	for i := 0; i < 2; i++ {
+		r, err := NewReceiver(dir)
+		require.NoError(t, err)
+		sendFrames(t, r, startReceiver(t, r), event)
+		require.NoError(t, r.Close())
+	}
+
+	// the offsets continue after the restart
+	assert.ElementsMatch(t, []received{{"line", 0}, {"line", 5}}, readIndex(t, filepath.Join(dir, "web")))
+}
+
+func TestReceiverMaxBucketAge(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewReceiver(dir,
+		WithCheckInterval(10*time.Millisecond),
+		WithIndexOptions(splunker.WithMaxBucketAge(time.Millisecond)),
+	)
+	require.NoError(t, err)
+	defer r.Close()
+
+	sendFrames(t, r, startReceiver(t, r), Frame{{KeyRaw, "line"}, {KeyTime, "1700000000"}, {KeyIndex, "web"}})
+
+	// the hot bucket rolls without further events
+	assert.Eventually(t, func() bool {
+		idx, err := splunker.OpenIndex(filepath.Join(dir, "web"))
+		if err != nil {
+			return false
+		}
+		buckets := idx.Buckets()
+		return len(buckets) == 1 && buckets[0].State == splunker.BucketStateWarm
+	}, 5*time.Second, 10*time.Millisecond)
+}
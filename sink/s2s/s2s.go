@@ -0,0 +1,433 @@
+// Package s2s implements the cooked mode v2 of the Splunk-to-Splunk
+// protocol spoken between forwarders and indexers.
+//
+// A connection starts with a signature of 400 bytes: the string
+// "--splunk-cooked-mode-v2--", the name of the sending server and its
+// management port, each padded with zeros to 128, 256 and 16 bytes.
+// Every event follows as frame of big endian uint32 values:
+//
+//	size     length of the remaining frame
+//	count    number of key/value pairs
+//	pairs    key and value, each as length and zero terminated string
+//	0        end of the pairs
+//	"_raw"   as length and zero terminated string
+//
+// The event uses the keys _raw, _time, _subsecond, MetaData:Host,
+// MetaData:Source, MetaData:Sourcetype, _MetaData:Index and _meta with
+// the indexed fields as space separated name::value pairs.
+//
+// Forwarders negotiating the newer cooked mode v3 are rejected, they
+// fall back to v2 with negotiateProtocolLevel = 0 in outputs.conf.
+package s2s
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fionera/splunker"
+)
+
+const (
+	// Signature starts every connection
+	Signature = "--splunk-cooked-mode-v2--"
+	// SignatureV3 is sent by forwarders negotiating cooked mode v3
+	SignatureV3 = "--splunk-cooked-mode-v3--"
+
+	signatureLen  = 128
+	serverNameLen = 256
+	mgmtPortLen   = 16
+	handshakeLen  = signatureLen + serverNameLen + mgmtPortLen
+
+	// maxFrameSize protects the reader against corrupt frames
+	maxFrameSize = 64 << 20
+)
+
+// Keys of the event frames
+const (
+	KeyRaw         = "_raw"
+	KeyTime        = "_time"
+	KeySubsecond   = "_subsecond"
+	KeyHost        = "MetaData:Host"
+	KeySource      = "MetaData:Source"
+	KeySourceType  = "MetaData:Sourcetype"
+	KeyIndex       = "_MetaData:Index"
+	KeyMeta        = "_meta"
+	KeyDone        = "_done"
+	KeyLineBreaker = "_linebreaker"
+)
+
+// The metadata values carry the name of the field as prefix
+const (
+	hostPrefix       = "host::"
+	sourcePrefix     = "source::"
+	sourceTypePrefix = "sourcetype::"
+)
+
+// Handshake is the signature sent at the start of a connection.
+type Handshake struct {
+	ServerName string
+	MgmtPort   string
+}
+
+// KeyValue is a pair of an event frame.
+type KeyValue struct {
+	Key, Value string
+}
+
+// Frame is a decoded event frame.
+type Frame []KeyValue
+
+// Get returns the value of the first pair with key.
+func (f Frame) Get(key string) (string, bool) {
+	for _, kv := range f {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return "", false
+}
+
+// Index returns the target index of the event, empty if none is set.
+func (f Frame) Index() string {
+	v, _ := f.Get(KeyIndex)
+	return v
+}
+
+// Done reports whether the frame marks the end of a stream.
+func (f Frame) Done() bool {
+	_, ok := f.Get(KeyDone)
+	return ok
+}
+
+// Event converts the frame into an event. The bucket of the event
+// only carries the index of the frame.
+func (f Frame) Event() (splunker.Event, error) {
+	info, err := f.EventInfo()
+	if err != nil {
+		return splunker.Event{}, err
+	}
+	return splunker.NewEvent(info), nil
+}
+
+// EventInfo returns the fields of the event in the frame.
+func (f Frame) EventInfo() (splunker.EventInfo, error) {
+	raw, ok := f.Get(KeyRaw)
+	if !ok {
+		return splunker.EventInfo{}, errors.New("s2s: frame without _raw")
+	}
+
+	info := splunker.EventInfo{Raw: []byte(raw)}
+
+	var sec, nsec int64
+	if v, ok := f.Get(KeyTime); ok {
+		var err error
+		if sec, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return splunker.EventInfo{}, fmt.Errorf("s2s: invalid _time %q", v)
+		}
+	}
+	if v, ok := f.Get(KeySubsecond); ok {
+		d, err := strconv.ParseFloat(v, 64)
+		if err != nil || d < 0 || d >= 1 {
+			return splunker.EventInfo{}, fmt.Errorf("s2s: invalid _subsecond %q", v)
+		}
+		nsec = int64(d*1e6+0.5) * int64(time.Microsecond)
+	}
+	info.Time = time.Unix(sec, nsec)
+
+	if v, ok := f.Get(KeyHost); ok {
+		info.Host = strings.TrimPrefix(v, hostPrefix)
+	}
+	if v, ok := f.Get(KeySource); ok {
+		info.Source = strings.TrimPrefix(v, sourcePrefix)
+	}
+	if v, ok := f.Get(KeySourceType); ok {
+		info.SourceType = strings.TrimPrefix(v, sourceTypePrefix)
+	}
+	if index := f.Index(); index != "" {
+		info.Bucket = &splunker.Bucket{Index: index}
+	}
+	if v, ok := f.Get(KeyMeta); ok {
+		info.IndexedFields = ParseMeta(v)
+	}
+
+	return info, nil
+}
+
+// EventFrame returns the frame of e. index overrides the index of the
+// event if not empty.
+func EventFrame(e splunker.Event, index string) Frame {
+	if index == "" {
+		index = e.Bucket().Index
+	}
+
+	// _subsecond is always added to _time, so a negative time keeps
+	// its whole seconds rounded down
+	t := e.Time()
+
+	f := Frame{
+		{KeyRaw, e.MessageString()},
+		{KeyTime, strconv.FormatInt(t.Unix(), 10)},
+		{KeyHost, hostPrefix + e.Host()},
+		{KeySource, sourcePrefix + e.Source()},
+		{KeySourceType, sourceTypePrefix + e.SourceType()},
+	}
+	if us := t.Nanosecond() / int(time.Microsecond); us != 0 {
+		f = append(f, KeyValue{KeySubsecond, fmt.Sprintf(".%06d", us)})
+	}
+	if index != "" {
+		f = append(f, KeyValue{KeyIndex, index})
+	}
+	if fields := e.IndexedFields(); len(fields) > 0 {
+		f = append(f, KeyValue{KeyMeta, FormatMeta(fields)})
+	}
+
+	return f
+}
+
+// FormatMeta formats fields as space separated name::value pairs.
+// Values containing spaces, quotes or backslashes are quoted.
+func FormatMeta(fields []splunker.Field) string {
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(f.Name)
+		b.WriteString("::")
+
+		if !strings.ContainsAny(f.Value, ` "\`) {
+			b.WriteString(f.Value)
+			continue
+		}
+
+		b.WriteByte('"')
+		for j := 0; j < len(f.Value); j++ {
+			if c := f.Value[j]; c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(f.Value[j])
+		}
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// ParseMeta parses the fields formatted by FormatMeta. Tokens
+// without :: are skipped.
+func ParseMeta(s string) []splunker.Field {
+	var fields []splunker.Field
+	for s = strings.TrimLeft(s, " "); s != ""; s = strings.TrimLeft(s, " ") {
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			end = len(s)
+		}
+
+		name, value, ok := strings.Cut(s[:end], "::")
+		if ok && strings.HasPrefix(value, `"`) {
+			// the quoted value may contain spaces, read up to the closing quote
+			var b strings.Builder
+			i := len(name) + len("::") + 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			value = b.String()
+			end = min(i+1, len(s))
+		}
+		if ok && name != "" {
+			fields = append(fields, splunker.Field{Name: name, Value: value})
+		}
+
+		s = s[end:]
+	}
+	return fields
+}
+
+// Writer writes the handshake and event frames.
+type Writer struct {
+	w         *bufio.Writer
+	handshake Handshake
+	started   bool
+	buf       []byte
+}
+
+func NewWriter(w io.Writer, h Handshake) *Writer {
+	return &Writer{
+		w:         bufio.NewWriterSize(w, 256*1024),
+		handshake: h,
+	}
+}
+
+// writeHandshake writes the signature if it was not written yet
+func (w *Writer) writeHandshake() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	var b [handshakeLen]byte
+	copy(b[:signatureLen], Signature)
+	copy(b[signatureLen:signatureLen+serverNameLen-1], w.handshake.ServerName)
+	copy(b[signatureLen+serverNameLen:handshakeLen-1], w.handshake.MgmtPort)
+
+	_, err := w.w.Write(b[:])
+	return err
+}
+
+// WriteFrame writes f, preceded by the handshake on the first call.
+func (w *Writer) WriteFrame(f Frame) error {
+	if err := w.writeHandshake(); err != nil {
+		return err
+	}
+
+	w.buf = AppendFrame(w.buf[:0], f)
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Flush writes the buffered frames.
+func (w *Writer) Flush() error {
+	if err := w.writeHandshake(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// AppendFrame appends the encoding of f to b.
+func AppendFrame(b []byte, f Frame) []byte {
+	start := len(b)
+	// the size is filled in at the end
+	b = append(b, 0, 0, 0, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(f)))
+	for _, kv := range f {
+		b = appendString(b, kv.Key)
+		b = appendString(b, kv.Value)
+	}
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = appendString(b, KeyRaw)
+
+	binary.BigEndian.PutUint32(b[start:], uint32(len(b)-start-4))
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)+1))
+	b = append(b, s...)
+	return append(b, 0)
+}
+
+// ErrSignature is returned for connections not starting with the signature.
+var ErrSignature = errors.New("s2s: invalid signature")
+
+// ErrVersion is returned for connections of forwarders using cooked mode v3.
+var ErrVersion = errors.New("s2s: cooked mode v3 is not supported, set negotiateProtocolLevel = 0 in outputs.conf of the forwarder")
+
+var errCorrupt = errors.New("s2s: corrupt frame")
+
+// Reader reads the handshake and event frames.
+type Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 256*1024)}
+}
+
+// ReadHandshake reads the signature at the start of a connection.
+func (r *Reader) ReadHandshake() (Handshake, error) {
+	var b [handshakeLen]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return Handshake{}, err
+	}
+
+	switch cString(b[:signatureLen]) {
+	case Signature:
+	case SignatureV3:
+		return Handshake{}, ErrVersion
+	default:
+		return Handshake{}, ErrSignature
+	}
+
+	return Handshake{
+		ServerName: cString(b[signatureLen : signatureLen+serverNameLen]),
+		MgmtPort:   cString(b[signatureLen+serverNameLen:]),
+	}, nil
+}
+
+// cString returns b up to the first zero byte
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// ReadFrame reads the next frame. It returns io.EOF at the end of the
+// connection.
+func (r *Reader) ReadFrame() (Frame, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r.r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("s2s: frame of %d bytes exceeds the limit", n)
+	}
+	if cap(r.buf) < int(n) {
+		r.buf = make([]byte, n)
+	}
+	b := r.buf[:n]
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if len(b) < 4 {
+		return nil, errCorrupt
+	}
+	count := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	// every pair takes at least 10 bytes
+	if uint64(count)*10 > uint64(len(b)) {
+		return nil, errCorrupt
+	}
+
+	f := make(Frame, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var kv KeyValue
+		var err error
+		if kv.Key, b, err = readString(b); err != nil {
+			return nil, err
+		}
+		if kv.Value, b, err = readString(b); err != nil {
+			return nil, err
+		}
+		f = append(f, kv)
+	}
+
+	// the trailer of 0 and _raw carries no information
+	return f, nil
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, errCorrupt
+	}
+	l := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	if l == 0 || uint64(l) > uint64(len(b)) {
+		return "", nil, errCorrupt
+	}
+	// the length includes the terminating zero
+	return string(b[:l-1]), b[l:], nil
+}
@@ -0,0 +1,166 @@
+package s2s
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+// receive accepts a single connection on l and returns the handshake and frames
+func receive(t *testing.T, l net.Listener) <-chan []Frame {
+	t.Helper()
+
+	res := make(chan []Frame, 1)
+	go func() {
+		defer close(res)
+
+		conn, err := l.Accept()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		r := NewReader(conn)
+		h, err := r.ReadHandshake()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, Handshake{ServerName: "fwd01", MgmtPort: "8089"}, h)
+
+		var frames []Frame
+		for {
+			f, err := r.ReadFrame()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			frames = append(frames, f)
+		}
+		res <- frames
+	}()
+
+	return res
+}
+
+func testForwarder(t *testing.T, l net.Listener, cfg Config) {
+	frames := receive(t, l)
+
+	cfg.ServerName = "fwd01"
+	fwd, err := Dial(l.Addr().String(), cfg)
+	require.NoError(t, err)
+
+	in := splunker.NewEvent(splunker.EventInfo{
+		Time:       time.Unix(1700000000, 250000000),
+		Host:       "web01",
+		Source:     "/var/log/app.log",
+		SourceType: "app",
+		Raw:        []byte("GET /index.html 200"),
+		Bucket:     &splunker.Bucket{Index: "web"},
+		IndexedFields: []splunker.Field{
+			{Name: "env", Value: "prod"},
+			{Name: "msg", Value: `say "hi" \o/`},
+		},
+	})
+	require.NoError(t, fwd.WriteEvent(in))
+	require.NoError(t, fwd.Close())
+
+	res := <-frames
+	require.Len(t, res, 2)
+	assert.True(t, res[1].Done())
+
+	out, err := res[0].Event()
+	require.NoError(t, err)
+	assert.Equal(t, "copy", res[0].Index())
+	assert.Equal(t, in.Time(), out.Time())
+	assert.Equal(t, in.Host(), out.Host())
+	assert.Equal(t, in.Source(), out.Source())
+	assert.Equal(t, in.SourceType(), out.SourceType())
+	assert.Equal(t, in.MessageString(), out.MessageString())
+	assert.Equal(t, in.IndexedFields(), out.IndexedFields())
+}
+
+func TestForwarder(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	testForwarder(t, l, Config{Index: "copy"})
+}
+
+func TestForwarderTLS(t *testing.T) {
+	// borrow the certificate of a test server
+	srv := httptest.NewTLSServer(nil)
+	defer srv.Close()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates})
+	require.NoError(t, err)
+	defer l.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	testForwarder(t, l, Config{Index: "copy", TLSConfig: &tls.Config{RootCAs: pool, ServerName: "example.com"}})
+}
+
+func TestReaderSignature(t *testing.T) {
+	r := NewReader(bytes.NewReader(make([]byte, handshakeLen)))
+	_, err := r.ReadHandshake()
+	assert.ErrorIs(t, err, ErrSignature)
+
+	var b [handshakeLen]byte
+	copy(b[:], SignatureV3)
+	_, err = NewReader(bytes.NewReader(b[:])).ReadHandshake()
+	assert.ErrorIs(t, err, ErrVersion)
+}
+
+func TestEventFrameTime(t *testing.T) {
+	for _, tc := range []struct {
+		time      time.Time
+		sec       string
+		subsecond string
+	}{
+		{time.Unix(1700000000, 0), "1700000000", ""},
+		{time.Unix(1700000000, 250000000), "1700000000", ".250000"},
+		{time.Unix(1700000000, 1000), "1700000000", ".000001"},
+		{time.Unix(-1, 0), "-1", ""},
+		{time.Unix(-2, 750000000), "-2", ".750000"},
+		{time.Unix(-1, 999999000), "-1", ".999999"},
+	} {
+		in := splunker.NewEvent(splunker.EventInfo{Time: tc.time, Raw: []byte("x")})
+		f := EventFrame(in, "main")
+
+		sec, _ := f.Get(KeyTime)
+		assert.Equal(t, tc.sec, sec, tc.time)
+		subsecond, _ := f.Get(KeySubsecond)
+		assert.Equal(t, tc.subsecond, subsecond, tc.time)
+
+		out, err := f.Event()
+		require.NoError(t, err)
+		assert.True(t, tc.time.Equal(out.Time()), "%v != %v", tc.time, out.Time())
+	}
+}
+
+func TestMeta(t *testing.T) {
+	fields := []splunker.Field{
+		{Name: "a", Value: "1"},
+		{Name: "b", Value: "with space"},
+		{Name: "c", Value: `q"uo\te`},
+		{Name: "d", Value: ""},
+	}
+	s := FormatMeta(fields)
+	assert.Equal(t, `a::1 b::"with space" c::"q\"uo\\te" d::`, s)
+	assert.Equal(t, fields, ParseMeta(s))
+
+	assert.Equal(t, []splunker.Field{{Name: "x", Value: "y"}}, ParseMeta("  junk x::y  "))
+}
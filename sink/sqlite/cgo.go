@@ -0,0 +1,6 @@
+//go:build cgo
+
+package sqlite
+
+// cgoEnabled reports whether the driver is built, it wraps the C library
+const cgoEnabled = true
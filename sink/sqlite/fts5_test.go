@@ -0,0 +1,19 @@
+//go:build sqlite_fts5
+
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterFullText(t *testing.T) {
+	db := writeEvents(t, Config{Path: filepath.Join(t.TempDir(), "case.db"), FullText: true})
+
+	var host string
+	require.NoError(t, db.QueryRow(`SELECT e.host FROM events_fts JOIN events e ON e.id = events_fts.rowid WHERE events_fts MATCH 'deny'`).Scan(&host))
+	assert.Equal(t, "fw01", host)
+}
@@ -0,0 +1,18 @@
+//go:build !sqlite_fts5
+
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriterFullText in fts5_test.go runs with -tags sqlite_fts5
+func TestWriterNoFullText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "case.db")
+	_, err := Open(Config{Path: path, FullText: true})
+	assert.ErrorContains(t, err, "-tags sqlite_fts5")
+	assert.NoFileExists(t, path)
+}
@@ -0,0 +1,330 @@
+// Package sqlite writes events into a SQLite database, so they can be
+// queried without Splunk. The database has the tables
+//
+//	events          _time (unix timestamp with fraction), host, source,
+//	                sourcetype, _raw, the bucket and the stream offsets
+//	indexed_fields  event, name and value of every indexed field
+//	buckets         index, id, guid, state, time range and path of the
+//	                buckets the events were read from
+//
+// Indexes on _time, host, sourcetype and the field names are created when
+// the Writer is closed, as that is faster than updating them while
+// inserting. With Config.FullText an FTS5 table events_fts indexes _raw,
+// which requires the driver to be built with -tags sqlite_fts5. The driver
+// needs cgo, without it Open fails.
+//
+// SQLite has no unsigned integers, stream ids and offsets larger than
+// 2^63-1 are stored as negative numbers.
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/fionera/splunker"
+)
+
+const schema = `
+CREATE TABLE buckets (
+	id         INTEGER PRIMARY KEY,
+	"index"    TEXT NOT NULL,
+	bucket_id  INTEGER,
+	guid       TEXT,
+	state      TEXT,
+	replicated INTEGER,
+	oldest     INTEGER,
+	newest     INTEGER,
+	path       TEXT
+);
+CREATE TABLE events (
+	id               INTEGER PRIMARY KEY,
+	_time            REAL NOT NULL,
+	host             TEXT NOT NULL,
+	source           TEXT NOT NULL,
+	sourcetype       TEXT NOT NULL,
+	_raw             TEXT NOT NULL,
+	bucket           INTEGER NOT NULL REFERENCES buckets (id),
+	stream_id        INTEGER NOT NULL,
+	stream_offset    INTEGER NOT NULL,
+	stream_suboffset INTEGER NOT NULL,
+	hash             BLOB
+);
+CREATE TABLE indexed_fields (
+	event INTEGER NOT NULL REFERENCES events (id),
+	name  TEXT NOT NULL,
+	value TEXT NOT NULL
+);
+`
+
+const indexes = `
+CREATE INDEX events_time ON events (_time);
+CREATE INDEX events_host ON events (host, _time);
+CREATE INDEX events_sourcetype ON events (sourcetype, _time);
+CREATE INDEX indexed_fields_event ON indexed_fields (event);
+CREATE INDEX indexed_fields_name ON indexed_fields (name, value);
+ANALYZE;
+`
+
+const fullText = `
+CREATE VIRTUAL TABLE events_fts USING fts5 (_raw, content = 'events', content_rowid = 'id');
+INSERT INTO events_fts (events_fts) VALUES ('rebuild');
+`
+
+// Config configures the Writer. Only Path is required.
+type Config struct {
+	// Path of the database, it must not exist
+	Path string
+	// FullText creates an FTS5 index over _raw
+	FullText bool
+	// BatchSize is the number of events inserted per transaction,
+	// defaults to 10000
+	BatchSize int
+}
+
+// bucketKey identifies a bucket, events without one are grouped by index
+type bucketKey struct {
+	path, index string
+}
+
+// Writer inserts events into a new SQLite database.
+type Writer struct {
+	cfg Config
+	db  *sql.DB
+
+	tx          *sql.Tx
+	insertEvent *sql.Stmt
+	insertField *sql.Stmt
+	pending     int
+
+	buckets map[bucketKey]int64
+	// ids of the last inserted event and bucket
+	eventID, bucketID int64
+	// ids of the last committed event and bucket
+	committedEventID, committedBucketID int64
+}
+
+var _ splunker.Sink = (*Writer)(nil)
+
+func Open(cfg Config) (*Writer, error) {
+	if !cgoEnabled {
+		return nil, errors.New("sqlite: the driver needs cgo, build with CGO_ENABLED=1")
+	}
+	if cfg.Path == "" {
+		return nil, errors.New("sqlite: Path is required")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 10000
+	}
+
+	if _, err := os.Stat(cfg.Path); err == nil {
+		return nil, fmt.Errorf("sqlite: %s already exists", cfg.Path)
+	}
+
+	// the database is not usable before Close, so it does not need a journal
+	db, err := sql.Open("sqlite3", "file:"+cfg.Path+"?_journal_mode=OFF&_synchronous=OFF")
+	if err != nil {
+		return nil, err
+	}
+	// a single connection keeps the transaction and the statements together
+	db.SetMaxOpenConns(1)
+
+	if err := initDB(db, cfg.FullText); err != nil {
+		_ = db.Close()
+		// do not leave an empty database behind that blocks the next attempt
+		_ = os.Remove(cfg.Path)
+		return nil, err
+	}
+
+	return &Writer{
+		cfg:     cfg,
+		db:      db,
+		buckets: make(map[bucketKey]int64),
+	}, nil
+}
+
+func initDB(db *sql.DB, fullText bool) error {
+	if fullText {
+		var fts5 bool
+		if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+			return fmt.Errorf("sqlite: %w", err)
+		}
+		if !fts5 {
+			return errors.New("sqlite: FTS5 is not available, build with -tags sqlite_fts5")
+		}
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		return fmt.Errorf("sqlite: create tables: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) begin() error {
+	var err error
+	if w.tx, err = w.db.Begin(); err != nil {
+		return err
+	}
+	if w.insertEvent, err = w.tx.Prepare(`INSERT INTO events VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`); err != nil {
+		return err
+	}
+	w.insertField, err = w.tx.Prepare(`INSERT INTO indexed_fields VALUES (?, ?, ?)`)
+	return err
+}
+
+func (w *Writer) commit() error {
+	w.pending = 0
+	tx := w.tx
+	w.tx = nil
+	if err := tx.Commit(); err != nil {
+		w.reset()
+		return err
+	}
+	w.committedEventID, w.committedBucketID = w.eventID, w.bucketID
+	return nil
+}
+
+// rollback discards the events of the transaction, so the Writer can
+// continue after a failed insert
+func (w *Writer) rollback() {
+	w.pending = 0
+	_ = w.tx.Rollback()
+	w.tx = nil
+	w.reset()
+}
+
+// reset forgets the rows inserted since the last commit
+func (w *Writer) reset() {
+	w.eventID, w.bucketID = w.committedEventID, w.committedBucketID
+	for k, id := range w.buckets {
+		if id > w.bucketID {
+			delete(w.buckets, k)
+		}
+	}
+}
+
+// bucket returns the id of the row of b in buckets and inserts it if necessary
+func (w *Writer) bucket(b splunker.Bucket) (int64, error) {
+	key := bucketKey{path: b.Path, index: b.Index}
+	if id, ok := w.buckets[key]; ok {
+		return id, nil
+	}
+
+	nullable := func(v any, ok bool) any {
+		if !ok {
+			return nil
+		}
+		return v
+	}
+	_, err := w.tx.Exec(`INSERT INTO buckets VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		w.bucketID+1,
+		b.Index,
+		nullable(int64(b.ID), b.Path != ""),
+		nullable(b.GUID, b.GUID != ""),
+		nullable(b.State.String()[len("BucketState"):], b.Path != ""),
+		b.Replicated,
+		nullable(b.Oldest.Unix(), !b.Oldest.IsZero()),
+		nullable(b.Newest.Unix(), !b.Newest.IsZero()),
+		nullable(b.Path, b.Path != ""),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	w.bucketID++
+	w.buckets[key] = w.bucketID
+	return w.bucketID, nil
+}
+
+func (w *Writer) WriteEvent(e splunker.Event) error {
+	if w.tx == nil {
+		if err := w.begin(); err != nil {
+			if w.tx != nil {
+				w.rollback()
+			}
+			return fmt.Errorf("sqlite: %w", err)
+		}
+	}
+
+	if err := w.insert(e); err != nil {
+		w.rollback()
+		return fmt.Errorf("sqlite: %w", err)
+	}
+
+	if w.pending++; w.pending >= w.cfg.BatchSize {
+		if err := w.commit(); err != nil {
+			return fmt.Errorf("sqlite: %w", err)
+		}
+	}
+	return nil
+}
+
+func (w *Writer) insert(e splunker.Event) error {
+	bucket, err := w.bucket(e.Bucket())
+	if err != nil {
+		return fmt.Errorf("insert bucket: %w", err)
+	}
+
+	var hash any
+	if h, ok := e.Hash(); ok {
+		hash = h[:]
+	}
+
+	t := e.Time()
+	id := w.eventID + 1
+	_, err = w.insertEvent.Exec(
+		id,
+		float64(t.Unix())+float64(t.Nanosecond())/1e9,
+		e.Host(),
+		e.Source(),
+		e.SourceType(),
+		e.MessageString(),
+		bucket,
+		int64(e.StreamID()),
+		int64(e.StreamOffset()),
+		int64(e.StreamSubOffset()),
+		hash,
+	)
+	if err != nil {
+		return fmt.Errorf("insert event: %w", err)
+	}
+
+	for _, f := range e.IndexedFields() {
+		if _, err := w.insertField.Exec(id, f.Name, f.Value); err != nil {
+			return fmt.Errorf("insert field: %w", err)
+		}
+	}
+
+	w.eventID = id
+	return nil
+}
+
+// Close commits the remaining events, creates the indexes and closes the database.
+func (w *Writer) Close() error {
+	err := w.close()
+	if cerr := w.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *Writer) close() error {
+	if w.tx != nil {
+		if err := w.commit(); err != nil {
+			return fmt.Errorf("sqlite: %w", err)
+		}
+	}
+
+	if _, err := w.db.Exec(indexes); err != nil {
+		return fmt.Errorf("sqlite: create indexes: %w", err)
+	}
+	if w.cfg.FullText {
+		if _, err := w.db.Exec(fullText); err != nil {
+			return fmt.Errorf("sqlite: create full text index: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package sqlite
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+func writeEvents(t *testing.T, cfg Config) *sql.DB {
+	w, err := Open(cfg)
+	require.NoError(t, err)
+
+	web := &splunker.Bucket{Index: "web", ID: 7, State: splunker.BucketStateWarm, Path: "/opt/splunk/web/db/db_1700000009_1700000000_7",
+		Oldest: time.Unix(1700000000, 0), Newest: time.Unix(1700000009, 0)}
+	for i := 0; i < 5; i++ {
+		info := splunker.EventInfo{
+			Time:          time.Unix(1700000000+int64(i), 500000000),
+			Host:          "web01",
+			Source:        "/var/log/access.log",
+			SourceType:    "access_combined",
+			Raw:           []byte("GET /index.html 200"),
+			Bucket:        web,
+			StreamOffset:  uint64(i) * 20,
+			IndexedFields: []splunker.Field{{Name: "status", Value: "200"}, {Name: "tag", Value: "a"}, {Name: "tag", Value: "b"}},
+		}
+		if i == 4 {
+			info.Host = "fw01"
+			info.Raw = []byte("deny udp 10.0.0.1 -> 10.0.0.2")
+			info.Bucket = &splunker.Bucket{Index: "firewall"}
+			info.IndexedFields = nil
+		}
+		require.NoError(t, w.WriteEvent(splunker.NewEvent(info)))
+	}
+	require.NoError(t, w.Close())
+
+	db, err := sql.Open("sqlite3", cfg.Path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "case.db")
+	db := writeEvents(t, Config{Path: path, BatchSize: 2})
+
+	var n int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM events WHERE host = 'web01'`).Scan(&n))
+	assert.Equal(t, 4, n)
+
+	var tm float64
+	var index, state string
+	require.NoError(t, db.QueryRow(`SELECT e._time, b."index", b.state FROM events e JOIN buckets b ON b.id = e.bucket WHERE e.stream_offset = 20`).
+		Scan(&tm, &index, &state))
+	assert.Equal(t, 1700000001.5, tm)
+	assert.Equal(t, "web", index)
+	assert.Equal(t, "Warm", state)
+
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM indexed_fields WHERE name = 'tag'`).Scan(&n))
+	assert.Equal(t, 8, n)
+
+	var path2 sql.NullString
+	require.NoError(t, db.QueryRow(`SELECT b.path FROM events e JOIN buckets b ON b.id = e.bucket WHERE e.host = 'fw01'`).Scan(&path2))
+	assert.False(t, path2.Valid)
+
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'events_%'`).Scan(&n))
+	assert.Equal(t, 3, n)
+
+	_, err := Open(Config{Path: path})
+	assert.Error(t, err)
+}
+
+func TestWriterRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "case.db")
+	w, err := Open(Config{Path: path})
+	require.NoError(t, err)
+
+	event := func(host string, b *splunker.Bucket) splunker.Event {
+		return splunker.NewEvent(splunker.EventInfo{Time: time.Unix(1700000000, 0), Host: host, Raw: []byte("line"), Bucket: b})
+	}
+	require.NoError(t, w.WriteEvent(event("web01", &splunker.Bucket{Index: "web"})))
+
+	// take the id of the next event, so its insert fails
+	_, err = w.tx.Exec(`INSERT INTO events VALUES (2, 0, '', '', '', '', 1, 0, 0, 0, NULL)`)
+	require.NoError(t, err)
+	assert.Error(t, w.WriteEvent(event("fw01", &splunker.Bucket{Index: "firewall"})))
+	assert.Nil(t, w.tx)
+	assert.Zero(t, w.eventID)
+
+	require.NoError(t, w.WriteEvent(event("fw01", &splunker.Bucket{Index: "firewall"})))
+	require.NoError(t, w.Close())
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var id int64
+	var index string
+	require.NoError(t, db.QueryRow(`SELECT e.id, b."index" FROM events e JOIN buckets b ON b.id = e.bucket WHERE e.host = 'fw01'`).
+		Scan(&id, &index))
+	assert.EqualValues(t, 1, id)
+	assert.Equal(t, "firewall", index)
+
+	var n int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM events`).Scan(&n))
+	assert.Equal(t, 1, n)
+}
@@ -0,0 +1,265 @@
+// Package syslog sends events as syslog messages over UDP, TCP or TLS.
+//
+// The host of an event is the HOSTNAME, its sourcetype the APP-NAME and
+// its _time the TIMESTAMP of the message, the _raw is the MSG. In RFC 5424
+// messages source, index and the indexed fields are parameters of a
+// STRUCTURED-DATA element. Over TCP and TLS messages are framed by octet
+// counting (RFC 6587), over UDP every message is a datagram.
+package syslog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/fionera/splunker"
+)
+
+// Format is the syslog message format.
+type Format int
+
+const (
+	RFC5424 Format = iota
+	RFC3164
+)
+
+// DefaultSDID is the id of the STRUCTURED-DATA element, using the
+// enterprise number reserved for documentation.
+const DefaultSDID = "splunk@32473"
+
+// Config configures the Writer. Only Addr is required.
+type Config struct {
+	// Network is udp, tcp or tls, defaults to udp
+	Network string
+	Addr    string
+	// TLSConfig is used for the tls network
+	TLSConfig *tls.Config
+
+	Format Format
+	// Facility and Severity of all messages, default to user (1) and
+	// notice (5) when nil
+	Facility *int
+	Severity *int
+	// SDID is the id of the STRUCTURED-DATA element, defaults to DefaultSDID
+	SDID string
+	// MaxMessageSize truncates longer messages sent over UDP, defaults
+	// to 65507, the maximum payload of a datagram
+	MaxMessageSize int
+
+	// DialTimeout defaults to 30s
+	DialTimeout time.Duration
+}
+
+// Writer sends events to a syslog server.
+type Writer struct {
+	cfg      Config
+	priority int
+	conn     net.Conn
+	// w buffers the messages on stream connections, nil for UDP
+	w   *bufio.Writer
+	buf []byte
+}
+
+var _ splunker.Sink = (*Writer)(nil)
+
+// Dial connects to the syslog server at cfg.Addr.
+func Dial(cfg Config) (*Writer, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("syslog: Addr is required")
+	}
+	if cfg.Network == "" {
+		cfg.Network = "udp"
+	}
+	if cfg.SDID == "" {
+		cfg.SDID = DefaultSDID
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = 65507
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 30 * time.Second
+	}
+
+	facility, severity := 1, 5
+	if cfg.Facility != nil {
+		facility = *cfg.Facility
+	}
+	if cfg.Severity != nil {
+		severity = *cfg.Severity
+	}
+	if facility < 0 || facility > 23 || severity < 0 || severity > 7 {
+		return nil, fmt.Errorf("syslog: invalid facility %d or severity %d", facility, severity)
+	}
+
+	w := &Writer{
+		cfg:      cfg,
+		priority: facility*8 + severity,
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout}
+	var err error
+	switch cfg.Network {
+	case "udp", "tcp":
+		w.conn, err = dialer.Dial(cfg.Network, cfg.Addr)
+	case "tls":
+		w.conn, err = tls.DialWithDialer(dialer, "tcp", cfg.Addr, cfg.TLSConfig)
+	default:
+		return nil, fmt.Errorf("syslog: unknown network %q", cfg.Network)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("syslog: %w", err)
+	}
+
+	if cfg.Network != "udp" {
+		w.w = bufio.NewWriterSize(w.conn, 256*1024)
+	}
+
+	return w, nil
+}
+
+func (w *Writer) WriteEvent(e splunker.Event) error {
+	if w.cfg.Format == RFC3164 {
+		w.buf = AppendRFC3164(w.buf[:0], w.priority, e)
+	} else {
+		w.buf = AppendRFC5424(w.buf[:0], w.priority, w.cfg.SDID, e)
+	}
+
+	if w.w == nil {
+		msg := w.buf[:min(len(w.buf), w.cfg.MaxMessageSize)]
+		_, err := w.conn.Write(msg)
+		return err
+	}
+
+	if _, err := w.w.WriteString(strconv.Itoa(len(w.buf))); err != nil {
+		return err
+	}
+	if err := w.w.WriteByte(' '); err != nil {
+		return err
+	}
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Close flushes the buffered messages and closes the connection.
+func (w *Writer) Close() error {
+	var err error
+	if w.w != nil {
+		err = w.w.Flush()
+	}
+	if cerr := w.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// AppendRFC5424 appends the RFC 5424 message of e to b.
+func AppendRFC5424(b []byte, priority int, sdID string, e splunker.Event) []byte {
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(priority), 10)
+	b = append(b, ">1 "...)
+	b = e.Time().UTC().AppendFormat(b, "2006-01-02T15:04:05.000000Z07:00")
+	b = append(b, ' ')
+	b = appendHeaderField(b, e.Host(), 255)
+	b = append(b, ' ')
+	b = appendHeaderField(b, e.SourceType(), 48)
+	// PROCID and MSGID
+	b = append(b, " - - "...)
+
+	start := len(b)
+	b = append(b, '[')
+	b = append(b, sdID...)
+	b = appendParam(b, "source", e.Source())
+	b = appendParam(b, "index", e.Bucket().Index)
+	for _, f := range e.IndexedFields() {
+		b = appendParam(b, f.Name, f.Value)
+	}
+	if len(b) == start+1+len(sdID) {
+		// no parameters, use the NILVALUE
+		b = append(b[:start], '-')
+	} else {
+		b = append(b, ']')
+	}
+
+	if msg := e.Message(); len(msg) > 0 {
+		b = append(b, ' ')
+		b = append(b, msg...)
+	}
+	return b
+}
+
+// AppendRFC3164 appends the BSD syslog message of e to b. The sourcetype
+// is the TAG.
+func AppendRFC3164(b []byte, priority int, e splunker.Event) []byte {
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(priority), 10)
+	b = append(b, '>')
+	b = e.Time().UTC().AppendFormat(b, time.Stamp)
+	b = append(b, ' ')
+	b = appendHeaderField(b, e.Host(), 255)
+	b = append(b, ' ')
+
+	// the TAG consists of up to 32 alphanumeric characters
+	tag := len(b)
+	for _, c := range []byte(e.SourceType()) {
+		if len(b)-tag == 32 {
+			break
+		}
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' {
+			b = append(b, c)
+		}
+	}
+	if len(b) == tag {
+		b = append(b, "splunk"...)
+	}
+	b = append(b, ": "...)
+
+	return append(b, e.Message()...)
+}
+
+// appendHeaderField appends s with at most max printable ASCII characters
+// or the NILVALUE if s is empty
+func appendHeaderField(b []byte, s string, max int) []byte {
+	start := len(b)
+	for i := 0; i < len(s) && len(b)-start < max; i++ {
+		if c := s[i]; c > ' ' && c < 0x7f {
+			b = append(b, c)
+		}
+	}
+	if len(b) == start {
+		b = append(b, '-')
+	}
+	return b
+}
+
+// appendParam appends a SD-PARAM, the name is reduced to the allowed
+// characters and special characters in the value are escaped. Empty
+// values are skipped.
+func appendParam(b []byte, name, value string) []byte {
+	if value == "" {
+		return b
+	}
+
+	b = append(b, ' ')
+	start := len(b)
+	for i := 0; i < len(name) && len(b)-start < 32; i++ {
+		if c := name[i]; c > ' ' && c < 0x7f && c != '=' && c != ']' && c != '"' {
+			b = append(b, c)
+		}
+	}
+	if len(b) == start {
+		return b[:start-1]
+	}
+
+	b = append(b, '=', '"')
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == '"' || c == '\\' || c == ']' {
+			b = append(b, '\\')
+		}
+		b = append(b, value[i])
+	}
+	return append(b, '"')
+}
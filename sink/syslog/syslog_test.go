@@ -0,0 +1,133 @@
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+func testEvent() splunker.Event {
+	return splunker.NewEvent(splunker.EventInfo{
+		Time:       time.Unix(1700000000, 250000000),
+		Host:       "fw01",
+		Source:     "udp:514",
+		SourceType: "pan:traffic",
+		Raw:        []byte("allow tcp 10.0.0.1 -> 10.0.0.2"),
+		Bucket:     &splunker.Bucket{Index: "firewall"},
+		IndexedFields: []splunker.Field{
+			{Name: "rule", Value: `allow "web" [dmz]`},
+			{Name: "bad name=", Value: "x"},
+		},
+	})
+}
+
+func TestAppendRFC5424(t *testing.T) {
+	assert.Equal(t, `<13>1 2023-11-14T22:13:20.250000Z fw01 pan:traffic - - `+
+		`[splunk@32473 source="udp:514" index="firewall" rule="allow \"web\" [dmz\]" badname="x"] `+
+		`allow tcp 10.0.0.1 -> 10.0.0.2`,
+		string(AppendRFC5424(nil, 13, DefaultSDID, testEvent())))
+
+	assert.Equal(t, `<13>1 2023-11-14T22:13:20.000000Z - - - - -`,
+		string(AppendRFC5424(nil, 13, DefaultSDID, splunker.NewEvent(splunker.EventInfo{Time: time.Unix(1700000000, 0)}))))
+}
+
+func TestAppendRFC3164(t *testing.T) {
+	assert.Equal(t, `<134>Nov 14 22:13:20 fw01 pantraffic: allow tcp 10.0.0.1 -> 10.0.0.2`,
+		string(AppendRFC3164(nil, 134, testEvent())))
+}
+
+func TestWriterUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	w, err := Dial(Config{Addr: pc.LocalAddr().String(), Format: RFC3164, Facility: ptr(16), Severity: ptr(6), MaxMessageSize: 20})
+	require.NoError(t, err)
+	require.NoError(t, w.WriteEvent(testEvent()))
+	require.NoError(t, w.Close())
+
+	buf := make([]byte, 1024)
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "<134>Nov 14 22:13:20", string(buf[:n]))
+}
+
+func ptr(v int) *int {
+	return &v
+}
+
+func TestWriterKernEmergency(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	// facility kern and severity emerg are both 0
+	w, err := Dial(Config{Addr: pc.LocalAddr().String(), Facility: ptr(0), Severity: ptr(0), MaxMessageSize: 4})
+	require.NoError(t, err)
+	require.NoError(t, w.WriteEvent(testEvent()))
+	require.NoError(t, w.Close())
+
+	buf := make([]byte, 1024)
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "<0>1", string(buf[:n]))
+
+	_, err = Dial(Config{Addr: pc.LocalAddr().String(), Severity: ptr(8)})
+	assert.Error(t, err)
+}
+
+func TestWriterTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	msgs := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		// octet counting: the length, a space and the message
+		var res []string
+		r := bufio.NewReader(conn)
+		for {
+			l, err := r.ReadString(' ')
+			if err == io.EOF {
+				break
+			}
+			n, err := strconv.Atoi(l[:len(l)-1])
+			if !assert.NoError(t, err) {
+				return
+			}
+			msg := make([]byte, n)
+			_, err = io.ReadFull(r, msg)
+			assert.NoError(t, err)
+			res = append(res, string(msg))
+		}
+		msgs <- res
+	}()
+
+	w, err := Dial(Config{Network: "tcp", Addr: l.Addr().String()})
+	require.NoError(t, err)
+	multiline := splunker.NewEvent(splunker.EventInfo{Time: time.Unix(1700000000, 0), Host: "fw01", Raw: []byte("line 1\nline 2")})
+	require.NoError(t, w.WriteEvent(testEvent()))
+	require.NoError(t, w.WriteEvent(multiline))
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, []string{
+		string(AppendRFC5424(nil, 13, DefaultSDID, testEvent())),
+		"<13>1 2023-11-14T22:13:20.000000Z fw01 - - - - line 1\nline 2",
+	}, <-msgs)
+}
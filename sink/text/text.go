@@ -0,0 +1,164 @@
+// Package text renders every event through a text/template, so the line
+// format of the output can be chosen without changing the code, e.g.
+//
+//	{{.Host}} - {{.SourceType}} - {{.Source}}: {{.Raw}}
+//	{{format .Time "2006-01-02 15:04:05"}} {{.Index}} {{.Field "status"}} {{json .Raw}}
+//
+// The template is executed with an Event. Besides the functions of
+// text/template it can use
+//
+//	format   formats a time.Time with a layout of package time
+//	utc      converts a time.Time to UTC
+//	local    converts a time.Time to the local time zone
+//	unix     returns the unix timestamp of a time.Time
+//	json     quotes a value as JSON
+//	join     joins a []string with a separator
+//	lower, upper, trim, replace
+//	         strings.ToLower, ToUpper, TrimSpace and ReplaceAll
+//
+// A newline is written after every event unless the output of the
+// template already ends with one.
+package text
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fionera/splunker"
+)
+
+// DefaultTemplate writes host, sourcetype and source in front of _raw.
+const DefaultTemplate = `{{.Host}} - {{.SourceType}} - {{.Source}}: {{.Raw}}`
+
+// Funcs are the functions available to the templates, in addition
+// to the builtin functions of text/template.
+var Funcs = template.FuncMap{
+	"format": func(t time.Time, layout string) string { return t.Format(layout) },
+	"utc":    func(t time.Time) time.Time { return t.UTC() },
+	"local":  func(t time.Time) time.Time { return t.Local() },
+	"unix":   func(t time.Time) int64 { return t.Unix() },
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join":    func(s []string, sep string) string { return strings.Join(s, sep) },
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"trim":    strings.TrimSpace,
+	"replace": func(s, old, new string) string { return strings.ReplaceAll(s, old, new) },
+}
+
+// Parse parses text as template with Funcs.
+func Parse(text string) (*template.Template, error) {
+	return template.New("event").Funcs(Funcs).Parse(text)
+}
+
+// Event is the data the template is executed with.
+type Event struct {
+	// Raw is the _raw of the event
+	Raw        string
+	Time       time.Time
+	Host       string
+	Source     string
+	SourceType string
+	Index      string
+	// Bucket the event was read from, its Path is empty if it is unknown
+	Bucket splunker.Bucket
+	Fields []splunker.Field
+
+	e splunker.Event
+}
+
+// Epoch returns _time as unix timestamp with the subseconds as fraction.
+func (e Event) Epoch() string {
+	return e.e.Epoch()
+}
+
+// Hash returns the hex encoded hash of the event, or an empty string
+// if the journal does not store one.
+func (e Event) Hash() string {
+	hash, ok := e.e.Hash()
+	if !ok {
+		return ""
+	}
+	return hex.EncodeToString(hash[:])
+}
+
+func (e Event) StreamID() uint64        { return e.e.StreamID() }
+func (e Event) StreamOffset() uint64    { return e.e.StreamOffset() }
+func (e Event) StreamSubOffset() uint64 { return e.e.StreamSubOffset() }
+
+// Field returns the first value of the indexed field name, or an empty
+// string if the event does not have it.
+func (e Event) Field(name string) string {
+	for _, f := range e.Fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// FieldValues returns all values of the indexed field name.
+func (e Event) FieldValues(name string) []string {
+	var values []string
+	for _, f := range e.Fields {
+		if f.Name == name {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+// Writer writes every event rendered through a template.
+type Writer struct {
+	w    *bufio.Writer
+	tmpl *template.Template
+	buf  bytes.Buffer
+}
+
+var _ splunker.Sink = (*Writer)(nil)
+
+func NewWriter(w io.Writer, tmpl *template.Template) *Writer {
+	return &Writer{
+		w:    bufio.NewWriterSize(w, 1<<20),
+		tmpl: tmpl,
+	}
+}
+
+func (w *Writer) WriteEvent(e splunker.Event) error {
+	bucket := e.Bucket()
+
+	w.buf.Reset()
+	err := w.tmpl.Execute(&w.buf, Event{
+		Raw:        e.MessageString(),
+		Time:       e.Time(),
+		Host:       e.Host(),
+		Source:     e.Source(),
+		SourceType: e.SourceType(),
+		Index:      bucket.Index,
+		Bucket:     bucket,
+		Fields:     e.IndexedFields(),
+		e:          e,
+	})
+	if err != nil {
+		return err
+	}
+
+	if b := w.buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+		w.buf.WriteByte('\n')
+	}
+	_, err = w.w.Write(w.buf.Bytes())
+	return err
+}
+
+// Close flushes the buffered output.
+func (w *Writer) Close() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,57 @@
+package text
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fionera/splunker"
+)
+
+func testEvent() splunker.Event {
+	return splunker.NewEvent(splunker.EventInfo{
+		Time:       time.Unix(1700000000, 250000000),
+		Host:       "web01",
+		Source:     "/var/log/access.log",
+		SourceType: "access_combined",
+		Raw:        []byte(`GET /index.html "200"`),
+		Bucket:     &splunker.Bucket{Index: "web", ID: 7, Path: "/opt/splunk/web/db/db_1_1_7"},
+		IndexedFields: []splunker.Field{
+			{Name: "status", Value: "200"},
+			{Name: "tag", Value: "a"},
+			{Name: "tag", Value: "b"},
+		},
+	})
+}
+
+func render(t *testing.T, text string) string {
+	tmpl, err := Parse(text)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, tmpl)
+	require.NoError(t, w.WriteEvent(testEvent()))
+	require.NoError(t, w.Close())
+	return buf.String()
+}
+
+func TestWriter(t *testing.T) {
+	assert.Equal(t, "web01 - access_combined - /var/log/access.log: GET /index.html \"200\"\n", render(t, DefaultTemplate))
+
+	assert.Equal(t, "2023-11-14 22:13:20 1700000000.250000 web~7 200 a,b\n",
+		render(t, `{{format (utc .Time) "2006-01-02 15:04:05"}} {{.Epoch}} {{.Bucket.SplunkID}} {{.Field "status"}} {{join (.FieldValues "tag") ","}}`))
+
+	assert.Equal(t, `"GET /index.html \"200\""`+"\n", render(t, `{{json .Raw}}`+"\n"))
+	assert.Equal(t, "WEB 1700000000 \n", render(t, `{{upper .Index}} {{unix .Time}} {{.Hash}}`))
+}
+
+func TestWriterError(t *testing.T) {
+	tmpl, err := Parse(`{{.Missing}}`)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.Error(t, NewWriter(&buf, tmpl).WriteEvent(testEvent()))
+}
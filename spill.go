@@ -0,0 +1,519 @@
+package splunker
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"os"
+	"slices"
+	"sync"
+)
+
+// bucketTable assigns ids to the buckets of spilled events
+// as a bucket pointer can not be written to disk
+type bucketTable struct {
+	mu  sync.Mutex
+	ids map[*Bucket]uint64
+	// buckets[id-1] is the bucket with the id, 0 means no bucket
+	buckets []*Bucket
+}
+
+func (t *bucketTable) id(b *Bucket) uint64 {
+	if b == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id, ok := t.ids[b]
+	if !ok {
+		if t.ids == nil {
+			t.ids = make(map[*Bucket]uint64)
+		}
+		t.buckets = append(t.buckets, b)
+		id = uint64(len(t.buckets))
+		t.ids[b] = id
+	}
+
+	return id
+}
+
+func (t *bucketTable) bucket(id uint64) *Bucket {
+	if id == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.buckets[id-1]
+}
+
+const (
+	spillHasHash = 1 << iota
+	spillIncludePunctuation
+)
+
+// appendSpillEvent appends the encoding of e to b.
+// The record is prefixed with its length.
+func appendSpillEvent(b []byte, e *Event, bucketID uint64) []byte {
+	start := len(b)
+	// reserve the space for the length, it is moved into place afterwards
+	b = append(b, make([]byte, binary.MaxVarintLen64)...)
+	body := len(b)
+
+	var flags byte
+	if e.hasHash {
+		flags |= spillHasHash
+	}
+	if e.includePunctuation {
+		flags |= spillIncludePunctuation
+	}
+	b = append(b, flags)
+	if e.hasHash {
+		b = append(b, e.hash[:]...)
+	}
+
+	b = binary.AppendVarint(b, e.indexTime)
+	b = binary.AppendUvarint(b, e.subSeconds)
+	b = binary.AppendUvarint(b, e.streamID)
+	b = binary.AppendUvarint(b, e.streamOffset)
+	b = binary.AppendUvarint(b, e.streamSubOffset)
+	b = binary.AppendUvarint(b, e.metadataCount)
+	b = binary.AppendUvarint(b, bucketID)
+	b = appendSpillBytes(b, e.host)
+	b = appendSpillBytes(b, e.source)
+	b = appendSpillBytes(b, e.sourceType)
+	b = appendSpillBytes(b, e.message)
+	b = binary.AppendUvarint(b, uint64(len(e.fields)))
+	for _, f := range e.fields {
+		b = appendSpillBytes(b, f.Name)
+		b = appendSpillBytes(b, f.Value)
+	}
+
+	var l [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(l[:], uint64(len(b)-body))
+	copy(b[start:], l[:n])
+
+	return append(b[:start+n], b[body:]...)
+}
+
+func appendSpillBytes[T string | []byte](b []byte, s T) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// spillDecoder reads the events written by appendSpillEvent
+type spillDecoder struct {
+	r       *bufio.Reader
+	buf     []byte
+	buckets *bucketTable
+	// strings interns host, source, sourcetype and field names
+	strings map[string]string
+}
+
+var errSpillCorrupt = errors.New("corrupt spill record")
+
+func (d *spillDecoder) decode(e *Event) error {
+	l, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return err
+	}
+	if l > math.MaxInt32 {
+		return errSpillCorrupt
+	}
+
+	if cap(d.buf) < int(l) {
+		d.buf = make([]byte, l)
+	}
+	b := d.buf[:l]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		// only the end of the file before a length is a clean end
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	*e = Event{}
+	if len(b) == 0 {
+		return errSpillCorrupt
+	}
+	flags := b[0]
+	b = b[1:]
+	if e.hasHash = flags&spillHasHash != 0; e.hasHash {
+		if len(b) < hashSize {
+			return errSpillCorrupt
+		}
+		b = b[copy(e.hash[:], b):]
+	}
+	e.includePunctuation = flags&spillIncludePunctuation != 0
+
+	var n int
+	if e.indexTime, n = binary.Varint(b); n <= 0 {
+		return errSpillCorrupt
+	}
+	b = b[n:]
+	for _, v := range []*uint64{&e.subSeconds, &e.streamID, &e.streamOffset, &e.streamSubOffset, &e.metadataCount} {
+		if *v, n = binary.Uvarint(b); n <= 0 {
+			return errSpillCorrupt
+		}
+		b = b[n:]
+	}
+
+	bucketID, n := binary.Uvarint(b)
+	if n <= 0 {
+		return errSpillCorrupt
+	}
+	b = b[n:]
+	e.bucket = d.buckets.bucket(bucketID)
+
+	for _, s := range []*string{&e.host, &e.source, &e.sourceType} {
+		var v []byte
+		if v, b, err = readSpillBytes(b); err != nil {
+			return err
+		}
+		*s = d.intern(v)
+	}
+
+	msg, b, err := readSpillBytes(b)
+	if err != nil {
+		return err
+	}
+	e.message = append([]byte(nil), msg...)
+	e.messageLength = uint64(len(e.message))
+
+	fields, n := binary.Uvarint(b)
+	if n <= 0 {
+		return errSpillCorrupt
+	}
+	b = b[n:]
+	for i := uint64(0); i < fields; i++ {
+		var name, value []byte
+		if name, b, err = readSpillBytes(b); err != nil {
+			return err
+		}
+		if value, b, err = readSpillBytes(b); err != nil {
+			return err
+		}
+		e.fields = append(e.fields, Field{Name: d.intern(name), Value: string(value)})
+	}
+
+	return nil
+}
+
+// intern returns s as string, reusing the string of a previous call
+func (d *spillDecoder) intern(s []byte) string {
+	v, ok := d.strings[string(s)]
+	if !ok {
+		v = string(s)
+		d.strings[v] = v
+	}
+	return v
+}
+
+func readSpillBytes(b []byte) (v, rest []byte, err error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return nil, nil, errSpillCorrupt
+	}
+	return b[n : n+int(l)], b[n+int(l):], nil
+}
+
+// spillSorter sorts events by less. Up to limit events are kept in memory,
+// beyond that they are written as sorted runs to temporary files in dir.
+// The events passed to add are cloned.
+type spillSorter struct {
+	less    func(a, b *Event) bool
+	limit   int
+	dir     string
+	buckets *bucketTable
+
+	chunk []Event
+	// files are the names of the run files, they are only open while merging
+	files []string
+	buf   []byte
+}
+
+func newSpillSorter(less func(a, b *Event) bool, limit int, dir string, buckets *bucketTable) *spillSorter {
+	return &spillSorter{
+		less:    less,
+		limit:   max(limit, 1),
+		dir:     dir,
+		buckets: buckets,
+	}
+}
+
+func (s *spillSorter) add(e Event) error {
+	s.chunk = append(s.chunk, e.Clone())
+	if len(s.chunk) < s.limit {
+		return nil
+	}
+
+	return s.spill()
+}
+
+func (s *spillSorter) sortChunk() {
+	slices.SortStableFunc(s.chunk, func(a, b Event) int {
+		switch {
+		case s.less(&a, &b):
+			return -1
+		case s.less(&b, &a):
+			return 1
+		}
+		return 0
+	})
+}
+
+// spill writes the current chunk as sorted run to a temporary file
+func (s *spillSorter) spill() error {
+	s.sortChunk()
+
+	err := s.writeRun(func(w io.Writer) error {
+		for i := range s.chunk {
+			s.buf = appendSpillEvent(s.buf[:0], &s.chunk[i], s.buckets.id(s.chunk[i].bucket))
+			if _, err := w.Write(s.buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+
+	clear(s.chunk)
+	s.chunk = s.chunk[:0]
+
+	return nil
+}
+
+// writeRun creates a new run file and passes its writer to fn
+func (s *spillSorter) writeRun(fn func(w io.Writer) error) error {
+	f, err := os.CreateTemp(s.dir, "splunker-*.run")
+	if err != nil {
+		return err
+	}
+	// added right away to be removed by close on errors
+	s.files = append(s.files, f.Name())
+
+	w := bufio.NewWriterSize(f, 1<<20)
+	if err := fn(w); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// close removes the temporary files
+func (s *spillSorter) close() {
+	for _, name := range s.files {
+		_ = os.Remove(name)
+	}
+	s.files = nil
+	s.chunk = nil
+}
+
+// mergeFanIn is the maximum number of run files merged at once.
+// It bounds the number of open files while merging.
+var mergeFanIn = 64
+
+// mergeSorted merges the runs of all sorters into a single sorted sequence.
+// All sorters have to share the same less function and bucket table.
+// If there are more than mergeFanIn run files, they are first merged into
+// fewer, larger runs.
+func mergeSorted(less func(a, b *Event) bool, sorters ...*spillSorter) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		// the first sorter takes over the files of the others,
+		// so the intermediate runs are removed by its close
+		s := sorters[0]
+		for _, o := range sorters[1:] {
+			s.files = append(s.files, o.files...)
+			o.files = nil
+		}
+		for len(s.files) > mergeFanIn {
+			if err := s.mergeFiles(mergeFanIn); err != nil {
+				yield(Event{}, err)
+				return
+			}
+		}
+
+		var runs []*eventRun
+		defer func() {
+			for _, r := range runs {
+				r.close()
+			}
+		}()
+		for _, o := range sorters {
+			o.sortChunk()
+			if len(o.chunk) > 0 {
+				runs = append(runs, &eventRun{mem: o.chunk})
+			}
+		}
+		for _, name := range s.files {
+			r, err := s.openRun(name)
+			if err != nil {
+				yield(Event{}, err)
+				return
+			}
+			runs = append(runs, r)
+		}
+
+		var stopped bool
+		err := mergeRuns(less, runs, func(e *Event) error {
+			stopped = !yield(*e, nil)
+			if stopped {
+				return errStopMerge
+			}
+			return nil
+		})
+		if err != nil && !stopped {
+			yield(Event{}, err)
+		}
+	}
+}
+
+var errStopMerge = errors.New("merge stopped")
+
+// mergeFiles merges the first n run files into a new run file
+func (s *spillSorter) mergeFiles(n int) error {
+	runs := make([]*eventRun, 0, n)
+	defer func() {
+		for _, r := range runs {
+			r.close()
+		}
+	}()
+	for _, name := range s.files[:n] {
+		r, err := s.openRun(name)
+		if err != nil {
+			return fmt.Errorf("spill: %w", err)
+		}
+		runs = append(runs, r)
+	}
+
+	err := s.writeRun(func(w io.Writer) error {
+		return mergeRuns(s.less, runs, func(e *Event) error {
+			s.buf = appendSpillEvent(s.buf[:0], e, s.buckets.id(e.bucket))
+			_, err := w.Write(s.buf)
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range s.files[:n] {
+		_ = os.Remove(name)
+	}
+	s.files = s.files[n:]
+
+	return nil
+}
+
+// openRun opens the run file name
+func (s *spillSorter) openRun(name string) (*eventRun, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &eventRun{f: f, dec: &spillDecoder{
+		r:       bufio.NewReaderSize(f, 64*1024),
+		buckets: s.buckets,
+		strings: make(map[string]string),
+	}}, nil
+}
+
+// mergeRuns passes the events of runs to fn ordered by less.
+// The first error of fn is returned.
+func mergeRuns(less func(a, b *Event) bool, runs []*eventRun, fn func(e *Event) error) error {
+	h := &runHeap{less: less}
+
+	// fetch the first event of every run
+	for _, r := range runs {
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		r := h.runs[0]
+		if err := fn(&r.head); err != nil {
+			return err
+		}
+
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	return nil
+}
+
+// eventRun is a sorted run of events either in memory or in a file
+type eventRun struct {
+	head Event
+	mem  []Event
+	dec  *spillDecoder
+	f    *os.File
+}
+
+func (r *eventRun) close() {
+	if r.f != nil {
+		_ = r.f.Close()
+	}
+}
+
+func (r *eventRun) next() (bool, error) {
+	if r.dec == nil {
+		if len(r.mem) == 0 {
+			return false, nil
+		}
+		r.head, r.mem = r.mem[0], r.mem[1:]
+		return true, nil
+	}
+
+	if err := r.dec.decode(&r.head); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, fmt.Errorf("spill: %w", err)
+	}
+	return true, nil
+}
+
+type runHeap struct {
+	less func(a, b *Event) bool
+	runs []*eventRun
+}
+
+func (h *runHeap) Len() int           { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool { return h.less(&h.runs[i].head, &h.runs[j].head) }
+func (h *runHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x any)         { h.runs = append(h.runs, x.(*eventRun)) }
+
+func (h *runHeap) Pop() any {
+	r := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return r
+}
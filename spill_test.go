@@ -0,0 +1,155 @@
+package splunker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpillEventRoundTrip(t *testing.T) {
+	b := &Bucket{Path: "/data/web/db/db_2_1_0", Index: "web"}
+	e := Event{
+		hasHash:            true,
+		hash:               [hashSize]byte{1, 2, 3},
+		streamID:           42,
+		streamOffset:       1 << 40,
+		streamSubOffset:    7,
+		indexTime:          -5,
+		subSeconds:         123,
+		message:            []byte("hello world"),
+		messageLength:      11,
+		includePunctuation: true,
+		host:               "host",
+		source:             "/var/log/messages",
+		sourceType:         "syslog",
+		bucket:             b,
+		fields:             []Field{{"punct", "__"}, {"user", "root"}},
+	}
+
+	table := &bucketTable{}
+	var buf []byte
+	buf = appendSpillEvent(buf, &e, table.id(b))
+	buf = appendSpillEvent(buf, &Event{}, 0)
+
+	d := &spillDecoder{
+		r:       bufio.NewReader(bytes.NewReader(buf)),
+		buckets: table,
+		strings: make(map[string]string),
+	}
+
+	var got Event
+	require.NoError(t, d.decode(&got))
+	assert.Equal(t, e, got)
+
+	require.NoError(t, d.decode(&got))
+	assert.Nil(t, got.bucket)
+	assert.Empty(t, got.message)
+
+	assert.Equal(t, io.EOF, d.decode(&got))
+}
+
+func TestSpillDecodeCorrupt(t *testing.T) {
+	var full []byte
+	full = appendSpillEvent(full, &Event{hasHash: true, indexTime: 1 << 40, message: []byte("hello")}, 0)
+
+	tests := map[string][]byte{
+		"empty record":   {0},
+		"truncated hash": {2, spillHasHash, 1},
+		"truncated time": {2, 0, 0x80},
+		"truncated body": append([]byte{byte(len(full) - 4)}, full[1:len(full)-3]...),
+		"huge length":    {0xff, 0xff, 0xff, 0xff, 0x0f},
+	}
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := &spillDecoder{
+				r:       bufio.NewReader(bytes.NewReader(b)),
+				buckets: &bucketTable{},
+				strings: make(map[string]string),
+			}
+			assert.ErrorIs(t, d.decode(&Event{}), errSpillCorrupt)
+		})
+	}
+
+	// the file ends in the body, right after the length or in the length
+	for name, b := range map[string][]byte{
+		"in body":      full[:len(full)-3],
+		"after length": full[:1],
+		"in length":    {0x80},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := &spillDecoder{
+				r:       bufio.NewReader(bytes.NewReader(b)),
+				buckets: &bucketTable{},
+				strings: make(map[string]string),
+			}
+			assert.ErrorIs(t, d.decode(&Event{}), io.ErrUnexpectedEOF)
+		})
+	}
+}
+
+func TestEventRunTruncated(t *testing.T) {
+	var buf []byte
+	buf = appendSpillEvent(buf, &Event{message: []byte("first")}, 0)
+	l := len(buf)
+	buf = appendSpillEvent(buf, &Event{message: []byte("second")}, 0)
+
+	// a run cut right after the length of its second record
+	r := &eventRun{dec: &spillDecoder{
+		r:       bufio.NewReader(bytes.NewReader(buf[:l+1])),
+		buckets: &bucketTable{},
+		strings: make(map[string]string),
+	}}
+	ok, err := r.next()
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, err = r.next()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestMergeSortedFanIn(t *testing.T) {
+	defer func(n int) { mergeFanIn = n }(mergeFanIn)
+	mergeFanIn = 2
+
+	dir := t.TempDir()
+	less := func(a, b *Event) bool { return a.indexTime < b.indexTime }
+	buckets := &bucketTable{}
+	b := &Bucket{Index: "main"}
+	sorters := []*spillSorter{
+		newSpillSorter(less, 3, dir, buckets),
+		newSpillSorter(less, 4, dir, buckets),
+	}
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		// a permutation of 0..n-1
+		e := Event{indexTime: int64(i * 7 % n), bucket: b, message: []byte(fmt.Sprint(i * 7 % n))}
+		require.NoError(t, sorters[i%2].add(e))
+	}
+	require.Greater(t, len(sorters[0].files)+len(sorters[1].files), mergeFanIn)
+
+	var got []int64
+	for e, err := range mergeSorted(less, sorters...) {
+		require.NoError(t, err)
+		assert.Same(t, b, e.bucket)
+		assert.Equal(t, fmt.Sprint(e.indexTime), e.MessageString())
+		got = append(got, e.indexTime)
+	}
+	assert.LessOrEqual(t, len(sorters[0].files), mergeFanIn)
+	require.Len(t, got, n)
+	for i, v := range got {
+		assert.Equal(t, int64(i), v)
+	}
+
+	for _, s := range sorters {
+		s.close()
+	}
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
@@ -0,0 +1,366 @@
+package splunker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// journalVersion is written into the header of new journals
+const journalVersion = 1
+
+// Event opcodes used by the JournalWriter, both without extended storage
+// and punctuation and with the metadata keys stored unshifted.
+const (
+	opcodeEventWithHash Opcode = 40
+	opcodeEvent         Opcode = 41
+)
+
+// JournalWriter writes events as zstd compressed journal in the format
+// read by JournalDecoder.
+//
+// Host, source and sourcetype are written into their string tables
+// and activated with a state opcode when they change. Indexed fields are
+// stored as string metadata with name and value in the string table.
+type JournalWriter struct {
+	zw  *zstd.Encoder
+	buf []byte
+	// body is the part of an event after its length
+	body []byte
+	size int64
+
+	started  bool
+	baseTime int64
+	// tables map the entries of the string tables to their 1-based index
+	tables map[Opcode]map[string]uint64
+	active struct {
+		host, source, sourceType uint64
+	}
+}
+
+var _ Sink = (*JournalWriter)(nil)
+
+// NewJournalWriter creates a JournalWriter writing to w. Close does not close w.
+func NewJournalWriter(w io.Writer) (*JournalWriter, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("zstd.NewWriter: %v", err)
+	}
+
+	return &JournalWriter{
+		zw: zw,
+		tables: map[Opcode]map[string]uint64{
+			OpcodeNewHost:       {},
+			OpcodeNewSource:     {},
+			OpcodeNewSourceType: {},
+			OpcodeNewString:     {},
+		},
+	}, nil
+}
+
+// Size returns the number of uncompressed bytes written so far.
+func (jw *JournalWriter) Size() int64 {
+	return jw.size
+}
+
+// start appends the header and the base time, taken from the first event
+func (jw *JournalWriter) start(b []byte, e *Event) []byte {
+	jw.started = true
+	jw.baseTime = min(max(e.indexTime, math.MinInt32), math.MaxInt32)
+
+	b = append(b, byte(OpcodeHeader), journalVersion, 0)
+	b = binary.LittleEndian.AppendUint32(b, uint32(int32(jw.baseTime)))
+
+	// the decoder takes the base time from the state
+	b = append(b, 0x10|0x1)
+	return binary.LittleEndian.AppendUint32(b, uint32(int32(jw.baseTime)))
+}
+
+// intern returns the index of s in the table of o, appending the
+// entry for s to b if it is new. The empty string has index 0.
+func (jw *JournalWriter) intern(b []byte, o Opcode, s string) ([]byte, uint64) {
+	if s == "" {
+		return b, 0
+	}
+
+	t := jw.tables[o]
+	i, ok := t[s]
+	if !ok {
+		i = uint64(len(t) + 1)
+		t[s] = i
+
+		b = append(b, byte(o))
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+
+	return b, i
+}
+
+func (jw *JournalWriter) WriteEvent(e Event) error {
+	b := jw.buf[:0]
+	if !jw.started {
+		b = jw.start(b, &e)
+	}
+
+	var host, source, sourceType uint64
+	b, host = jw.intern(b, OpcodeNewHost, e.host)
+	b, source = jw.intern(b, OpcodeNewSource, e.source)
+	b, sourceType = jw.intern(b, OpcodeNewSourceType, e.sourceType)
+
+	var state byte
+	if host != jw.active.host {
+		state |= 0x8
+	}
+	if source != jw.active.source {
+		state |= 0x4
+	}
+	if sourceType != jw.active.sourceType {
+		state |= 0x2
+	}
+	if state != 0 {
+		b = append(b, 0x10|state)
+		if state&0x8 != 0 {
+			b = binary.AppendUvarint(b, host)
+		}
+		if state&0x4 != 0 {
+			b = binary.AppendUvarint(b, source)
+		}
+		if state&0x2 != 0 {
+			b = binary.AppendUvarint(b, sourceType)
+		}
+		jw.active.host, jw.active.source, jw.active.sourceType = host, source, sourceType
+	}
+
+	body := jw.body[:0]
+	o := opcodeEvent
+	if e.hasHash {
+		o = opcodeEventWithHash
+		body = append(body, e.hash[:]...)
+	}
+	body = binary.LittleEndian.AppendUint64(body, e.streamID)
+	body = binary.AppendUvarint(body, e.streamOffset)
+	body = binary.AppendUvarint(body, e.streamSubOffset)
+	body = binary.AppendVarint(body, e.indexTime-jw.baseTime)
+	body = binary.AppendUvarint(body, e.subSeconds)
+	body = binary.AppendUvarint(body, uint64(len(e.fields)))
+	for _, f := range e.fields {
+		var name, value uint64
+		b, name = jw.intern(b, OpcodeNewString, f.Name)
+		b, value = jw.intern(b, OpcodeNewString, f.Value)
+		// the lower bits of the key are the type, 0 is a string
+		body = binary.AppendUvarint(body, name<<4)
+		body = binary.AppendVarint(body, int64(value))
+	}
+	body = append(body, e.message...)
+
+	b = append(b, byte(o))
+	b = binary.AppendUvarint(b, uint64(len(body)))
+	b = append(b, body...)
+
+	jw.buf, jw.body = b, body
+	jw.size += int64(len(b))
+
+	_, err := jw.zw.Write(b)
+	return err
+}
+
+// Close flushes the compressed journal.
+func (jw *JournalWriter) Close() error {
+	return jw.zw.Close()
+}
+
+// IndexWriterOption configures an IndexWriter.
+type IndexWriterOption func(w *IndexWriter)
+
+// WithMaxBucketSize rolls the hot bucket once its uncompressed journal
+// reaches n bytes. Defaults to 750 MB like maxDataSize = auto.
+func WithMaxBucketSize(n int64) IndexWriterOption {
+	return func(w *IndexWriter) {
+		w.maxSize = n
+	}
+}
+
+// WithMaxBucketSpan rolls the hot bucket before the _time of its events
+// would span more than d, like maxHotSpanSecs. Defaults to 90 days.
+func WithMaxBucketSpan(d time.Duration) IndexWriterOption {
+	return func(w *IndexWriter) {
+		w.maxSpan = d
+	}
+}
+
+// WithMaxBucketAge rolls the hot bucket once it was created d ago.
+// It is checked when an event is written and by RollExpired.
+// Disabled by default.
+func WithMaxBucketAge(d time.Duration) IndexWriterOption {
+	return func(w *IndexWriter) {
+		w.maxAge = d
+	}
+}
+
+// IndexWriter writes events into the buckets of an index directory.
+// Events go into the hot bucket hot_v1_<id> which is renamed to
+// db_<newest>_<oldest>_<id> when it rolls.
+//
+// The buckets only contain rawdata/journal.zst, Splunk has to rebuild
+// them before they can be searched.
+type IndexWriter struct {
+	dir     string
+	maxSize int64
+	maxSpan time.Duration
+	maxAge  time.Duration
+	nextID  uint64
+
+	hot *hotBucket
+}
+
+var _ Sink = (*IndexWriter)(nil)
+
+type hotBucket struct {
+	id      uint64
+	path    string
+	f       *os.File
+	jw      *JournalWriter
+	created time.Time
+	events  int
+	oldest  int64
+	newest  int64
+}
+
+// NewIndexWriter writes into the index directory path. It is created if
+// it does not exist, new buckets get ids after the existing ones.
+func NewIndexWriter(path string, opts ...IndexWriterOption) (*IndexWriter, error) {
+	w := &IndexWriter{
+		dir:     filepath.Join(path, "db"),
+		maxSize: 750 << 20,
+		maxSpan: 90 * 24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	if err := os.MkdirAll(w.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	idx, err := OpenIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range idx.Buckets() {
+		w.nextID = max(w.nextID, b.ID+1)
+	}
+
+	return w, nil
+}
+
+func (w *IndexWriter) WriteEvent(e Event) error {
+	t := e.indexTime
+	if h := w.hot; h != nil && h.events > 0 {
+		span := time.Duration(max(h.newest, t)-min(h.oldest, t)) * time.Second
+		if h.jw.Size() >= w.maxSize || span > w.maxSpan ||
+			(w.maxAge > 0 && time.Since(h.created) >= w.maxAge) {
+			if err := w.Roll(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if w.hot == nil {
+		if err := w.openHot(); err != nil {
+			return err
+		}
+	}
+
+	h := w.hot
+	if err := h.jw.WriteEvent(e); err != nil {
+		return fmt.Errorf("%s: %w", h.path, err)
+	}
+
+	if h.events == 0 || t < h.oldest {
+		h.oldest = t
+	}
+	if h.events == 0 || t > h.newest {
+		h.newest = t
+	}
+	h.events++
+
+	return nil
+}
+
+func (w *IndexWriter) openHot() error {
+	id := w.nextID
+	path := filepath.Join(w.dir, "hot_v1_"+strconv.FormatUint(id, 10))
+	if err := os.MkdirAll(filepath.Join(path, "rawdata"), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(path, "rawdata", "journal.zst"))
+	if err != nil {
+		return err
+	}
+
+	jw, err := NewJournalWriter(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	w.nextID++
+	w.hot = &hotBucket{
+		id:      id,
+		path:    path,
+		f:       f,
+		jw:      jw,
+		created: time.Now(),
+	}
+
+	return nil
+}
+
+// Roll closes the hot bucket and renames it to a warm bucket.
+// The next event opens a new hot bucket.
+func (w *IndexWriter) Roll() error {
+	h := w.hot
+	if h == nil {
+		return nil
+	}
+	w.hot = nil
+
+	err := h.jw.Close()
+	if cerr := h.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.path, err)
+	}
+
+	if h.events == 0 {
+		return os.RemoveAll(h.path)
+	}
+
+	name := fmt.Sprintf("db_%d_%d_%d", h.newest, h.oldest, h.id)
+	return os.Rename(h.path, filepath.Join(w.dir, name))
+}
+
+// RollExpired rolls the hot bucket if it is older than the maximum age,
+// see WithMaxBucketAge. Writers that may be idle for long call it
+// periodically, as the age is otherwise only checked for new events.
+func (w *IndexWriter) RollExpired() error {
+	if h := w.hot; h != nil && w.maxAge > 0 && time.Since(h.created) >= w.maxAge {
+		return w.Roll()
+	}
+	return nil
+}
+
+// Close rolls the hot bucket.
+func (w *IndexWriter) Close() error {
+	return w.Roll()
+}
@@ -0,0 +1,86 @@
+package splunker
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexWriter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "web")
+
+	var in []EventInfo
+	for i := 0; i < 100; i++ {
+		info := EventInfo{
+			Time:         time.Unix(1700000000+int64(i), int64(i)*int64(time.Millisecond)),
+			Host:         fmt.Sprintf("web%02d", i%3),
+			Source:       "/var/log/app.log",
+			SourceType:   "app",
+			Raw:          []byte(fmt.Sprintf("event %d", i)),
+			StreamID:     7,
+			StreamOffset: uint64(i) * 10,
+		}
+		if i%2 == 0 {
+			info.HasHash = true
+			info.Hash[0] = byte(i)
+		}
+		if i%5 == 0 {
+			info.IndexedFields = []Field{{Name: "env", Value: "prod"}, {Name: "n", Value: fmt.Sprint(i)}}
+		}
+		in = append(in, info)
+	}
+
+	w, err := NewIndexWriter(dir, WithMaxBucketSize(1000))
+	require.NoError(t, err)
+	for _, info := range in {
+		require.NoError(t, w.WriteEvent(NewEvent(info)))
+	}
+	require.NoError(t, w.Close())
+
+	idx, err := OpenIndex(dir)
+	require.NoError(t, err)
+	buckets := idx.Buckets()
+	require.Greater(t, len(buckets), 1)
+	assert.Equal(t, BucketStateWarm, buckets[0].State)
+	assert.Equal(t, time.Unix(1700000000, 0), buckets[0].Oldest)
+
+	i := 0
+	for e, err := range idx.All() {
+		require.NoError(t, err)
+		require.Less(t, i, len(in))
+
+		want := in[i]
+		assert.Equal(t, want.Time, e.Time())
+		assert.Equal(t, want.Host, e.Host())
+		assert.Equal(t, want.Source, e.Source())
+		assert.Equal(t, want.SourceType, e.SourceType())
+		assert.Equal(t, string(want.Raw), e.MessageString())
+		assert.Equal(t, want.StreamOffset, e.StreamOffset())
+		hash, ok := e.Hash()
+		if assert.Equal(t, want.HasHash, ok) && ok {
+			assert.Equal(t, want.Hash, hash)
+		}
+		if want.IndexedFields != nil {
+			assert.Equal(t, want.IndexedFields, e.IndexedFields())
+		} else {
+			assert.Empty(t, e.IndexedFields())
+		}
+		i++
+	}
+	assert.Equal(t, len(in), i)
+
+	// a new writer continues after the existing buckets
+	w, err = NewIndexWriter(dir)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteEvent(NewEvent(in[0])))
+	require.NoError(t, w.Close())
+
+	idx, err = OpenIndex(dir)
+	require.NoError(t, err)
+	assert.Len(t, idx.Buckets(), len(buckets)+1)
+	assert.Equal(t, uint64(len(buckets)), idx.Buckets()[len(buckets)].ID)
+}